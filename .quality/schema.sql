@@ -9,6 +9,14 @@ CREATE TABLE IF NOT EXISTS queue (
   done_at TEXT,
   result TEXT,
   next_at TEXT,
+  claimed_at TEXT,
+  leased_until TEXT,
+  failed_at TEXT,
+  error TEXT,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  version TEXT,
+  result_json TEXT,
+  worker TEXT,
   PRIMARY KEY (path, treatment)
 );
 
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/dkoosis/next/client"
+	"github.com/dkoosis/next/ledger"
+)
+
+// The commands speak in the ledger's types, which are also the client's
+// wire types.
+type (
+	enqueueItem  = ledger.Item
+	claimedItem  = ledger.Claimed
+	claimOptions = ledger.ClaimOptions
+	doneOptions  = ledger.CompleteOptions
+	failOptions  = ledger.FailOptions
+	statusRow    = ledger.Stats
+	versionRow   = ledger.VersionCount
+	listOptions  = ledger.ListOptions
+	entry        = ledger.Entry
+)
+
+// queueAPI is what the commands need from a ledger. localQueue runs against
+// the SQLite file; remoteQueue calls a `next serve` endpoint, so shell worker
+// loops behave the same either way.
+type queueAPI interface {
+	Enqueue(treatment string, items []enqueueItem) (int, error)
+	Reopen(treatment string, items []enqueueItem) (int, error)
+	Claim(opts claimOptions) ([]claimedItem, error)
+	Done(opts doneOptions) (int64, error)
+	Fail(opts failOptions) (int64, error)
+	Status(treatment string) ([]statusRow, error)
+	Versions(treatment string) ([]versionRow, error)
+	List(opts listOptions) ([]entry, error)
+	Aggregate(treatment, agg string) ([]client.AggregateRow, error)
+	Reset(treatment string) (int64, error)
+	Snapshots() ([]client.Snapshot, error)
+	Undo(snapshot string) error
+	Metrics() ([]byte, error)
+	Treatments() ([]client.Treatment, error)
+	History(treatment, path string) ([]client.Run, error)
+	Findings(q client.FindingsRequest) ([]client.Finding, error)
+	Audit(q client.AuditRequest) ([]client.AuditEntry, error)
+	Workers(opts ledger.WorkersOptions) ([]client.Worker, error)
+	Costs(treatment string) ([]client.CostRow, error)
+	PutArtifact(r io.Reader) (string, error)
+	OpenArtifact(hash string) (io.ReadCloser, error)
+	Close() error
+}
+
+// serverFlag registers --server, defaulting to $NEXT_SERVER.
+func serverFlag(fs *flag.FlagSet) *string {
+	return fs.String("server", os.Getenv("NEXT_SERVER"), "next serve endpoint (http://host:port or unix:/path); default $NEXT_SERVER")
+}
+
+// workerFlag registers --worker, defaulting to $NEXT_WORKER or else the
+// hostname and pid: claim passes its parent's pid so every claim from one
+// shell worker loop shares an id, run its own.
+func workerFlag(fs *flag.FlagSet, pid int) *string {
+	def := os.Getenv("NEXT_WORKER")
+	if def == "" {
+		host, _ := os.Hostname()
+		def = fmt.Sprintf("%s:%d", host, pid)
+	}
+	return fs.String("worker", def, "worker id recorded with claims, shown by `next workers`; default $NEXT_WORKER or host:pid")
+}
+
+// openQueue returns a remote queue when server is set and the local ledger at
+// dbPath otherwise.
+func openQueue(dbPath, server string) (queueAPI, error) {
+	if server != "" {
+		c, err := client.New(server)
+		if err != nil {
+			return nil, err
+		}
+		return remoteQueue{c: c}, nil
+	}
+	l, err := openLedger(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return localQueue{l: l}, nil
+}
+
+// openLedger opens the ledger file at dbPath.
+func openLedger(dbPath string) (*ledger.Ledger, error) {
+	db, err := openDB(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("db error: %w", err)
+	}
+	l, err := newLedger(db, dbPath)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// newLedger wraps db with the snapshot retention set by $NEXT_SNAPSHOT_KEEP.
+func newLedger(db *sql.DB, dbPath string) (*ledger.Ledger, error) {
+	keep, err := snapshotKeep()
+	if err != nil {
+		return nil, err
+	}
+	return ledger.New(db, dbPath, ledger.Options{SnapshotKeep: keep})
+}
+
+// localQueue runs the commands against a ledger file. Commands have no
+// cancellation of their own, so every call uses a background context.
+type localQueue struct {
+	l *ledger.Ledger
+}
+
+func (q localQueue) Enqueue(treatment string, items []enqueueItem) (int, error) {
+	return q.l.Enqueue(context.Background(), treatment, items)
+}
+
+func (q localQueue) Reopen(treatment string, items []enqueueItem) (int, error) {
+	return q.l.Reopen(context.Background(), treatment, items)
+}
+
+func (q localQueue) Claim(opts claimOptions) ([]claimedItem, error) {
+	return q.l.Claim(context.Background(), opts)
+}
+
+func (q localQueue) Done(opts doneOptions) (int64, error) {
+	return q.l.Complete(context.Background(), opts)
+}
+
+func (q localQueue) Fail(opts failOptions) (int64, error) {
+	return q.l.Fail(context.Background(), opts)
+}
+
+func (q localQueue) Status(treatment string) ([]statusRow, error) {
+	return q.l.Stats(context.Background(), treatment)
+}
+
+func (q localQueue) Versions(treatment string) ([]versionRow, error) {
+	return q.l.Versions(context.Background(), treatment)
+}
+
+func (q localQueue) List(opts listOptions) ([]entry, error) {
+	return q.l.List(context.Background(), opts)
+}
+
+func (q localQueue) Aggregate(treatment, agg string) ([]client.AggregateRow, error) {
+	return q.l.Aggregate(context.Background(), treatment, agg)
+}
+
+func (q localQueue) Treatments() ([]client.Treatment, error) {
+	return q.l.Treatments(context.Background())
+}
+
+func (q localQueue) Reset(treatment string) (int64, error) {
+	return q.l.Reset(context.Background(), treatment)
+}
+
+func (q localQueue) Snapshots() ([]client.Snapshot, error) {
+	return q.l.Snapshots(context.Background())
+}
+
+func (q localQueue) Undo(snapshot string) error {
+	return q.l.Undo(context.Background(), snapshot)
+}
+
+func (q localQueue) Metrics() ([]byte, error) {
+	var buf bytes.Buffer
+	err := q.l.WriteMetrics(context.Background(), &buf)
+	return buf.Bytes(), err
+}
+
+func (q localQueue) History(treatment, path string) ([]client.Run, error) {
+	return q.l.History(context.Background(), treatment, path)
+}
+
+func (q localQueue) Findings(fq client.FindingsRequest) ([]client.Finding, error) {
+	return q.l.Findings(context.Background(), fq)
+}
+
+func (q localQueue) Audit(aq client.AuditRequest) ([]client.AuditEntry, error) {
+	return q.l.AuditLog(context.Background(), aq)
+}
+
+func (q localQueue) Workers(opts ledger.WorkersOptions) ([]client.Worker, error) {
+	return q.l.Workers(context.Background(), opts)
+}
+
+func (q localQueue) Costs(treatment string) ([]client.CostRow, error) {
+	return q.l.Costs(context.Background(), treatment)
+}
+
+func (q localQueue) PutArtifact(r io.Reader) (string, error) {
+	return q.l.PutArtifact(context.Background(), r)
+}
+
+func (q localQueue) OpenArtifact(hash string) (io.ReadCloser, error) {
+	return q.l.OpenArtifact(context.Background(), hash)
+}
+
+func (q localQueue) Close() error {
+	return q.l.Close()
+}
+
+type remoteQueue struct {
+	c *client.Client
+}
+
+func (q remoteQueue) Enqueue(treatment string, items []enqueueItem) (int, error) {
+	return q.c.Enqueue(context.Background(), treatment, items)
+}
+
+func (q remoteQueue) Reopen(treatment string, items []enqueueItem) (int, error) {
+	return q.c.Reopen(context.Background(), treatment, items)
+}
+
+func (q remoteQueue) Claim(opts claimOptions) ([]claimedItem, error) {
+	req := client.ClaimRequest{Treatment: opts.Treatment, Cursor: opts.Cursor, N: opts.N, Version: opts.Version, Worker: opts.Worker}
+	if opts.Lease > 0 {
+		req.Lease = opts.Lease.String()
+	}
+	if opts.Wait > 0 {
+		req.Wait = opts.Wait.String()
+	}
+	items, err := q.c.Claim(context.Background(), req)
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusPaymentRequired {
+		err = budgetError{apiErr}
+	}
+	return items, err
+}
+
+// budgetError is a server's refusal to claim past a spent budget; it matches
+// ledger.ErrBudgetExhausted so claim exits the same way against either
+// backend.
+type budgetError struct{ err *client.Error }
+
+func (e budgetError) Error() string        { return e.err.Error() }
+func (e budgetError) Unwrap() error        { return e.err }
+func (e budgetError) Is(target error) bool { return target == ledger.ErrBudgetExhausted }
+
+func (q remoteQueue) Done(opts doneOptions) (int64, error) {
+	return q.c.Done(context.Background(), opts)
+}
+
+func (q remoteQueue) Fail(opts failOptions) (int64, error) {
+	return q.c.Fail(context.Background(), opts)
+}
+
+func (q remoteQueue) Status(treatment string) ([]statusRow, error) {
+	return q.c.Status(context.Background(), treatment)
+}
+
+func (q remoteQueue) Versions(treatment string) ([]versionRow, error) {
+	return q.c.Versions(context.Background(), treatment)
+}
+
+func (q remoteQueue) List(opts listOptions) ([]entry, error) {
+	return q.c.List(context.Background(), opts)
+}
+
+func (q remoteQueue) Aggregate(treatment, agg string) ([]client.AggregateRow, error) {
+	return q.c.Aggregate(context.Background(), treatment, agg)
+}
+
+func (q remoteQueue) Reset(treatment string) (int64, error) {
+	return q.c.Reset(context.Background(), treatment)
+}
+
+func (q remoteQueue) Snapshots() ([]client.Snapshot, error) {
+	return q.c.Snapshots(context.Background())
+}
+
+func (q remoteQueue) Undo(snapshot string) error {
+	return q.c.Undo(context.Background(), snapshot)
+}
+
+func (q remoteQueue) Metrics() ([]byte, error) {
+	return q.c.Metrics(context.Background())
+}
+
+func (q remoteQueue) Treatments() ([]client.Treatment, error) {
+	return q.c.Treatments(context.Background())
+}
+
+func (q remoteQueue) History(treatment, path string) ([]client.Run, error) {
+	return q.c.History(context.Background(), treatment, path)
+}
+
+func (q remoteQueue) Findings(fq client.FindingsRequest) ([]client.Finding, error) {
+	return q.c.Findings(context.Background(), fq)
+}
+
+func (q remoteQueue) Audit(aq client.AuditRequest) ([]client.AuditEntry, error) {
+	return q.c.Audit(context.Background(), aq)
+}
+
+func (q remoteQueue) Workers(opts ledger.WorkersOptions) ([]client.Worker, error) {
+	req := client.WorkersRequest{Treatment: opts.Treatment}
+	if opts.Window > 0 {
+		req.Window = opts.Window.String()
+	}
+	if opts.Stale > 0 {
+		req.Stale = opts.Stale.String()
+	}
+	return q.c.Workers(context.Background(), req)
+}
+
+func (q remoteQueue) Costs(treatment string) ([]client.CostRow, error) {
+	return q.c.Costs(context.Background(), treatment)
+}
+
+func (q remoteQueue) PutArtifact(r io.Reader) (string, error) {
+	return q.c.PutArtifact(context.Background(), r)
+}
+
+func (q remoteQueue) OpenArtifact(hash string) (io.ReadCloser, error) {
+	return q.c.Artifact(context.Background(), hash)
+}
+
+func (q remoteQueue) Close() error { return nil }
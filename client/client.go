@@ -0,0 +1,429 @@
+// Package client talks to a `next serve` endpoint. It defines the JSON
+// request and response envelopes and a small HTTP client over them; the
+// rows they carry are the ledger package's types, re-exported under their
+// wire names.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dkoosis/next/ledger"
+)
+
+// The ledger's types under the names the wire protocol uses.
+type (
+	EnqueueItem     = ledger.Item
+	ClaimedItem     = ledger.Claimed
+	DoneRequest     = ledger.CompleteOptions
+	FailRequest     = ledger.FailOptions
+	StatusRow       = ledger.Stats
+	VersionRow      = ledger.VersionCount
+	ListRequest     = ledger.ListOptions
+	Entry           = ledger.Entry
+	Run             = ledger.Run
+	Finding         = ledger.Finding
+	FindingsRequest = ledger.FindingsQuery
+	AggregateRow    = ledger.AggregateRow
+	Actor           = ledger.Actor
+	AuditEntry      = ledger.AuditEntry
+	AuditRequest    = ledger.AuditQuery
+	Worker          = ledger.WorkerStats
+	TreatmentDef    = ledger.TreatmentDef
+	RetryPolicy     = ledger.RetryPolicy
+	RateLimit       = ledger.RateLimit
+	Budget          = ledger.Budget
+	CostRow         = ledger.CostRow
+	Treatment       = ledger.Treatment
+	Snapshot        = ledger.Snapshot
+)
+
+type EnqueueRequest struct {
+	Treatment string        `json:"treatment"`
+	Items     []EnqueueItem `json:"items"`
+	// Reopen also resets rows whose content hash changed to pending, and the
+	// response counts only rows added or reset.
+	Reopen bool `json:"reopen,omitempty"`
+}
+
+type EnqueueResponse struct {
+	Enqueued int `json:"enqueued"`
+}
+
+// ClaimRequest asks for up to N claimable paths after Cursor. Lease is a Go
+// duration string such as "10m"; empty means no lease. Wait, also a duration,
+// makes the server hold the request until something is claimable or the
+// wait elapses; an empty response then means the wait timed out.
+type ClaimRequest struct {
+	Treatment string `json:"treatment"`
+	Cursor    string `json:"cursor,omitempty"`
+	N         int    `json:"n,omitempty"`
+	Lease     string `json:"lease,omitempty"`
+	Wait      string `json:"wait,omitempty"`
+	// Version overrides the treatment's defined version: done results
+	// recorded under any other version are claimable again.
+	Version string `json:"version,omitempty"`
+	// Worker identifies the claimer; its runs are attributed to it.
+	Worker string `json:"worker,omitempty"`
+}
+
+type ClaimResponse struct {
+	Items []ClaimedItem `json:"items"`
+}
+
+type ResetRequest struct {
+	Treatment string `json:"treatment"`
+}
+
+type UndoRequest struct {
+	Snapshot string `json:"snapshot"`
+}
+
+// UpdateResponse reports how many rows a mutation touched.
+type UpdateResponse struct {
+	Updated int64 `json:"updated"`
+}
+
+type StatusResponse struct {
+	Treatments []StatusRow `json:"treatments"`
+}
+
+type VersionsResponse struct {
+	Versions []VersionRow `json:"versions"`
+}
+
+type ListResponse struct {
+	Entries []Entry `json:"entries"`
+}
+
+type AggregateResponse struct {
+	Rows []AggregateRow `json:"rows"`
+}
+
+type FindingsResponse struct {
+	Findings []Finding `json:"findings"`
+}
+
+type HistoryResponse struct {
+	Runs []Run `json:"runs"`
+}
+
+type AuditResponse struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+// WorkersRequest selects workers by treatment. Window and Stale are Go
+// durations; empty uses the server's defaults.
+type WorkersRequest struct {
+	Treatment string
+	Window    string
+	Stale     string
+}
+
+type WorkersResponse struct {
+	Workers []Worker `json:"workers"`
+}
+
+type ArtifactResponse struct {
+	Artifact string `json:"artifact"`
+}
+
+type CostsResponse struct {
+	Costs []CostRow `json:"costs"`
+}
+
+type TreatmentsResponse struct {
+	Treatments []Treatment `json:"treatments"`
+}
+
+type SnapshotsResponse struct {
+	Snapshots []Snapshot `json:"snapshots"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Error is returned for non-2xx responses.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("server: %s (HTTP %d)", e.Message, e.StatusCode)
+}
+
+// Client calls a next server. The zero value is not usable; use New.
+type Client struct {
+	base  string
+	http  *http.Client
+	actor Actor
+}
+
+// New returns a client for server, which is either an http(s) URL or
+// "unix:/path/to.sock".
+func New(server string) (*Client, error) {
+	if sock, ok := strings.CutPrefix(server, "unix:"); ok {
+		var d net.Dialer
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return d.DialContext(ctx, "unix", sock)
+			},
+		}
+		return &Client{base: "http://unix", http: &http.Client{Transport: transport}, actor: ledger.CurrentActor()}, nil
+	}
+	u, err := url.Parse(server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid server %q: want http://host:port or unix:/path", server)
+	}
+	return &Client{base: strings.TrimRight(server, "/"), http: http.DefaultClient, actor: ledger.CurrentActor()}, nil
+}
+
+// Actor headers carry the calling process's identity.
+const (
+	headerUser    = "X-Next-User"
+	headerHost    = "X-Next-Host"
+	headerPID     = "X-Next-Pid"
+	headerCmdline = "X-Next-Cmdline"
+)
+
+// ActorFromHeader reads the actor a client sent; missing headers leave
+// fields empty.
+func ActorFromHeader(h http.Header) Actor {
+	pid, _ := strconv.Atoi(h.Get(headerPID))
+	return Actor{User: h.Get(headerUser), Host: h.Get(headerHost), PID: pid, Cmdline: h.Get(headerCmdline)}
+}
+
+func (c *Client) Enqueue(ctx context.Context, treatment string, items []EnqueueItem) (int, error) {
+	var resp EnqueueResponse
+	err := c.do(ctx, http.MethodPost, "/api/enqueue", EnqueueRequest{Treatment: treatment, Items: items}, &resp)
+	return resp.Enqueued, err
+}
+
+// Reopen enqueues items, resetting rows whose content changed to pending.
+func (c *Client) Reopen(ctx context.Context, treatment string, items []EnqueueItem) (int, error) {
+	var resp EnqueueResponse
+	err := c.do(ctx, http.MethodPost, "/api/enqueue", EnqueueRequest{Treatment: treatment, Items: items, Reopen: true}, &resp)
+	return resp.Enqueued, err
+}
+
+func (c *Client) Claim(ctx context.Context, req ClaimRequest) ([]ClaimedItem, error) {
+	var resp ClaimResponse
+	err := c.do(ctx, http.MethodPost, "/api/claim", req, &resp)
+	return resp.Items, err
+}
+
+func (c *Client) Done(ctx context.Context, req DoneRequest) (int64, error) {
+	var resp UpdateResponse
+	err := c.do(ctx, http.MethodPost, "/api/done", req, &resp)
+	return resp.Updated, err
+}
+
+func (c *Client) Fail(ctx context.Context, req FailRequest) (int64, error) {
+	var resp UpdateResponse
+	err := c.do(ctx, http.MethodPost, "/api/fail", req, &resp)
+	return resp.Updated, err
+}
+
+func (c *Client) Status(ctx context.Context, treatment string) ([]StatusRow, error) {
+	var resp StatusResponse
+	err := c.do(ctx, http.MethodGet, "/api/status?"+url.Values{"treatment": {treatment}}.Encode(), nil, &resp)
+	return resp.Treatments, err
+}
+
+func (c *Client) List(ctx context.Context, req ListRequest) ([]Entry, error) {
+	q := url.Values{"treatment": {req.Treatment}, "state": {req.State}}
+	if req.Limit > 0 {
+		q.Set("limit", strconv.Itoa(req.Limit))
+	}
+	if req.Newest {
+		q.Set("newest", "1")
+	}
+	if req.Where != "" {
+		q.Set("where", req.Where)
+	}
+	var resp ListResponse
+	err := c.do(ctx, http.MethodGet, "/api/list?"+q.Encode(), nil, &resp)
+	return resp.Entries, err
+}
+
+// Treatments lists the server's treatment definitions with their counts.
+func (c *Client) Treatments(ctx context.Context) ([]Treatment, error) {
+	var resp TreatmentsResponse
+	err := c.do(ctx, http.MethodGet, "/api/treatments", nil, &resp)
+	return resp.Treatments, err
+}
+
+// Versions counts done results per treatment version.
+func (c *Client) Versions(ctx context.Context, treatment string) ([]VersionRow, error) {
+	var resp VersionsResponse
+	err := c.do(ctx, http.MethodGet, "/api/versions?"+url.Values{"treatment": {treatment}}.Encode(), nil, &resp)
+	return resp.Versions, err
+}
+
+// Reset deletes every entry for treatment. The server snapshots first.
+func (c *Client) Reset(ctx context.Context, treatment string) (int64, error) {
+	var resp UpdateResponse
+	err := c.do(ctx, http.MethodPost, "/api/reset", ResetRequest{Treatment: treatment}, &resp)
+	return resp.Updated, err
+}
+
+// Snapshots lists the server's snapshots, oldest first.
+func (c *Client) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	var resp SnapshotsResponse
+	err := c.do(ctx, http.MethodGet, "/api/snapshots", nil, &resp)
+	return resp.Snapshots, err
+}
+
+// Undo restores the named snapshot on the server and removes it.
+func (c *Client) Undo(ctx context.Context, snapshot string) error {
+	return c.do(ctx, http.MethodPost, "/api/undo", UndoRequest{Snapshot: snapshot}, &UpdateResponse{})
+}
+
+// History returns the runs recorded for one path, newest first.
+func (c *Client) History(ctx context.Context, treatment, path string) ([]Run, error) {
+	var resp HistoryResponse
+	q := url.Values{"treatment": {treatment}, "path": {path}}
+	err := c.do(ctx, http.MethodGet, "/api/history?"+q.Encode(), nil, &resp)
+	return resp.Runs, err
+}
+
+// Aggregate computes agg, such as "avg($.score)", per treatment over done
+// rows' result JSON.
+func (c *Client) Aggregate(ctx context.Context, treatment, agg string) ([]AggregateRow, error) {
+	var resp AggregateResponse
+	q := url.Values{"treatment": {treatment}, "agg": {agg}}
+	err := c.do(ctx, http.MethodGet, "/api/aggregate?"+q.Encode(), nil, &resp)
+	return resp.Rows, err
+}
+
+// Findings returns the current findings matching req, ordered by path and
+// line.
+func (c *Client) Findings(ctx context.Context, req FindingsRequest) ([]Finding, error) {
+	var resp FindingsResponse
+	q := url.Values{"treatment": {req.Treatment}, "rule": {req.Rule}, "path": {req.Path}}
+	if req.Previous {
+		q.Set("previous", "1")
+	}
+	err := c.do(ctx, http.MethodGet, "/api/findings?"+q.Encode(), nil, &resp)
+	return resp.Findings, err
+}
+
+// Costs totals recorded spend per treatment and day, or for one treatment
+// when non-empty.
+func (c *Client) Costs(ctx context.Context, treatment string) ([]CostRow, error) {
+	var resp CostsResponse
+	err := c.do(ctx, http.MethodGet, "/api/costs?"+url.Values{"treatment": {treatment}}.Encode(), nil, &resp)
+	return resp.Costs, err
+}
+
+// Workers summarizes each worker's claims and recent throughput.
+func (c *Client) Workers(ctx context.Context, req WorkersRequest) ([]Worker, error) {
+	var resp WorkersResponse
+	q := url.Values{"treatment": {req.Treatment}, "window": {req.Window}, "stale": {req.Stale}}
+	err := c.do(ctx, http.MethodGet, "/api/workers?"+q.Encode(), nil, &resp)
+	return resp.Workers, err
+}
+
+// Audit returns the audit entries matching req, oldest first.
+func (c *Client) Audit(ctx context.Context, req AuditRequest) ([]AuditEntry, error) {
+	q := url.Values{"since": {req.Since}, "command": {req.Command}}
+	if req.Limit > 0 {
+		q.Set("limit", strconv.Itoa(req.Limit))
+	}
+	var resp AuditResponse
+	err := c.do(ctx, http.MethodGet, "/api/audit?"+q.Encode(), nil, &resp)
+	return resp.Entries, err
+}
+
+// PutArtifact uploads an output blob and returns its sha256, which Done and
+// Fail accept as Artifact.
+func (c *Client) PutArtifact(ctx context.Context, r io.Reader) (string, error) {
+	var resp ArtifactResponse
+	err := c.do(ctx, http.MethodPost, "/api/artifacts", r, &resp)
+	return resp.Artifact, err
+}
+
+// Artifact streams a stored blob. The caller closes it.
+func (c *Client) Artifact(ctx context.Context, hash string) (io.ReadCloser, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/api/artifacts/"+url.PathEscape(hash), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Metrics returns the server's Prometheus text exposition.
+func (c *Client) Metrics(ctx context.Context) ([]byte, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return io.ReadAll(resp.Body)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// send performs a request and turns non-2xx responses into *Error. A body
+// that is an io.Reader is sent as is; any other body is encoded as JSON. On
+// success the caller owns resp.Body.
+func (c *Client) send(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var reqBody io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reqBody, contentType = b, "application/octet-stream"
+	default:
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set(headerUser, c.actor.User)
+	req.Header.Set(headerHost, c.actor.Host)
+	req.Header.Set(headerPID, strconv.Itoa(c.actor.PID))
+	req.Header.Set(headerCmdline, c.actor.Cmdline)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer func() { _ = resp.Body.Close() }()
+		var e ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			e.Error = http.StatusText(resp.StatusCode)
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Message: e.Error}
+	}
+	return resp, nil
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestNew_RejectsBadServer(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []string{"", "localhost:7070", "ftp://host", "http://"} {
+		if _, err := New(s); err == nil {
+			t.Errorf("New(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestClient_ReturnsError_When_ServerRejects(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"path required"}`))
+	}))
+	defer srv.Close()
+
+	c, err := New(srv.URL)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	_, err = c.Done(context.Background(), DoneRequest{})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *Error", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != "path required" {
+		t.Fatalf("err = %+v", apiErr)
+	}
+}
+
+func TestClient_DialsUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	sock := filepath.Join(t.TempDir(), "next.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := &httptest.Server{
+		Listener: ln,
+		Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/status" || r.URL.Query().Get("treatment") != "lint" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(`{"treatments":[{"treatment":"lint","pending":3}]}`))
+		})},
+	}
+	srv.Start()
+	defer srv.Close()
+
+	c, err := New("unix:" + sock)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	rows, err := c.Status(context.Background(), "lint")
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(rows) != 1 || rows[0].Pending != 3 {
+		t.Fatalf("rows = %+v", rows)
+	}
+}
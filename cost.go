@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/dkoosis/next/client"
+)
+
+// exitBudgetExhausted is claim's and run's exit status once the treatment's
+// budget is spent, so a worker loop stops instead of retrying.
+const exitBudgetExhausted = 4
+
+// spendFlags registers --cost and --tokens, the spend done and fail record
+// with the run.
+func spendFlags(fs *flag.FlagSet) (cost *float64, tokens *int64) {
+	cost = fs.Float64("cost", 0, "what the run cost, e.g. an LLM call's price, for status --cost and budgets")
+	tokens = fs.Int64("tokens", 0, "tokens the run used, for status --cost and budgets")
+	return cost, tokens
+}
+
+// printCosts adds the recorded spend per treatment and day, each treatment
+// followed by its total.
+func printCosts(rows []client.CostRow) {
+	fmt.Printf("\n%-20s %-10s %8s %12s %12s\n", "TREATMENT", "DAY", "RUNS", "TOKENS", "COST")
+	var total client.CostRow
+	for i, r := range rows {
+		fmt.Printf("%-20s %-10s %8d %12d %12.4f\n", r.Treatment, r.Day, r.Runs, r.Tokens, r.Cost)
+		total.Runs += r.Runs
+		total.Tokens += r.Tokens
+		total.Cost += r.Cost
+		if i == len(rows)-1 || rows[i+1].Treatment != r.Treatment {
+			fmt.Printf("%-20s %-10s %8d %12d %12.4f\n", r.Treatment, "total", total.Runs, total.Tokens, total.Cost)
+			total = client.CostRow{}
+		}
+	}
+}
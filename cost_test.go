@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dkoosis/next/client"
+	"github.com/dkoosis/next/ledger"
+)
+
+func TestStatusCmd_ShowsCosts_When_RunsRecordSpend(t *testing.T) {
+	l, db := newTestLedger(t, "")
+	mustEnqueue(t, db, "review", "/src/a.go", "/src/b.go")
+
+	runCmd(t, doneCmd, "done", "--db", l.Path(), "--treatment", "review", "--path", "/src/a.go", "--cost", "0.0123", "--tokens", "4500")
+	runCmd(t, failCmd, "fail", "--db", l.Path(), "--treatment", "review", "--path", "/src/b.go", "--cost", "0.002", "--tokens", "500")
+
+	out := runCmd(t, statusCmd, "status", "--db", l.Path(), "--cost")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	last := strings.Fields(lines[len(lines)-1])
+	if want := []string{"review", "total", "2", "5000", "0.0143"}; strings.Join(last, " ") != strings.Join(want, " ") {
+		t.Fatalf("total line = %v, want %v in:\n%s", last, want, out)
+	}
+	if out := runCmd(t, statusCmd, "status", "--db", l.Path()); strings.Contains(out, "COST") {
+		t.Fatalf("status without --cost shows costs:\n%s", out)
+	}
+}
+
+func TestClaimCmd_ReturnsBudgetExhausted_When_BudgetSpent(t *testing.T) {
+	l, db := newTestLedger(t, `{"treatments": {"llm": {"budget": {"tokens": 4000, "per": "24h"}}}}`)
+	mustEnqueue(t, db, "llm", "/src/a.go", "/src/b.go")
+	runCmd(t, claimCmd, "claim", "--db", l.Path(), "--treatment", "llm")
+	runCmd(t, doneCmd, "done", "--db", l.Path(), "--treatment", "llm", "--path", "/src/a.go", "--tokens", "4500")
+
+	var err error
+	out := runCmd(t, func() { err = doClaimCmd() }, "claim", "--db", l.Path(), "--treatment", "llm")
+	if !errors.Is(err, ledger.ErrBudgetExhausted) || out != "" {
+		t.Fatalf("claim = %q, %v; want nothing and ErrBudgetExhausted", out, err)
+	}
+
+	srv := httptest.NewServer(newServer(l).routes())
+	defer srv.Close()
+	out = runCmd(t, func() { err = doClaimCmd() }, "claim", "--server", srv.URL, "--treatment", "llm")
+	var apiErr *client.Error
+	if !errors.Is(err, ledger.ErrBudgetExhausted) || !errors.As(err, &apiErr) || apiErr.StatusCode != 402 {
+		t.Fatalf("remote claim = %q, %v; want ErrBudgetExhausted from HTTP 402", out, err)
+	}
+}
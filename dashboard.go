@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dkoosis/next/ledger"
+)
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTmpl = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"percent": percent,
+	"add":     func(a, b int) int { return a + b },
+}).Parse(dashboardHTML))
+
+const dashboardListLimit = 25
+
+// dashboardData is everything the read-only dashboard page renders.
+type dashboardData struct {
+	Generated  string
+	Treatment  string
+	Treatments []statusRow
+	Recent     []entry
+	Failures   []entry
+	Tree       *dirNode
+}
+
+// dirNode is one directory in the completion tree.
+type dirNode struct {
+	Name     string
+	Done     int
+	Total    int
+	Children []*dirNode
+	byName   map[string]*dirNode
+}
+
+func (n *dirNode) Percent() int { return percent(n.Done, n.Total) }
+
+func percent(done, total int) int {
+	if total == 0 {
+		return 0
+	}
+	return done * 100 / total
+}
+
+func (s *server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	data, err := loadDashboard(r.Context(), s.l, r.URL.Query().Get("treatment"))
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTmpl.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func loadDashboard(ctx context.Context, l *ledger.Ledger, treatment string) (*dashboardData, error) {
+	status, err := l.Stats(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	recent, err := l.List(ctx, listOptions{Treatment: treatment, State: "done", Limit: dashboardListLimit, Newest: true})
+	if err != nil {
+		return nil, err
+	}
+	failures, err := l.List(ctx, listOptions{Treatment: treatment, State: "failed", Limit: dashboardListLimit, Newest: true})
+	if err != nil {
+		return nil, err
+	}
+	all, err := l.List(ctx, listOptions{Treatment: treatment})
+	if err != nil {
+		return nil, err
+	}
+	return &dashboardData{
+		Generated:  time.Now().UTC().Format(time.RFC3339),
+		Treatment:  treatment,
+		Treatments: status,
+		Recent:     recent,
+		Failures:   failures,
+		Tree:       completionTree(all),
+	}, nil
+}
+
+// completionTree aggregates done/total per directory, rooted at the deepest
+// directory shared by every path.
+func completionTree(entries []entry) *dirNode {
+	dirs := make([]string, len(entries))
+	for i, e := range entries {
+		dirs[i] = filepath.Dir(e.Path)
+	}
+	rootDir := commonDir(dirs)
+	root := &dirNode{Name: rootDir, byName: map[string]*dirNode{}}
+	for i, e := range entries {
+		done := e.DoneAt != ""
+		rel, err := filepath.Rel(rootDir, dirs[i])
+		if err != nil {
+			rel = dirs[i]
+		}
+		node := root
+		node.add(done)
+		if rel == "." {
+			continue
+		}
+		for _, part := range strings.Split(rel, string(filepath.Separator)) {
+			node = node.child(part)
+			node.add(done)
+		}
+	}
+	root.sort()
+	return root
+}
+
+func (n *dirNode) add(done bool) {
+	n.Total++
+	if done {
+		n.Done++
+	}
+}
+
+func (n *dirNode) child(name string) *dirNode {
+	c, ok := n.byName[name]
+	if !ok {
+		c = &dirNode{Name: name, byName: map[string]*dirNode{}}
+		n.byName[name] = c
+		n.Children = append(n.Children, c)
+	}
+	return c
+}
+
+func (n *dirNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+// commonDir returns the longest directory prefix shared by dirs.
+func commonDir(dirs []string) string {
+	if len(dirs) == 0 {
+		return ""
+	}
+	prefix := dirs[0]
+	for _, d := range dirs[1:] {
+		for prefix != d && !strings.HasPrefix(d, prefix+string(filepath.Separator)) {
+			parent := filepath.Dir(prefix)
+			if parent == prefix {
+				return parent
+			}
+			prefix = parent
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompletionTree_AggregatesPerDirectory(t *testing.T) {
+	root := completionTree([]entry{
+		{Path: "/repo/a.go"},
+		{Path: "/repo/pkg/b.go", DoneAt: "2026-01-01T00:00:00Z"},
+		{Path: "/repo/pkg/c.go"},
+		{Path: "/repo/pkg/sub/d.go", DoneAt: "2026-01-01T00:00:00Z"},
+	})
+	if root.Name != "/repo" || root.Done != 2 || root.Total != 4 {
+		t.Fatalf("root = %s %d/%d", root.Name, root.Done, root.Total)
+	}
+	if len(root.Children) != 1 || root.Children[0].Name != "pkg" {
+		t.Fatalf("children = %+v", root.Children)
+	}
+	pkg := root.Children[0]
+	if pkg.Done != 2 || pkg.Total != 3 || pkg.Percent() != 66 {
+		t.Fatalf("pkg = %d/%d (%d%%)", pkg.Done, pkg.Total, pkg.Percent())
+	}
+	if len(pkg.Children) != 1 || pkg.Children[0].Percent() != 100 {
+		t.Fatalf("pkg children = %+v", pkg.Children)
+	}
+}
+
+func TestDashboard_RendersProgressAndFailures(t *testing.T) {
+	l, db := newTestLedger(t, "")
+	ctx := context.Background()
+	mustEnqueue(t, db, "review", "/repo/a.go", "/repo/b.go")
+	if _, err := l.Complete(ctx, doneOptions{Path: "/repo/a.go", Treatment: "review", Result: "r-123"}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if _, err := l.Fail(ctx, failOptions{Path: "/repo/b.go", Treatment: "review", Error: "<rate limited>"}); err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+
+	srv := httptest.NewServer(newServer(l).routes())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", resp.StatusCode, body)
+	}
+	page := string(body)
+	for _, want := range []string{
+		`<span style="width: 50%">`,
+		"r-123",
+		"&lt;rate limited&gt;",
+		`href="/?treatment=review"`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("dashboard missing %q", want)
+		}
+	}
+	if strings.Contains(page, "<script") {
+		t.Error("dashboard must not depend on JavaScript")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dkoosis/next/client"
+	"github.com/dkoosis/next/ledger"
+)
+
+func findingsCmd() {
+	if err := doFindingsCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doFindingsCmd() error {
+	fs := flag.NewFlagSet("findings", flag.ExitOnError)
+	treatment := fs.String("treatment", "", "filter by treatment (empty = all)")
+	rule := fs.String("rule", "", "filter by rule id")
+	path := fs.String("path", "", "filter by file path")
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	req := client.FindingsRequest{Treatment: *treatment, Rule: *rule}
+	if *path != "" {
+		abs, err := filepath.Abs(*path)
+		if err != nil {
+			return fmt.Errorf("path error: %w", err)
+		}
+		req.Path = abs
+	}
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	findings, err := q.Findings(req)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+	for _, f := range findings {
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\n", f.Treatment, findingLocation(f), f.Level, f.Rule, strings.Join(strings.Fields(f.Message), " "))
+	}
+	return nil
+}
+
+// findingLocation formats a finding as path:line or path:start-end.
+func findingLocation(f client.Finding) string {
+	switch {
+	case f.StartLine == 0:
+		return f.Path
+	case f.EndLine > f.StartLine:
+		return fmt.Sprintf("%s:%d-%d", f.Path, f.StartLine, f.EndLine)
+	}
+	return fmt.Sprintf("%s:%d", f.Path, f.StartLine)
+}
+
+func diffCmd() {
+	if err := doDiffCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doDiffCmd() error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	treatment := fs.String("treatment", "default", "treatment name")
+	path := fs.String("path", "", "list one path's findings as new, fixed or unchanged (default: per-path summary)")
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	req := client.FindingsRequest{Treatment: *treatment}
+	if *path != "" {
+		abs, err := filepath.Abs(*path)
+		if err != nil {
+			return fmt.Errorf("path error: %w", err)
+		}
+		req.Path = abs
+	}
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	cur, err := q.Findings(req)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+	req.Previous = true
+	prev, err := q.Findings(req)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+	changes := ledger.DiffFindings(prev, cur)
+
+	if *path != "" {
+		for _, c := range changes {
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", c.Change, findingLocation(c.Finding), c.Level, c.Rule, strings.Join(strings.Fields(c.Message), " "))
+		}
+		return nil
+	}
+	printDiffSummary(changes)
+	return nil
+}
+
+// printDiffSummary counts changes per path, in path order, with a total.
+func printDiffSummary(changes []ledger.FindingChange) {
+	type counts struct{ new, fixed, unchanged int }
+	var paths []string
+	per := map[string]*counts{}
+	var total counts
+	for _, c := range changes {
+		n, ok := per[c.Path]
+		if !ok {
+			n = &counts{}
+			per[c.Path] = n
+			paths = append(paths, c.Path)
+		}
+		for _, cs := range []*counts{n, &total} {
+			switch c.Change {
+			case ledger.FindingNew:
+				cs.new++
+			case ledger.FindingFixed:
+				cs.fixed++
+			default:
+				cs.unchanged++
+			}
+		}
+	}
+	fmt.Printf("%8s %8s %10s  %s\n", "NEW", "FIXED", "UNCHANGED", "PATH")
+	for _, p := range paths {
+		n := per[p]
+		fmt.Printf("%8d %8d %10d  %s\n", n.new, n.fixed, n.unchanged, p)
+	}
+	fmt.Printf("%8d %8d %10d  %s\n", total.new, total.fixed, total.unchanged, "TOTAL")
+}
@@ -2,10 +2,12 @@ module github.com/dkoosis/next
 
 go 1.24.0
 
-require github.com/ncruces/go-sqlite3 v0.30.0
+require (
+	github.com/ncruces/go-sqlite3 v0.30.0
+	golang.org/x/sys v0.37.0
+)
 
 require (
 	github.com/ncruces/julianday v1.0.0 // indirect
 	github.com/tetratelabs/wazero v1.9.0 // indirect
-	golang.org/x/sys v0.37.0 // indirect
 )
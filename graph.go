@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dkoosis/next/ledger"
+)
+
+func graphCmd() {
+	if err := doGraphCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doGraphCmd() error {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	dot := fs.Bool("dot", false, "print Graphviz DOT instead of text")
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	list, err := q.Treatments()
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+	after := make(map[string][]string, len(list))
+	for _, t := range list {
+		if t.Defined {
+			after[t.Name] = t.After
+		}
+	}
+	order, err := ledger.TreatmentOrder(after)
+	if err != nil {
+		return err
+	}
+
+	if *dot {
+		fmt.Println("digraph treatments {")
+		for _, n := range order {
+			fmt.Printf("  %q;\n", n)
+			for _, d := range after[n] {
+				fmt.Printf("  %q -> %q;\n", d, n)
+			}
+		}
+		fmt.Println("}")
+		return nil
+	}
+	for _, n := range order {
+		if len(after[n]) == 0 {
+			fmt.Println(n)
+			continue
+		}
+		fmt.Printf("%s <- %s\n", n, strings.Join(after[n], ", "))
+	}
+	return nil
+}
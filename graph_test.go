@@ -0,0 +1,20 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGraphCmd_PrintsTopologicalOrder(t *testing.T) {
+	_, dbPath := newTestDB(t)
+	writeTreatments(t, dbPath, `{"treatments": {"review": {"after": ["gofmt"]}, "gofmt": {}}}`)
+
+	out := runCmd(t, graphCmd, "graph", "--db", dbPath)
+	if out != "gofmt\nreview <- gofmt\n" {
+		t.Fatalf("output = %q", out)
+	}
+	dot := runCmd(t, graphCmd, "graph", "--db", dbPath, "--dot")
+	if !strings.Contains(dot, `"gofmt" -> "review";`) {
+		t.Fatalf("dot output = %q", dot)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/dkoosis/next/ledger"
+	"github.com/dkoosis/next/treatment"
+)
+
+// hookMarker identifies hooks written by next hook install, so uninstall
+// never removes a hook someone else wrote.
+const hookMarker = "# Installed by `next hook install`"
+
+// hookBackupSuffix is appended to a hook that was in place before install.
+const hookBackupSuffix = ".next-backup"
+
+// zeroSHA is what git passes to pre-push for a ref that does not exist.
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+var errHookBlocked = errors.New("next: blocked by failing treatment results")
+
+func hookCmd() {
+	if err := doHookCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doHookCmd() error {
+	if len(os.Args) < 3 {
+		return fmt.Errorf("usage: next hook install|uninstall|run [flags]")
+	}
+	switch os.Args[2] {
+	case "install":
+		return doHookInstall(os.Args[3:])
+	case "uninstall":
+		return doHookUninstall(os.Args[3:])
+	case "run":
+		return doHookRun(os.Args[3:], os.Stdin, os.Stderr)
+	}
+	return fmt.Errorf("unknown hook command %q (want install, uninstall or run)", os.Args[2])
+}
+
+// stageFlag registers --stage, accepting the hooks next knows how to run.
+func stageFlag(fs *flag.FlagSet) *string {
+	return fs.String("stage", "pre-commit", "git hook: pre-commit or pre-push")
+}
+
+func checkStage(stage string) error {
+	if stage != "pre-commit" && stage != "pre-push" {
+		return fmt.Errorf("error: --stage must be pre-commit or pre-push, got %q", stage)
+	}
+	return nil
+}
+
+func doHookInstall(args []string) error {
+	fs := flag.NewFlagSet("hook install", flag.ExitOnError)
+	treatments := fs.String("treatment", "", "comma-separated treatments to gate on (required)")
+	stage := stageFlag(fs)
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(args)
+
+	if err := checkStage(*stage); err != nil {
+		return err
+	}
+	if len(splitList(*treatments)) == 0 {
+		return fmt.Errorf("error: --treatment required")
+	}
+	absDB, err := filepath.Abs(*dbPath)
+	if err != nil {
+		return fmt.Errorf("path error: %w", err)
+	}
+	hook, err := hookPath(*stage)
+	if err != nil {
+		return err
+	}
+	bin, err := os.Executable()
+	if err != nil {
+		bin = "next"
+	}
+
+	existing, err := os.ReadFile(hook) // #nosec G304 -- path from git rev-parse
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	case !bytes.Contains(existing, []byte(hookMarker)):
+		// Renaming over an earlier backup would lose the hook it holds.
+		if _, err := os.Lstat(hook + hookBackupSuffix); err == nil {
+			return fmt.Errorf("error: %s was not installed by next and %s already exists; move one of them aside first", hook, hook+hookBackupSuffix)
+		}
+		if err := os.Rename(hook, hook+hookBackupSuffix); err != nil {
+			return fmt.Errorf("back up existing hook: %w", err)
+		}
+		fmt.Printf("moved existing %s hook to %s\n", *stage, hook+hookBackupSuffix)
+	}
+
+	run := []string{shellQuote(bin), "hook", "run", "--stage=" + *stage, "--treatment=" + shellQuote(*treatments), "--db=" + shellQuote(absDB)}
+	if *server != "" {
+		run = append(run, "--server="+shellQuote(*server))
+	}
+	if err := os.MkdirAll(filepath.Dir(hook), 0o750); err != nil {
+		return err
+	}
+	// #nosec G306 -- git only runs executable hooks
+	if err := os.WriteFile(hook, []byte(hookScript(*stage, strings.Join(run, " "))), 0o755); err != nil {
+		return err
+	}
+	fmt.Printf("installed %s hook for treatment=%s\n", *stage, *treatments)
+	return nil
+}
+
+// hookScript chains to a backed-up hook before running next. pre-push
+// input (the refs being pushed) is read once and given to both.
+func hookScript(stage, run string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!/bin/sh\n%s; `next hook uninstall --stage=%s` restores the previous hook.\n", hookMarker, stage)
+	fmt.Fprintf(&b, "backup=\"$0%s\"\n", hookBackupSuffix)
+	if stage == "pre-push" {
+		b.WriteString("input=$(cat)\n")
+		b.WriteString("if [ -x \"$backup\" ]; then printf '%s\\n' \"$input\" | \"$backup\" \"$@\" || exit $?; fi\n")
+		fmt.Fprintf(&b, "printf '%%s\\n' \"$input\" | %s \"$@\"\n", run)
+		return b.String()
+	}
+	b.WriteString("if [ -x \"$backup\" ]; then \"$backup\" \"$@\" || exit $?; fi\n")
+	fmt.Fprintf(&b, "exec %s \"$@\"\n", run)
+	return b.String()
+}
+
+func doHookUninstall(args []string) error {
+	fs := flag.NewFlagSet("hook uninstall", flag.ExitOnError)
+	stage := stageFlag(fs)
+	_ = fs.Parse(args)
+
+	if err := checkStage(*stage); err != nil {
+		return err
+	}
+	hook, err := hookPath(*stage)
+	if err != nil {
+		return err
+	}
+	existing, err := os.ReadFile(hook) // #nosec G304 -- path from git rev-parse
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error: no %s hook installed", *stage)
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(existing, []byte(hookMarker)) {
+		return fmt.Errorf("error: %s was not installed by next; leaving it alone", hook)
+	}
+	if err := os.Remove(hook); err != nil {
+		return err
+	}
+	if err := os.Rename(hook+hookBackupSuffix, hook); err == nil {
+		fmt.Printf("restored previous %s hook\n", *stage)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("restore previous hook: %w", err)
+	}
+	fmt.Printf("uninstalled %s hook\n", *stage)
+	return nil
+}
+
+// doHookRun is what the installed hook executes. It re-enqueues the files
+// being committed or pushed, so done results for unchanged content are
+// reused and edited files are reopened, runs built-in treatments on what is
+// still pending, and fails if any touched file has a failing result. Files
+// are hashed and treated as staged or pushed, not as in the work tree.
+func doHookRun(args []string, stdin io.Reader, stderr io.Writer) error {
+	fs := flag.NewFlagSet("hook run", flag.ExitOnError)
+	treatments := fs.String("treatment", "", "comma-separated treatments to gate on (required)")
+	stage := stageFlag(fs)
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(args)
+
+	if err := checkStage(*stage); err != nil {
+		return err
+	}
+	var files []hookFile
+	var err error
+	if *stage == "pre-push" {
+		files, err = pushedFiles(stdin)
+	} else {
+		files, err = stagedFiles()
+	}
+	if err != nil {
+		return err
+	}
+	items, blobs := readBlobs(files, stderr)
+	if len(items) == 0 {
+		return nil
+	}
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	blocked := false
+	for _, name := range splitList(*treatments) {
+		failing, err := gateTreatment(q, name, items, blobs, stderr)
+		if err != nil {
+			return err
+		}
+		for _, e := range failing {
+			fmt.Fprintf(stderr, "next: %s failed for %s: %s\n", name, e.Path, e.Error)
+		}
+		blocked = blocked || len(failing) > 0
+	}
+	if blocked {
+		return errHookBlocked
+	}
+	return nil
+}
+
+// gateTreatment reopens items for name, runs the treatment on the blobs of
+// pending ones if it is built in, and returns the touched entries that are
+// failing. Entries still pending afterwards are reported but do not block.
+func gateTreatment(q queueAPI, name string, items []enqueueItem, blobs map[string][]byte, stderr io.Writer) ([]entry, error) {
+	if _, err := q.Reopen(name, items); err != nil {
+		return nil, fmt.Errorf("error: %w", err)
+	}
+	entries, err := touchedEntries(q, name, items)
+	if err != nil {
+		return nil, err
+	}
+	t, builtin := treatment.Lookup(name)
+	var failing []entry
+	for _, e := range entries {
+		if e.State == "pending" && builtin {
+			src, cleanup, err := blobFile(e.Path, blobs[e.Path])
+			if err != nil {
+				return nil, err
+			}
+			result, runErr := treatment.Run(t, src)
+			cleanup()
+			if runErr == nil {
+				_, err = q.Done(doneOptions{Path: e.Path, Treatment: name, Result: result})
+				e.State = "done"
+			} else {
+				_, err = q.Fail(failOptions{Path: e.Path, Treatment: name, Error: runErr.Error()})
+				e.State, e.Error = "failed", runErr.Error()
+			}
+			if err != nil {
+				return nil, fmt.Errorf("update error: %w", err)
+			}
+		}
+		switch e.State {
+		case "failed":
+			failing = append(failing, e)
+		case "pending", "leased":
+			fmt.Fprintf(stderr, "next: %s has no result yet for %s\n", name, e.Path)
+		}
+	}
+	return failing, nil
+}
+
+// touchedEntries returns name's entries for items, in item order. A row
+// may be missing when the treatment's globs rejected the path.
+func touchedEntries(q queueAPI, name string, items []enqueueItem) ([]entry, error) {
+	all, err := q.List(listOptions{Treatment: name})
+	if err != nil {
+		return nil, fmt.Errorf("query error: %w", err)
+	}
+	byPath := make(map[string]entry, len(all))
+	for _, e := range all {
+		byPath[e.Path] = e
+	}
+	var out []entry
+	for _, it := range items {
+		if e, ok := byPath[it.Path]; ok {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// hookFile is a path being committed or pushed and the git object holding
+// that content: ":<path>" for the index, "<commit>:<path>" for a push.
+type hookFile struct {
+	path   string
+	object string
+}
+
+// stagedFiles lists files added, copied, modified or renamed in the index.
+func stagedFiles() ([]hookFile, error) {
+	return gitFiles("", "diff", "--cached", "--name-only", "--diff-filter=ACMR", "-z")
+}
+
+// pushedFiles lists files changed by the commits in a pre-push hook's
+// input: "<local ref> <local sha> <remote ref> <remote sha>" per line. A new
+// remote branch is compared against everything already on any remote. Each
+// file's content is taken from the first pushed ref that changed it.
+func pushedFiles(r io.Reader) ([]hookFile, error) {
+	seen := map[string]bool{}
+	var out []hookFile
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) != 4 || f[1] == zeroSHA {
+			continue // malformed, or a branch deletion
+		}
+		args := []string{"log", "--format=", "--name-only", "--diff-filter=ACMR", "-z", f[1], "--not"}
+		if f[3] == zeroSHA {
+			args = append(args, "--remotes")
+		} else {
+			args = append(args, f[3])
+		}
+		files, err := gitFiles(f[1], args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, hf := range files {
+			if !seen[hf.path] {
+				seen[hf.path] = true
+				out = append(out, hf)
+			}
+		}
+	}
+	return out, sc.Err()
+}
+
+// gitFiles runs a git command printing NUL-separated paths relative to the
+// top of the work tree and returns them made absolute, with their objects
+// in rev ("" for the index).
+func gitFiles(rev string, args ...string) ([]hookFile, error) {
+	top, err := git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	out, err := git(args...)
+	if err != nil {
+		return nil, err
+	}
+	var files []hookFile
+	for _, p := range strings.Split(out, "\x00") {
+		if p = strings.Trim(p, "\n"); p != "" {
+			files = append(files, hookFile{path: filepath.Join(top, p), object: rev + ":" + p})
+		}
+	}
+	return files, nil
+}
+
+// readBlobs reads each file's content from its git object and hashes it as
+// readEnqueueItems hashes files on disk, returning the items and the
+// content by path. Unreadable objects, such as a file a later pushed commit
+// deleted, are skipped with a warning.
+func readBlobs(files []hookFile, stderr io.Writer) ([]enqueueItem, map[string][]byte) {
+	var items []enqueueItem
+	blobs := make(map[string][]byte, len(files))
+	for _, f := range files {
+		b, err := gitBlob(f.object)
+		if err != nil {
+			fmt.Fprintf(stderr, "warning: skipping %q: %v\n", f.path, err)
+			continue
+		}
+		items = append(items, enqueueItem{Path: f.path, ContentHash: ledger.ContentHash(b)})
+		blobs[f.path] = b
+	}
+	return items, blobs
+}
+
+// blobFile writes content to a temporary file named like path, for
+// treatments that read a path, and returns it with a cleanup func.
+func blobFile(path string, content []byte) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "next-hook-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { _ = os.RemoveAll(dir) }
+	tmp := filepath.Join(dir, filepath.Base(path))
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return tmp, cleanup, nil
+}
+
+// gitBlob returns an object's content byte for byte; git trims output.
+func gitBlob(object string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "cat-file", "blob", object)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git cat-file: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// hookPath is where git looks for the stage's hook, honouring
+// core.hooksPath and worktrees.
+func hookPath(stage string) (string, error) {
+	p, err := git("rev-parse", "--git-path", "hooks/"+stage)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(p)
+}
+
+func git(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
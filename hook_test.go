@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dkoosis/next/ledger"
+)
+
+// gitInit makes dir a repository for the hook tests.
+func gitInit(t *testing.T, dir string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	mustGit(t, dir, "init", "-q")
+}
+
+func mustGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func writeFile(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHookInstall_RestoresPreviousHook_When_Uninstalled(t *testing.T) {
+	dir, restore := setupWorkDir(t, false)
+	defer restore()
+	gitInit(t, dir)
+	hook := filepath.Join(dir, ".git", "hooks", "pre-commit")
+	previous := "#!/bin/sh\necho previous\n"
+	if err := os.WriteFile(hook, []byte(previous), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	runCmd(t, hookCmd, "hook", "install", "--treatment", "lint")
+	script, err := os.ReadFile(hook)
+	if err != nil || !bytes.Contains(script, []byte(hookMarker)) || !bytes.Contains(script, []byte("hook run --stage=pre-commit --treatment='lint'")) {
+		t.Fatalf("hook = %q, %v", script, err)
+	}
+	if backup, err := os.ReadFile(hook + hookBackupSuffix); err != nil || string(backup) != previous {
+		t.Fatalf("backup = %q, %v", backup, err)
+	}
+	// Reinstalling must not back up our own hook over the user's.
+	runCmd(t, hookCmd, "hook", "install", "--treatment", "lint,gofmt-check")
+	if backup, _ := os.ReadFile(hook + hookBackupSuffix); string(backup) != previous {
+		t.Fatalf("backup after reinstall = %q", backup)
+	}
+	// Nor back up another tool's hook over it.
+	ours, _ := os.ReadFile(hook)
+	other := "#!/bin/sh\necho other\n"
+	writeFile(t, hook, other)
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	os.Args = []string{"next", "hook", "install", "--treatment", "lint"}
+	if err := doHookCmd(); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("install over a foreign hook with a backup err = %v", err)
+	}
+	if backup, _ := os.ReadFile(hook + hookBackupSuffix); string(backup) != previous {
+		t.Fatalf("backup after refused install = %q", backup)
+	}
+	if got, _ := os.ReadFile(hook); string(got) != other {
+		t.Fatalf("hook after refused install = %q, want the other tool's", got)
+	}
+	writeFile(t, hook, string(ours))
+
+	runCmd(t, hookCmd, "hook", "uninstall")
+	if got, err := os.ReadFile(hook); err != nil || string(got) != previous {
+		t.Fatalf("after uninstall hook = %q, %v; want the previous hook", got, err)
+	}
+	if _, err := os.Stat(hook + hookBackupSuffix); !os.IsNotExist(err) {
+		t.Fatalf("backup left behind: %v", err)
+	}
+	os.Args = []string{"next", "hook", "uninstall"}
+	if err := doHookCmd(); err == nil || !strings.Contains(err.Error(), "not installed by next") {
+		t.Fatalf("uninstalling a foreign hook err = %v", err)
+	}
+}
+
+func TestHookRun_BlocksCommit_When_StagedFileFailing(t *testing.T) {
+	l, _ := newTestLedger(t, "")
+	dir := filepath.Dir(l.Path())
+	gitInit(t, dir)
+	a, b := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")
+	writeFile(t, a, "package a\n")
+	writeFile(t, b, "package b\n")
+	mustGit(t, dir, "add", "a.go", "b.go")
+
+	// b.go already failed lint at this content; a.go passed.
+	q := localQueue{l: l}
+	items, err := readEnqueueItems(strings.NewReader(a + "\n" + b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue("lint", items); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Done(doneOptions{Path: a, Treatment: "lint", Result: "ok"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Fail(failOptions{Path: b, Treatment: "lint", Error: "unused import"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	err = doHookRun([]string{"--treatment=lint", "--db=" + l.Path()}, nil, &stderr)
+	if !errors.Is(err, errHookBlocked) || !strings.Contains(stderr.String(), "lint failed for "+b+": unused import") {
+		t.Fatalf("err = %v, stderr:\n%s", err, stderr.String())
+	}
+
+	// Fixing b.go reopens it; with no result yet the commit goes through.
+	writeFile(t, b, "package b\n\nvar x = 1\n")
+	mustGit(t, dir, "add", "b.go")
+	stderr.Reset()
+	if err := doHookRun([]string{"--treatment=lint", "--db=" + l.Path()}, nil, &stderr); err != nil {
+		t.Fatalf("after fix err = %v, stderr:\n%s", err, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "no result yet for "+b) || strings.Contains(stderr.String(), a) {
+		t.Fatalf("stderr:\n%s", stderr.String())
+	}
+	entries, err := l.List(t.Context(), listOptions{Treatment: "lint", State: "done"})
+	if err != nil || len(entries) != 1 || entries[0].Path != a {
+		t.Fatalf("done entries = %+v, %v; want a.go's result reused", entries, err)
+	}
+}
+
+func TestHookRun_RunsBuiltinTreatment_When_Pushing(t *testing.T) {
+	l, _ := newTestLedger(t, "")
+	dir := filepath.Dir(l.Path())
+	gitInit(t, dir)
+	good, bad := filepath.Join(dir, "good.go"), filepath.Join(dir, "bad.go")
+	writeFile(t, good, "package x\n")
+	writeFile(t, bad, "package x\n")
+	mustGit(t, dir, "add", "good.go", "bad.go")
+	mustGit(t, dir, "commit", "-q", "-m", "init")
+	head := mustGit(t, dir, "rev-parse", "HEAD")
+
+	var stderr bytes.Buffer
+	input := "refs/heads/main " + head + " refs/heads/main " + zeroSHA + "\n"
+	err := doHookRun([]string{"--stage=pre-push", "--treatment=test-size", "--db=" + l.Path()}, strings.NewReader(input), &stderr)
+	if !errors.Is(err, errHookBlocked) || !strings.Contains(stderr.String(), "test-size failed for "+bad+": rejected") {
+		t.Fatalf("err = %v, stderr:\n%s", err, stderr.String())
+	}
+	entries, err := l.List(t.Context(), listOptions{Treatment: "test-size", State: "done"})
+	if err != nil || len(entries) != 1 || entries[0].Path != good || entries[0].Result != "checked" {
+		t.Fatalf("done entries = %+v, %v", entries, err)
+	}
+}
+
+func TestHookRun_ChecksStagedContent_When_WorkTreeDiffers(t *testing.T) {
+	l, _ := newTestLedger(t, "")
+	dir := filepath.Dir(l.Path())
+	gitInit(t, dir)
+	a := filepath.Join(dir, "a.go")
+	unformatted, formatted := "package  a\n", "package a\n"
+
+	// Staged unformatted, fixed only in the work tree: the commit is blocked.
+	writeFile(t, a, unformatted)
+	mustGit(t, dir, "add", "a.go")
+	writeFile(t, a, formatted)
+	var stderr bytes.Buffer
+	err := doHookRun([]string{"--treatment=gofmt-check", "--db=" + l.Path()}, nil, &stderr)
+	if !errors.Is(err, errHookBlocked) || !strings.Contains(stderr.String(), "gofmt-check failed for "+a) {
+		t.Fatalf("err = %v, stderr:\n%s", err, stderr.String())
+	}
+	entries, err := l.List(t.Context(), listOptions{Treatment: "gofmt-check"})
+	if err != nil || len(entries) != 1 || entries[0].ContentHash != ledger.ContentHash([]byte(unformatted)) {
+		t.Fatalf("entries = %+v, %v; want a.go at the staged content's hash", entries, err)
+	}
+
+	// Pushed formatted, broken only in the work tree: the push goes through.
+	writeFile(t, a, formatted)
+	mustGit(t, dir, "add", "a.go")
+	mustGit(t, dir, "commit", "-q", "-m", "init")
+	head := mustGit(t, dir, "rev-parse", "HEAD")
+	writeFile(t, a, unformatted)
+	stderr.Reset()
+	input := "refs/heads/main " + head + " refs/heads/main " + zeroSHA + "\n"
+	if err := doHookRun([]string{"--stage=pre-push", "--treatment=gofmt-check", "--db=" + l.Path()}, strings.NewReader(input), &stderr); err != nil {
+		t.Fatalf("push err = %v, stderr:\n%s", err, stderr.String())
+	}
+	entries, err = l.List(t.Context(), listOptions{Treatment: "gofmt-check", State: "done"})
+	if err != nil || len(entries) != 1 || entries[0].ContentHash != ledger.ContentHash([]byte(formatted)) {
+		t.Fatalf("done entries = %+v, %v; want a.go at the pushed content's hash", entries, err)
+	}
+}
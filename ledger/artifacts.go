@@ -0,0 +1,72 @@
+package ledger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// artifactDir is where a SQLite ledger keeps output blobs, next to its
+// file as .quality/artifacts.
+func artifactDir(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), "artifacts")
+}
+
+// validArtifact reports whether hash is a lowercase sha256 hex digest,
+// which also guarantees it is a plain file name.
+func validArtifact(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// putArtifact streams r into dir under the sha256 of its content. Output
+// identical to a stored blob is not written twice.
+func putArtifact(dir string, r io.Reader) (string, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		_ = tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	dst := filepath.Join(dir, hash)
+	if _, err := os.Stat(dst); err == nil {
+		return hash, nil
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+func openArtifact(dir, hash string) (io.ReadCloser, error) {
+	if !validArtifact(hash) {
+		return nil, fmt.Errorf("%w: %q", ErrArtifactNotFound, hash)
+	}
+	f, err := os.Open(filepath.Join(dir, hash)) // #nosec G304 -- hash is validated hex
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrArtifactNotFound, hash)
+	}
+	return f, err
+}
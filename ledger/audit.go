@@ -0,0 +1,138 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// auditColumns is the audit table's definition, shared by migrate and by
+// undo, which carries the live log into the snapshot it restores.
+const auditColumns = `(
+  id INTEGER PRIMARY KEY,
+  at TEXT NOT NULL,
+  user TEXT NOT NULL,
+  host TEXT NOT NULL,
+  pid INTEGER NOT NULL,
+  cmdline TEXT NOT NULL,
+  command TEXT NOT NULL,
+  treatment TEXT NOT NULL,
+  rows_affected INTEGER NOT NULL
+)`
+
+// auditTable is append-only: Reset leaves it alone and Undo keeps entries
+// newer than the snapshot, so the log records who changed what even after
+// the change is rolled back.
+const auditTable = `CREATE TABLE IF NOT EXISTS audit ` + auditColumns + `;
+CREATE INDEX IF NOT EXISTS idx_audit_at ON audit(at)`
+
+type actorKey struct{}
+
+// WithActor returns a context whose mutations are audited as a. Without
+// one, stores record the current process.
+func WithActor(ctx context.Context, a Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+func actorFrom(ctx context.Context) Actor {
+	if a, ok := ctx.Value(actorKey{}).(Actor); ok {
+		return a
+	}
+	return CurrentActor()
+}
+
+// CurrentActor describes this process. Fields that cannot be determined
+// are left empty.
+func CurrentActor() Actor {
+	a := Actor{PID: os.Getpid(), Cmdline: cmdline(os.Args)}
+	if u, err := user.Current(); err == nil {
+		a.User = u.Username
+	} else {
+		a.User = os.Getenv("USER")
+	}
+	a.Host, _ = os.Hostname()
+	return a
+}
+
+// cmdline joins args, quoting those that would not survive a shell split.
+func cmdline(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		if a == "" || strings.ContainsAny(a, " '\"\\$") || strings.IndexFunc(a, unicode.IsControl) >= 0 {
+			a = strconv.Quote(a)
+		}
+		quoted[i] = a
+	}
+	return strings.Join(quoted, " ")
+}
+
+// auditEntry describes a mutation by the context's actor, stamped now.
+func auditEntry(ctx context.Context, command, treatment string, rows int64) AuditEntry {
+	return AuditEntry{At: time.Now().UTC().Format(time.RFC3339), Actor: actorFrom(ctx), Command: command, Treatment: treatment, Rows: rows}
+}
+
+// recordAudit appends a mutation to the audit table within tx, so the entry
+// commits or rolls back with the change it describes.
+func recordAudit(ctx context.Context, tx execer, command, treatment string, rows int64) error {
+	return insertAudit(ctx, tx, "audit", auditEntry(ctx, command, treatment, rows))
+}
+
+func insertAudit(ctx context.Context, tx execer, table string, e AuditEntry) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO `+table+` (at, user, host, pid, cmdline, command, treatment, rows_affected)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.At, e.User, e.Host, e.PID, e.Cmdline, e.Command, e.Treatment, e.Rows)
+	if err != nil {
+		return fmt.Errorf("audit %s: %w", e.Command, err)
+	}
+	return nil
+}
+
+func queueAudit(ctx context.Context, db *sql.DB, q AuditQuery) ([]AuditEntry, error) {
+	query := `SELECT id, at, user, host, pid, cmdline, command, treatment, rows_affected
+		FROM audit WHERE (? = '' OR at >= ?) AND (? = '' OR command = ?)
+		ORDER BY id DESC`
+	args := []any{q.Since, q.Since, q.Command, q.Command}
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var out []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.At, &e.User, &e.Host, &e.PID, &e.Cmdline, &e.Command, &e.Treatment, &e.Rows); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.Reverse(out)
+	return out, nil
+}
+
+// normalizeSince rewrites an RFC 3339 Since in UTC, the form audit times
+// are stored in, so it compares as a string.
+func normalizeSince(since string) (string, error) {
+	if since == "" {
+		return "", nil
+	}
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return "", fmt.Errorf("%w: since %q is not RFC 3339", ErrInvalidQuery, since)
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
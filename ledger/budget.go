@@ -0,0 +1,66 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// spent sums the cost and tokens recorded by treatment's runs at or after
+// since, an RFC 3339 UTC time or "" for every run.
+func spent(ctx context.Context, tx *sql.Tx, treatment, since string) (cost float64, tokens int64, err error) {
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(cost), 0), COALESCE(SUM(tokens), 0) FROM runs
+		WHERE treatment=? AND at >= ?
+	`, treatment, since).Scan(&cost, &tokens)
+	return cost, tokens, err
+}
+
+// checkBudget returns ErrBudgetExhausted when q's budget is spent. It runs in
+// the claim transaction, so claimers sharing the ledger see the same spend.
+func checkBudget(ctx context.Context, tx *sql.Tx, q ClaimQuery) error {
+	if q.Budget == nil {
+		return nil
+	}
+	cost, tokens, err := spent(ctx, tx, q.Treatment, q.Budget.Since)
+	if err != nil {
+		return err
+	}
+	return budgetErr(q, cost, tokens)
+}
+
+// budgetErr compares spend against q's budget.
+func budgetErr(q ClaimQuery, cost float64, tokens int64) error {
+	b := q.Budget
+	if b.Cost > 0 && cost >= b.Cost {
+		return fmt.Errorf("%w: treatment %q spent %g of cost %g", ErrBudgetExhausted, q.Treatment, cost, b.Cost)
+	}
+	if b.Tokens > 0 && tokens >= b.Tokens {
+		return fmt.Errorf("%w: treatment %q spent %d of %d tokens", ErrBudgetExhausted, q.Treatment, tokens, b.Tokens)
+	}
+	return nil
+}
+
+func queueCosts(ctx context.Context, db *sql.DB, treatment string) ([]CostRow, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT treatment, substr(at, 1, 10) AS day, COUNT(*),
+		       COALESCE(SUM(cost), 0), COALESCE(SUM(tokens), 0)
+		FROM runs
+		WHERE (cost IS NOT NULL OR tokens IS NOT NULL) AND (? = '' OR treatment = ?)
+		GROUP BY treatment, day
+		ORDER BY treatment, day
+	`, treatment, treatment)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var out []CostRow
+	for rows.Next() {
+		var r CostRow
+		if err := rows.Scan(&r.Treatment, &r.Day, &r.Runs, &r.Cost, &r.Tokens); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
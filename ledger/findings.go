@@ -0,0 +1,141 @@
+package ledger
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// findingsTable holds the findings each done run recorded. A path's current
+// findings are those of its latest done run, so a later done without
+// findings clears them.
+const findingsTable = `CREATE TABLE IF NOT EXISTS findings (
+  run_id INTEGER NOT NULL REFERENCES runs(id),
+  path TEXT NOT NULL,
+  treatment TEXT NOT NULL,
+  rule TEXT NOT NULL,
+  level TEXT NOT NULL,
+  start_line INTEGER NOT NULL,
+  end_line INTEGER NOT NULL,
+  message TEXT NOT NULL,
+  fingerprint TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_findings_run ON findings(run_id);
+CREATE INDEX IF NOT EXISTS idx_findings_rule ON findings(treatment, rule)`
+
+// Finding changes reported by DiffFindings.
+const (
+	FindingNew       = "new"
+	FindingFixed     = "fixed"
+	FindingUnchanged = "unchanged"
+)
+
+// FindingChange is a finding classified against an earlier run. Fixed
+// findings carry the earlier run's location, the others the current one.
+type FindingChange struct {
+	Change string `json:"change"`
+	Finding
+}
+
+var digitRuns = regexp.MustCompile(`[0-9]+`)
+
+// Fingerprint identifies a finding independently of where it sits in the
+// file: a digest of the rule and text, with whitespace collapsed. Callers
+// that can read the source pass the flagged lines as text; recorded findings
+// without a fingerprint use their message with numbers masked, since
+// messages often quote line numbers.
+func Fingerprint(rule, text string) string {
+	sum := sha256.Sum256([]byte(rule + "\x00" + strings.Join(strings.Fields(text), " ")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func defaultFingerprint(f Finding) string {
+	if f.Fingerprint != "" {
+		return f.Fingerprint
+	}
+	return Fingerprint(f.Rule, digitRuns.ReplaceAllString(f.Message, "#"))
+}
+
+func recordFindings(ctx context.Context, tx execer, run int64, opts CompleteOptions) error {
+	for _, f := range opts.Findings {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO findings (run_id, path, treatment, rule, level, start_line, end_line, message, fingerprint)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, run, opts.Path, opts.Treatment, f.Rule, f.Level, f.StartLine, f.EndLine, f.Message, defaultFingerprint(f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queueFindings(ctx context.Context, db *sql.DB, q FindingsQuery) ([]Finding, error) {
+	rank := 1
+	if q.Previous {
+		rank = 2
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT run_id, path, treatment, rule, level, start_line, end_line, message, fingerprint
+		FROM findings
+		WHERE run_id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY treatment, path ORDER BY id DESC) AS n
+				FROM runs WHERE state='done'
+			) WHERE n = ?
+		)
+		  AND (? = '' OR treatment = ?) AND (? = '' OR rule = ?) AND (? = '' OR path = ?)
+		ORDER BY path, treatment, start_line, end_line, rule, message
+	`, rank, q.Treatment, q.Treatment, q.Rule, q.Rule, q.Path, q.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var out []Finding
+	for rows.Next() {
+		var f Finding
+		if err := rows.Scan(&f.Run, &f.Path, &f.Treatment, &f.Rule, &f.Level, &f.StartLine, &f.EndLine, &f.Message, &f.Fingerprint); err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// DiffFindings classifies cur against prev, matching findings on treatment,
+// path, rule and fingerprint so moved lines stay unchanged. Repeated
+// findings match one for one. The result is ordered by path, with each
+// path's current findings in their order followed by its fixed ones.
+func DiffFindings(prev, cur []Finding) []FindingChange {
+	type key struct{ treatment, path, rule, fingerprint string }
+	keyOf := func(f Finding) key { return key{f.Treatment, f.Path, f.Rule, defaultFingerprint(f)} }
+	count := func(fs []Finding) map[key]int {
+		m := map[key]int{}
+		for _, f := range fs {
+			m[keyOf(f)]++
+		}
+		return m
+	}
+
+	inPrev, inCur := count(prev), count(cur)
+	out := make([]FindingChange, 0, len(cur)+len(prev))
+	for _, f := range cur {
+		change := FindingNew
+		if k := keyOf(f); inPrev[k] > 0 {
+			inPrev[k]--
+			change = FindingUnchanged
+		}
+		out = append(out, FindingChange{Change: change, Finding: f})
+	}
+	for _, f := range prev {
+		if k := keyOf(f); inCur[k] > 0 {
+			inCur[k]--
+			continue
+		}
+		out = append(out, FindingChange{Change: FindingFixed, Finding: f})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
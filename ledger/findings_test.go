@@ -0,0 +1,55 @@
+package ledger
+
+import "testing"
+
+func TestDiffFindings_ClassifiesChanges_When_LinesShift(t *testing.T) {
+	f := func(path, rule string, line int, msg string) Finding {
+		return Finding{Path: path, Treatment: "lint", Rule: rule, StartLine: line, EndLine: line, Message: msg}
+	}
+	prev := []Finding{
+		f("/src/a.go", "unused", 3, "x declared at line 3 is unused"),
+		f("/src/a.go", "shadow", 9, "err shadowed"),
+		f("/src/a.go", "dup", 12, "duplicate"),
+		f("/src/b.go", "unused", 1, "y is unused"),
+	}
+	cur := []Finding{
+		f("/src/a.go", "unused", 5, "x declared at line 5 is unused"),
+		f("/src/a.go", "dup", 14, "duplicate"),
+		f("/src/a.go", "dup", 20, "duplicate"),
+		f("/src/b.go", "unused", 1, "y is unused"),
+	}
+
+	got := DiffFindings(prev, cur)
+	want := []struct {
+		change string
+		rule   string
+		line   int
+	}{
+		{FindingUnchanged, "unused", 5},
+		{FindingUnchanged, "dup", 14},
+		{FindingNew, "dup", 20},
+		{FindingFixed, "shadow", 9},
+		{FindingUnchanged, "unused", 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("DiffFindings = %+v, want %d changes", got, len(want))
+	}
+	for i, w := range want {
+		if got[i].Change != w.change || got[i].Rule != w.rule || got[i].StartLine != w.line {
+			t.Errorf("change %d = %s %s:%d, want %s %s:%d", i, got[i].Change, got[i].Rule, got[i].StartLine, w.change, w.rule, w.line)
+		}
+	}
+}
+
+func TestDiffFindings_UsesFingerprint_When_Set(t *testing.T) {
+	prev := []Finding{{Path: "/src/a.go", Rule: "r", Message: "same", Fingerprint: Fingerprint("r", "x := 1")}}
+	cur := []Finding{{Path: "/src/a.go", Rule: "r", Message: "same", Fingerprint: Fingerprint("r", "x  :=  2")}}
+
+	got := DiffFindings(prev, cur)
+	if len(got) != 2 || got[0].Change != FindingNew || got[1].Change != FindingFixed {
+		t.Fatalf("DiffFindings = %+v, want the edited line new and the old one fixed", got)
+	}
+	if Fingerprint("r", "  x := 1\n") != prev[0].Fingerprint {
+		t.Fatal("Fingerprint is sensitive to surrounding whitespace")
+	}
+}
@@ -0,0 +1,63 @@
+package ledger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TreatmentOrder returns treatment names so that each comes after every
+// treatment it depends on, breaking ties by name. after maps each treatment
+// to its upstream treatments. A dependency cycle or an undefined dependency
+// is an error naming the offending treatments.
+func TreatmentOrder(after map[string][]string) ([]string, error) {
+	names := make([]string, 0, len(after))
+	for n := range after {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(after))
+	order := make([]string, 0, len(after))
+	var stack []string
+	var visit func(string) error
+	visit = func(n string) error {
+		switch state[n] {
+		case visited:
+			return nil
+		case visiting:
+			i := len(stack) - 1
+			for stack[i] != n {
+				i--
+			}
+			return fmt.Errorf("dependency cycle: %s -> %s", strings.Join(stack[i:], " -> "), n)
+		}
+		state[n] = visiting
+		stack = append(stack, n)
+		deps := append([]string(nil), after[n]...)
+		sort.Strings(deps)
+		for _, d := range deps {
+			if _, ok := after[d]; !ok {
+				return fmt.Errorf("treatment %q: after %q: not defined", n, d)
+			}
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[n] = visited
+		order = append(order, n)
+		return nil
+	}
+	for _, n := range names {
+		if err := visit(n); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
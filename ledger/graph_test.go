@@ -0,0 +1,35 @@
+package ledger
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTreatmentOrder_PutsDependenciesFirst(t *testing.T) {
+	order, err := TreatmentOrder(map[string][]string{
+		"review": {"gofmt", "vet"},
+		"vet":    {"gofmt"},
+		"gofmt":  nil,
+		"count":  nil,
+	})
+	if err != nil {
+		t.Fatalf("TreatmentOrder: %v", err)
+	}
+	if got := strings.Join(order, " "); got != "count gofmt vet review" {
+		t.Fatalf("order = %q", got)
+	}
+}
+
+func TestTreatmentOrder_ReportsCycle(t *testing.T) {
+	_, err := TreatmentOrder(map[string][]string{
+		"a": {"b"},
+		"b": {"c"},
+		"c": {"a"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		t.Fatalf("err = %v, want the cycle spelled out", err)
+	}
+	if _, err := TreatmentOrder(map[string][]string{"a": {"missing"}}); err == nil {
+		t.Fatal("undefined dependency accepted")
+	}
+}
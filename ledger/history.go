@@ -0,0 +1,75 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+)
+
+// runsTable keeps every done and fail, so a result, its error and the
+// artifact linked to it outlive the queue row being revisited, reopened or
+// reset.
+const runsTable = `CREATE TABLE IF NOT EXISTS runs (
+  id INTEGER PRIMARY KEY,
+  path TEXT NOT NULL,
+  treatment TEXT NOT NULL,
+  content_hash TEXT NOT NULL,
+  state TEXT NOT NULL,
+  result TEXT,
+  error TEXT,
+  artifact TEXT,
+  version TEXT,
+  worker TEXT,
+  cost REAL,
+  tokens INTEGER,
+  at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_runs_path ON runs(treatment, path, id)`
+
+// runExtras is what a done or fail attaches to its run besides the queue
+// row's outcome.
+type runExtras struct {
+	artifact, worker string
+	cost             float64
+	tokens           int64
+}
+
+// recordRun appends the queue row's current outcome to runs with x and
+// returns the run's id. It runs in the transaction that just completed or
+// failed the row.
+func recordRun(ctx context.Context, tx execer, path, treatment string, x runExtras) (int64, error) {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO runs (path, treatment, content_hash, state, result, error, artifact, version, worker, cost, tokens, at)
+		SELECT path, treatment, content_hash,
+		       CASE WHEN done_at IS NULL THEN 'failed' ELSE 'done' END,
+		       result, error, NULLIF(?, ''), CASE WHEN done_at IS NULL THEN NULL ELSE version END,
+		       NULLIF(?, ''), NULLIF(?, 0), NULLIF(?, 0), COALESCE(done_at, failed_at)
+		FROM queue WHERE path=? AND treatment=?
+	`, x.artifact, x.worker, x.cost, x.tokens, path, treatment)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func queueHistory(ctx context.Context, db *sql.DB, treatment, path string) ([]Run, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, path, treatment, content_hash, state, COALESCE(result, ''), COALESCE(error, ''),
+		       COALESCE(artifact, ''), COALESCE(version, ''), COALESCE(worker, ''),
+		       COALESCE(cost, 0), COALESCE(tokens, 0), at
+		FROM runs WHERE treatment=? AND path=?
+		ORDER BY id DESC
+	`, treatment, path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var out []Run
+	for rows.Next() {
+		var r Run
+		if err := rows.Scan(&r.ID, &r.Path, &r.Treatment, &r.ContentHash, &r.State, &r.Result, &r.Error, &r.Artifact, &r.Version, &r.Worker, &r.Cost, &r.Tokens, &r.At); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
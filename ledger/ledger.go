@@ -0,0 +1,574 @@
+// Package ledger is the deterministic job queue behind the next CLI: a
+// ledger of (path, treatment) rows that workers enqueue, claim and complete,
+// kept in SQLite or, through the Store interface, any other backend. Embed
+// it to run the queue inside a Go service; use the client package instead to
+// talk to a `next serve` endpoint.
+//
+//	l, err := ledger.Open(".quality/ledger.db", ledger.Options{})
+//	items, err := l.Claim(ctx, ledger.ClaimOptions{Treatment: "lint", N: 1, Lease: 10 * time.Minute})
+//	_, err = l.Complete(ctx, ledger.CompleteOptions{Path: items[0].Path, Treatment: "lint", Result: "ok"})
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// Errors callers can test for with errors.Is.
+var (
+	// ErrUnknownTreatment is returned for a treatment name missing from the
+	// treatments file.
+	ErrUnknownTreatment = errors.New("unknown treatment")
+	// ErrUnknownState is returned by List for a state other than pending,
+	// leased, failed, done or due.
+	ErrUnknownState = errors.New("unknown state")
+	// ErrSnapshotNotFound is returned by Undo for a missing snapshot.
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// ErrInvalidQuery is returned for a malformed list filter or status
+	// aggregate over result JSON.
+	ErrInvalidQuery = errors.New("invalid query")
+	// ErrInvalidResultJSON is returned by Complete for result JSON that does
+	// not parse.
+	ErrInvalidResultJSON = errors.New("invalid result JSON")
+	// ErrArtifactNotFound is returned for an artifact digest that is not
+	// stored, and by stores that keep no artifacts.
+	ErrArtifactNotFound = errors.New("artifact not found")
+	// ErrLeaseRequired is returned by Claim without a lease for a treatment
+	// whose concurrency is capped, since only leases count against the cap.
+	ErrLeaseRequired = errors.New("lease required")
+	// ErrBudgetExhausted is returned by Claim once a treatment's runs have
+	// spent its budget, until the budget's window moves past enough spend.
+	ErrBudgetExhausted = errors.New("budget exhausted")
+	// ErrInvalidSpend is returned by Complete and Fail for a negative cost
+	// or token count, or for spend on a path they update no row for.
+	ErrInvalidSpend = errors.New("invalid spend")
+)
+
+// DefaultSnapshotKeep is how many snapshots Reset retains by default.
+const DefaultSnapshotKeep = 10
+
+// Options configures a Ledger. The zero value is ready to use.
+type Options struct {
+	// SnapshotKeep is how many pre-reset snapshots a SQLiteStore retains:
+	// zero means DefaultSnapshotKeep and a negative value disables them.
+	SnapshotKeep int
+	// TreatmentsFile is where treatment definitions are read from. Open and
+	// New default to treatments.json next to the ledger file; NewWithStore
+	// reads none unless it is set. A missing file accepts any treatment.
+	TreatmentsFile string
+}
+
+// ClaimOptions selects what Claim hands out.
+type ClaimOptions struct {
+	Treatment string
+	// Cursor resumes after this path_hash.
+	Cursor string
+	N      int
+	// Lease hides claimed rows from other claimers until it expires. Zero
+	// uses the treatment's timeout, if defined, and otherwise no lease.
+	Lease time.Duration
+	// Wait, when positive, blocks up to that long for something claimable.
+	Wait time.Duration
+	// Version overrides the treatment's defined version; done rows recorded
+	// under any other version are claimable again.
+	Version string
+	// Worker identifies the claimer in `next workers` and in run history.
+	Worker string
+}
+
+// WorkersOptions selects and windows Ledger.Workers.
+type WorkersOptions struct {
+	// Treatment restricts the report to one treatment when non-empty.
+	Treatment string
+	// Window is how far back done runs count as recent; zero means 15
+	// minutes.
+	Window time.Duration
+	// Stale is how old an unleased claim must be to count as stale; zero
+	// means an hour. Leased claims are stale once the lease expires.
+	Stale time.Duration
+}
+
+// Ledger is an open queue. It is safe for concurrent use; claims waiting in
+// one Ledger are woken by mutations made through the same Ledger.
+type Ledger struct {
+	store      Store
+	path       string
+	treatments treatmentConfig
+	changes    *notifier
+}
+
+// Open opens or creates the SQLite ledger at path.
+func Open(path string, opts Options) (*Ledger, error) {
+	s, err := OpenSQLiteStore(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	l, err := newSQLiteLedger(s, opts)
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// New wraps an already open SQLite database whose file is at path,
+// upgrading its queue table if one exists. Close closes db.
+func New(db *sql.DB, path string, opts Options) (*Ledger, error) {
+	s, err := NewSQLiteStore(db, path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newSQLiteLedger(s, opts)
+}
+
+func newSQLiteLedger(s *SQLiteStore, opts Options) (*Ledger, error) {
+	if opts.TreatmentsFile == "" {
+		opts.TreatmentsFile = treatmentsPath(s.path)
+	}
+	l, err := NewWithStore(s, opts)
+	if err != nil {
+		return nil, err
+	}
+	l.path = s.path
+	return l, nil
+}
+
+// NewWithStore runs a ledger on any Store, such as a MemoryStore in tests.
+// Close closes s.
+func NewWithStore(s Store, opts Options) (*Ledger, error) {
+	treatments, err := loadTreatments(opts.TreatmentsFile)
+	if err != nil {
+		return nil, err
+	}
+	return &Ledger{store: s, treatments: treatments, changes: newNotifier()}, nil
+}
+
+// Close closes the underlying store.
+func (l *Ledger) Close() error {
+	return l.store.Close()
+}
+
+// Path is the ledger's file path, or "" when the store has none.
+func (l *Ledger) Path() string {
+	return l.path
+}
+
+// Enqueue adds items for treatment, skipping rows that already exist and
+// paths the treatment's include/exclude globs reject. It reports how many
+// items were kept.
+func (l *Ledger) Enqueue(ctx context.Context, treatment string, items []Item) (int, error) {
+	def, err := l.treatments.lookup(treatment)
+	if err != nil {
+		return 0, err
+	}
+	n, err := l.store.Enqueue(ctx, treatment, l.treatments.filter(def, items))
+	if err == nil {
+		l.changes.broadcast()
+	}
+	return n, err
+}
+
+// Reopen is Enqueue for files that may have changed: rows whose content
+// hash differs from the item's are reset to pending under the new hash. It
+// reports how many rows were added or reset.
+func (l *Ledger) Reopen(ctx context.Context, treatment string, items []Item) (int, error) {
+	def, err := l.treatments.lookup(treatment)
+	if err != nil {
+		return 0, err
+	}
+	n, err := l.store.Reopen(ctx, treatment, l.treatments.filter(def, items))
+	if err == nil && n > 0 {
+		l.changes.broadcast()
+	}
+	return n, err
+}
+
+// Claim stamps and returns up to opts.N claimable rows in path_hash order,
+// applying the treatment's definition (timeout as the default lease,
+// version, upstream dependencies, concurrency cap, rate limit and budget). A
+// capped treatment needs a lease, or ErrLeaseRequired is returned, and one
+// whose budget is spent returns ErrBudgetExhausted. With opts.Wait it blocks
+// until something is claimable or the wait elapses, returning an empty slice
+// in that case.
+func (l *Ledger) Claim(ctx context.Context, opts ClaimOptions) ([]Claimed, error) {
+	def, err := l.treatments.lookup(opts.Treatment)
+	if err != nil {
+		return nil, err
+	}
+	q := ClaimQuery{Treatment: opts.Treatment, Cursor: opts.Cursor, N: opts.N, Lease: opts.Lease, Version: opts.Version, After: def.After, Worker: opts.Worker}
+	if q.N <= 0 {
+		q.N = 1
+	}
+	if q.Lease == 0 && def.Timeout != "" {
+		q.Lease, _ = time.ParseDuration(def.Timeout) // validated at load
+	}
+	if q.Version == "" {
+		q.Version = def.Version
+	}
+	if def.Concurrency > 0 {
+		if q.Lease <= 0 {
+			return nil, fmt.Errorf("%w: treatment %q caps concurrency at %d; claim with a lease or define a timeout", ErrLeaseRequired, opts.Treatment, def.Concurrency)
+		}
+		q.MaxLeases = def.Concurrency
+	}
+	if r := def.RateLimit; r != nil {
+		per, _ := time.ParseDuration(r.Per) // validated at load
+		q.Rate, q.Burst = float64(r.Requests)/per.Seconds(), r.Burst
+		if q.Burst == 0 {
+			q.Burst = r.Requests
+		}
+	}
+	if b := def.Budget; b != nil {
+		q.Budget = &BudgetQuery{Cost: b.Cost, Tokens: b.Tokens}
+		if b.Per != "" {
+			per, _ := time.ParseDuration(b.Per) // validated at load
+			q.Budget.Since = time.Now().UTC().Add(-per).Format(time.RFC3339)
+		}
+	}
+	var items []Claimed
+	if opts.Wait > 0 {
+		items, err = claimWaiting(ctx, l.store, q, opts.Wait, l.changes.wait)
+	} else {
+		items, err = l.store.Claim(ctx, q)
+	}
+	return items, err
+}
+
+// Complete records a result, releasing any lease. Revisit and Version
+// default to the treatment's; the path is enqueued for opts.Then and the
+// treatment's on_done follow-ups whose globs admit it. It returns the rows
+// updated, zero when the path was never enqueued. A non-empty
+// opts.Artifact must name a stored artifact; a negative opts.Cost or
+// opts.Tokens, or either on a path with no row, is ErrInvalidSpend.
+func (l *Ledger) Complete(ctx context.Context, opts CompleteOptions) (int64, error) {
+	def, err := l.treatments.lookup(opts.Treatment)
+	if err != nil {
+		return 0, err
+	}
+	if err := l.checkArtifact(ctx, opts.Artifact); err != nil {
+		return 0, err
+	}
+	if err := checkSpend(opts.Cost, opts.Tokens); err != nil {
+		return 0, err
+	}
+	if opts.ResultJSON != "" {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, []byte(opts.ResultJSON)); err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrInvalidResultJSON, err)
+		}
+		opts.ResultJSON = buf.String()
+	}
+	var then []string
+	for _, name := range append(append([]string(nil), opts.Then...), def.OnDone...) {
+		next, err := l.treatments.lookup(name)
+		if err != nil {
+			return 0, err
+		}
+		if !slices.Contains(then, name) && l.treatments.selects(next, opts.Path) {
+			then = append(then, name)
+		}
+	}
+	opts.Then = then
+	if opts.Revisit == "" {
+		opts.Revisit = def.Revisit
+	}
+	if opts.Version == "" {
+		opts.Version = def.Version
+	}
+	n, err := l.store.Complete(ctx, opts)
+	if err == nil {
+		l.changes.broadcast()
+	}
+	return n, err
+}
+
+// Fail records an error and releases the lease. Without opts.Revisit the
+// treatment's retry policy applies: the path is rescheduled after the
+// backoff until max_attempts failures, then stays failed.
+func (l *Ledger) Fail(ctx context.Context, opts FailOptions) (int64, error) {
+	def, err := l.treatments.lookup(opts.Treatment)
+	if err != nil {
+		return 0, err
+	}
+	if err := l.checkArtifact(ctx, opts.Artifact); err != nil {
+		return 0, err
+	}
+	if err := checkSpend(opts.Cost, opts.Tokens); err != nil {
+		return 0, err
+	}
+	opts.Retry = def.Retry
+	n, err := l.store.Fail(ctx, opts)
+	if err == nil {
+		l.changes.broadcast()
+	}
+	return n, err
+}
+
+// Stats counts rows per treatment, or for one treatment when non-empty.
+func (l *Ledger) Stats(ctx context.Context, treatment string) ([]Stats, error) {
+	if err := l.checkTreatment(treatment); err != nil {
+		return nil, err
+	}
+	rows, err := l.store.Stats(ctx, treatment)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range rows {
+		def, _ := l.treatments.lookup(r.Treatment) // undefined rows are uncapped
+		rows[i].MaxRunning = def.Concurrency
+	}
+	return rows, nil
+}
+
+// Versions counts done rows per recorded version, marking which match each
+// treatment's defined version. Without a defined version every result is
+// current.
+func (l *Ledger) Versions(ctx context.Context, treatment string) ([]VersionCount, error) {
+	if err := l.checkTreatment(treatment); err != nil {
+		return nil, err
+	}
+	rows, err := l.store.Versions(ctx, treatment)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range rows {
+		def, _ := l.treatments.lookup(r.Treatment) // undefined rows count as unversioned
+		rows[i].Current = def.Version == "" || r.Version == def.Version
+	}
+	return rows, nil
+}
+
+// List returns rows matching opts. opts.Where filters on result JSON with
+// terms such as "$.score < 5" joined by "and".
+func (l *Ledger) List(ctx context.Context, opts ListOptions) ([]Entry, error) {
+	if err := l.checkTreatment(opts.Treatment); err != nil {
+		return nil, err
+	}
+	return l.store.List(ctx, opts)
+}
+
+// Aggregate computes agg, one of avg, sum, min, max or count over a result
+// JSON path such as "avg($.score)", per treatment across done rows.
+func (l *Ledger) Aggregate(ctx context.Context, treatment, agg string) ([]AggregateRow, error) {
+	if err := l.checkTreatment(treatment); err != nil {
+		return nil, err
+	}
+	return l.store.Aggregate(ctx, treatment, agg)
+}
+
+// Reset deletes every row for treatment, first snapshotting stores that
+// support it. Any name is accepted so stray queues can be cleared.
+func (l *Ledger) Reset(ctx context.Context, treatment string) (int64, error) {
+	if snap, ok := l.store.(Snapshotter); ok {
+		if _, err := snap.Snapshot(ctx, "reset"); err != nil {
+			return 0, fmt.Errorf("%w; aborting reset", err)
+		}
+	}
+	n, err := l.store.Reset(ctx, treatment)
+	if err == nil {
+		l.changes.broadcast()
+	}
+	return n, err
+}
+
+// History returns the runs recorded for path under treatment, newest first.
+func (l *Ledger) History(ctx context.Context, treatment, path string) ([]Run, error) {
+	if err := l.checkTreatment(treatment); err != nil {
+		return nil, err
+	}
+	return l.store.History(ctx, treatment, path)
+}
+
+// Findings returns the current findings matching q: those recorded by each
+// path's latest done run, ordered by path and line.
+func (l *Ledger) Findings(ctx context.Context, q FindingsQuery) ([]Finding, error) {
+	if err := l.checkTreatment(q.Treatment); err != nil {
+		return nil, err
+	}
+	return l.store.Findings(ctx, q)
+}
+
+// PutArtifact stores r's content and returns its sha256 digest, for linking
+// to a run through CompleteOptions.Artifact or FailOptions.Artifact.
+func (l *Ledger) PutArtifact(ctx context.Context, r io.Reader) (string, error) {
+	as, ok := l.store.(ArtifactStore)
+	if !ok {
+		return "", errors.New("store keeps no artifacts")
+	}
+	return as.PutArtifact(ctx, r)
+}
+
+// OpenArtifact returns a stored artifact, or ErrArtifactNotFound.
+func (l *Ledger) OpenArtifact(ctx context.Context, hash string) (io.ReadCloser, error) {
+	as, ok := l.store.(ArtifactStore)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrArtifactNotFound, hash)
+	}
+	return as.OpenArtifact(ctx, hash)
+}
+
+// checkArtifact rejects a link to an artifact that was never stored.
+func (l *Ledger) checkArtifact(ctx context.Context, hash string) error {
+	if hash == "" {
+		return nil
+	}
+	rc, err := l.OpenArtifact(ctx, hash)
+	if err != nil {
+		return err
+	}
+	return rc.Close()
+}
+
+// checkSpend rejects a negative cost or token count.
+func checkSpend(cost float64, tokens int64) error {
+	if cost < 0 || tokens < 0 {
+		return fmt.Errorf("%w: cost %g and tokens %d must not be negative", ErrInvalidSpend, cost, tokens)
+	}
+	return nil
+}
+
+// spendWithoutRow rejects spend on a done or fail that updates no row:
+// spend is counted through the run the update records, so it would be lost.
+func spendWithoutRow(cost float64, tokens int64, path, treatment string) error {
+	if cost == 0 && tokens == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s for %s updated no row, so cost %g and tokens %d cannot be recorded", ErrInvalidSpend, path, treatment, cost, tokens)
+}
+
+// Snapshots lists the store's snapshots, oldest first. Stores without
+// snapshots report none.
+func (l *Ledger) Snapshots(ctx context.Context) ([]Snapshot, error) {
+	snap, ok := l.store.(Snapshotter)
+	if !ok {
+		return nil, nil
+	}
+	return snap.Snapshots(ctx)
+}
+
+// Undo restores the named snapshot. The state it replaces is snapshotted
+// first with reason "undo", so undoing again reverts a mistaken undo; the
+// restored snapshot is kept for stepping further back by name.
+func (l *Ledger) Undo(ctx context.Context, name string) error {
+	snap, ok := l.store.(Snapshotter)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrSnapshotNotFound, name)
+	}
+	err := snap.Restore(ctx, name)
+	if err == nil {
+		l.changes.broadcast()
+	}
+	return err
+}
+
+// Costs totals the cost and tokens recorded by runs per treatment and UTC
+// day, or for one treatment when non-empty.
+func (l *Ledger) Costs(ctx context.Context, treatment string) ([]CostRow, error) {
+	if err := l.checkTreatment(treatment); err != nil {
+		return nil, err
+	}
+	return l.store.Costs(ctx, treatment)
+}
+
+// Workers reports each worker's outstanding and stale claims, done and
+// failed runs, done runs within opts.Window and when it was last seen.
+func (l *Ledger) Workers(ctx context.Context, opts WorkersOptions) ([]WorkerStats, error) {
+	if err := l.checkTreatment(opts.Treatment); err != nil {
+		return nil, err
+	}
+	if opts.Window <= 0 {
+		opts.Window = 15 * time.Minute
+	}
+	if opts.Stale <= 0 {
+		opts.Stale = time.Hour
+	}
+	now := time.Now().UTC()
+	return l.store.Workers(ctx, WorkersQuery{
+		Treatment:   opts.Treatment,
+		Since:       now.Add(-opts.Window).Format(time.RFC3339),
+		StaleBefore: now.Add(-opts.Stale).Format(time.RFC3339),
+	})
+}
+
+// AuditLog returns the audit entries matching q, oldest first. q.Since may
+// be in any zone.
+func (l *Ledger) AuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	since, err := normalizeSince(q.Since)
+	if err != nil {
+		return nil, err
+	}
+	q.Since = since
+	return l.store.AuditLog(ctx, q)
+}
+
+// Treatments lists the defined treatments with their counts, plus any
+// treatment that has rows but no definition.
+func (l *Ledger) Treatments(ctx context.Context) ([]Treatment, error) {
+	status, err := l.store.Stats(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return l.treatments.treatmentList(status), nil
+}
+
+// WriteMetrics writes the Prometheus text exposition of the ledger. The
+// counters and latency histogram need a CounterStore; other stores report
+// the gauges alone.
+func (l *Ledger) WriteMetrics(ctx context.Context, w io.Writer) error {
+	status, err := l.store.Stats(ctx, "")
+	if err != nil {
+		return err
+	}
+	var counters map[string]map[string]float64
+	if cs, ok := l.store.(CounterStore); ok {
+		if counters, err = cs.Counters(ctx); err != nil {
+			return err
+		}
+	}
+	writeMetrics(w, status, counters)
+	return nil
+}
+
+func (l *Ledger) checkTreatment(treatment string) error {
+	if treatment == "" {
+		return nil
+	}
+	_, err := l.treatments.lookup(treatment)
+	return err
+}
+
+// PathHash is the sha256 hex digest of a path, which fixes claim order.
+func PathHash(path string) string {
+	h := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(h[:])
+}
+
+// FileHash is the sha256 hex digest of a file's contents.
+func FileHash(path string) (string, error) {
+	f, err := os.Open(path) // #nosec G304 -- path comes from user input, which is expected
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ContentHash is FileHash for content already read, such as a git blob.
+func ContentHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,456 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestLedger returns a ledger over a MemoryStore, reading treatments
+// from a temporary file when non-empty.
+func newTestLedger(t *testing.T, treatments string) *Ledger {
+	t.Helper()
+	var opts Options
+	if treatments != "" {
+		opts.TreatmentsFile = filepath.Join(t.TempDir(), "treatments.json")
+		if err := os.WriteFile(opts.TreatmentsFile, []byte(treatments), 0o600); err != nil {
+			t.Fatalf("write treatments: %v", err)
+		}
+	}
+	l, err := NewWithStore(NewMemoryStore(), opts)
+	if err != nil {
+		t.Fatalf("NewWithStore: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	return l
+}
+
+// newFileLedger opens a fresh SQLite ledger in a temporary directory for
+// tests that reach into the file.
+func newFileLedger(t *testing.T) (*Ledger, *SQLiteStore) {
+	t.Helper()
+	l, err := Open(filepath.Join(t.TempDir(), "ledger.db"), Options{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	return l, l.store.(*SQLiteStore)
+}
+
+func newTestStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+	s, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "ledger.db"), Options{})
+	if err != nil {
+		t.Fatalf("OpenSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func writeTreatments(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.WriteFile(treatmentsPath(path), []byte(body), 0o600); err != nil {
+		t.Fatalf("write treatments: %v", err)
+	}
+}
+
+// mustEnqueue adds rows straight to the store, bypassing treatment globs.
+func mustEnqueue(t *testing.T, s Store, treatment string, paths ...string) {
+	t.Helper()
+	items := make([]Item, 0, len(paths))
+	for _, p := range paths {
+		items = append(items, Item{Path: p, ContentHash: "hash-" + filepath.Base(p)})
+	}
+	if _, err := s.Enqueue(context.Background(), treatment, items); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+}
+
+func countRows(t *testing.T, s Store, treatment string) int {
+	t.Helper()
+	entries, err := s.List(context.Background(), ListOptions{Treatment: treatment})
+	if err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	return len(entries)
+}
+
+func TestOpen_UpgradesLedger_When_ColumnsMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE queue (path TEXT PRIMARY KEY, path_hash TEXT NOT NULL, content_hash TEXT NOT NULL, treatment TEXT NOT NULL, done_at TEXT, result TEXT, next_at TEXT)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	_ = db.Close()
+
+	l, err := Open(path, Options{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+	ctx := context.Background()
+	if _, err := l.Enqueue(ctx, "lint", []Item{{Path: "/src/a.go", ContentHash: "h"}}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	items, err := l.Claim(ctx, ClaimOptions{Treatment: "lint", Lease: time.Minute})
+	if err != nil || len(items) != 1 {
+		t.Fatalf("Claim = %v, %v", items, err)
+	}
+}
+
+func TestOpen_UpgradesOnce_When_OpenedConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE queue (path TEXT PRIMARY KEY, path_hash TEXT NOT NULL, content_hash TEXT NOT NULL, treatment TEXT NOT NULL, done_at TEXT, result TEXT, next_at TEXT)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	_ = db.Close()
+
+	// Connect first so the migrations, not the connection setup, overlap.
+	dbs := make([]*sql.DB, 16)
+	for i := range dbs {
+		if dbs[i], err = sql.Open("sqlite3", path); err != nil {
+			t.Fatalf("sql.Open: %v", err)
+		}
+		defer func() { _ = dbs[i].Close() }()
+		if err := dbs[i].Ping(); err != nil {
+			t.Fatalf("Ping: %v", err)
+		}
+	}
+	start := make(chan struct{})
+	errs := make(chan error, len(dbs))
+	for _, db := range dbs {
+		go func() {
+			<-start
+			_, err := NewSQLiteStore(db, path, Options{})
+			errs <- err
+		}()
+	}
+	close(start)
+	for range dbs {
+		if err := <-errs; err != nil {
+			t.Errorf("NewSQLiteStore: %v", err)
+		}
+	}
+}
+
+func TestLedger_RejectsUnknownTreatment_When_Defined(t *testing.T) {
+	l := newTestLedger(t, `{"treatments": {"lint": {}}}`)
+	ctx := context.Background()
+
+	if _, err := l.Enqueue(ctx, "lnit", nil); !errors.Is(err, ErrUnknownTreatment) {
+		t.Fatalf("Enqueue(lnit) err = %v, want ErrUnknownTreatment", err)
+	}
+	if _, err := l.Claim(ctx, ClaimOptions{Treatment: "lnit"}); !errors.Is(err, ErrUnknownTreatment) {
+		t.Fatalf("Claim(lnit) err = %v, want ErrUnknownTreatment", err)
+	}
+	if _, err := l.Costs(ctx, "lnit"); !errors.Is(err, ErrUnknownTreatment) {
+		t.Fatalf("Costs(lnit) err = %v, want ErrUnknownTreatment", err)
+	}
+	if _, err := l.List(ctx, ListOptions{State: "bogus"}); !errors.Is(err, ErrUnknownState) {
+		t.Fatalf("List(bogus) err = %v, want ErrUnknownState", err)
+	}
+	if _, err := l.Enqueue(ctx, "lint", nil); err != nil {
+		t.Fatalf("Enqueue(lint): %v", err)
+	}
+}
+
+func TestLedger_FiltersEnqueue_ByGlobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	dir := filepath.Dir(path)
+	// Globs are relative to the parent of the ledger's directory.
+	writeTreatments(t, path, `{"treatments": {"lint": {"include": ["**/*.go"], "exclude": ["`+filepath.Base(dir)+`/vendor/**"]}}}`)
+	l, err := Open(path, Options{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+	ctx := context.Background()
+	items := []Item{
+		{Path: filepath.Join(dir, "main.go"), ContentHash: "h1"},
+		{Path: filepath.Join(dir, "README.md"), ContentHash: "h2"},
+		{Path: filepath.Join(dir, "vendor", "x.go"), ContentHash: "h3"},
+	}
+
+	n, err := l.Enqueue(ctx, "lint", items)
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	entries, err := l.List(ctx, ListOptions{Treatment: "lint"})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if n != 1 || len(entries) != 1 || filepath.Base(entries[0].Path) != "main.go" {
+		t.Fatalf("enqueued %d, entries %+v; want only main.go", n, entries)
+	}
+}
+
+func TestLedger_AppliesRetryPolicy_When_Failing(t *testing.T) {
+	l := newTestLedger(t, `{"treatments": {"lint": {"revisit": "14 days", "retry": {"max_attempts": 2, "backoff": "-1 seconds"}}}}`)
+	ctx := context.Background()
+	mustEnqueue(t, l.store, "lint", "/src/a.go")
+
+	fail := func() {
+		t.Helper()
+		if _, err := l.Fail(ctx, FailOptions{Path: "/src/a.go", Treatment: "lint", Error: "boom"}); err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+	}
+	fail()
+	if items, err := l.Claim(ctx, ClaimOptions{Treatment: "lint"}); err != nil || len(items) != 1 {
+		t.Fatalf("claim after first failure = %v, %v; want the retry", items, err)
+	}
+	fail()
+	if items, err := l.Claim(ctx, ClaimOptions{Treatment: "lint"}); err != nil || len(items) != 0 {
+		t.Fatalf("claim after max_attempts = %v, %v; want nothing", items, err)
+	}
+
+	mustEnqueue(t, l.store, "lint", "/src/b.go")
+	if _, err := l.Complete(ctx, CompleteOptions{Path: "/src/b.go", Treatment: "lint"}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	entries, err := l.List(ctx, ListOptions{Treatment: "lint", State: "done"})
+	if err != nil || len(entries) != 1 || entries[0].NextAt == "" {
+		t.Fatalf("done entries = %+v, %v; want next_at from the default revisit", entries, err)
+	}
+}
+
+func TestLedger_ReclaimsStaleVersions_When_Defined(t *testing.T) {
+	l := newTestLedger(t, `{"treatments": {"review": {"version": "prompt-2"}}}`)
+	ctx := context.Background()
+	mustEnqueue(t, l.store, "review", "/src/a.go", "/src/b.go")
+	if _, err := l.Complete(ctx, CompleteOptions{Path: "/src/a.go", Treatment: "review", Version: "prompt-1"}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if _, err := l.Complete(ctx, CompleteOptions{Path: "/src/b.go", Treatment: "review"}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	versions, err := l.Versions(ctx, "review")
+	if err != nil {
+		t.Fatalf("Versions: %v", err)
+	}
+	want := []VersionCount{
+		{Treatment: "review", Version: "prompt-1", Done: 1},
+		{Treatment: "review", Version: "prompt-2", Done: 1, Current: true},
+	}
+	if len(versions) != 2 || versions[0] != want[0] || versions[1] != want[1] {
+		t.Fatalf("versions = %+v, want %+v", versions, want)
+	}
+	items, err := l.Claim(ctx, ClaimOptions{Treatment: "review", N: 5})
+	if err != nil || len(items) != 1 || items[0].Path != "/src/a.go" {
+		t.Fatalf("claim = %v, %v; want only the prompt-1 result", items, err)
+	}
+}
+
+func TestLedger_WaitsForUpstream_When_DependencyDeclared(t *testing.T) {
+	l := newTestLedger(t, `{"treatments": {"gofmt": {}, "review": {"after": ["gofmt"]}}}`)
+	ctx := context.Background()
+	mustEnqueue(t, l.store, "gofmt", "/src/a.go")
+	// gofmt saw b.go with other content than review did.
+	if _, err := l.store.Enqueue(ctx, "gofmt", []Item{{Path: "/src/b.go", ContentHash: "changed"}}); err != nil {
+		t.Fatal(err)
+	}
+	mustEnqueue(t, l.store, "review", "/src/a.go", "/src/b.go")
+
+	if items, err := l.Claim(ctx, ClaimOptions{Treatment: "review", N: 5}); err != nil || len(items) != 0 {
+		t.Fatalf("claim before gofmt = %v, %v; want nothing", items, err)
+	}
+	if _, err := l.Complete(ctx, CompleteOptions{Path: "/src/a.go", Treatment: "gofmt"}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	items, err := l.Claim(ctx, ClaimOptions{Treatment: "review", N: 5})
+	if err != nil || len(items) != 1 || items[0].Path != "/src/a.go" {
+		t.Fatalf("claim after gofmt(a) = %v, %v; want a.go", items, err)
+	}
+
+	// Upstream done for different content does not count.
+	if _, err := l.Complete(ctx, CompleteOptions{Path: "/src/b.go", Treatment: "gofmt"}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	items, err = l.Claim(ctx, ClaimOptions{Treatment: "review", N: 5})
+	if err != nil || len(items) != 1 || items[0].Path != "/src/a.go" {
+		t.Fatalf("claim with stale upstream for b.go = %v, %v; want only a.go", items, err)
+	}
+}
+
+func TestLedger_EnqueuesOnDone_When_Defined(t *testing.T) {
+	l := newTestLedger(t, `{"treatments": {
+		"extract": {"on_done": ["summarize"]},
+		"summarize": {},
+		"index": {"include": ["*.md"]}
+	}}`)
+	ctx := context.Background()
+	mustEnqueue(t, l.store, "extract", "/src/a.go")
+
+	if _, err := l.Complete(ctx, CompleteOptions{Path: "/src/a.go", Treatment: "extract", Then: []string{"index"}}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if n := countRows(t, l.store, "summarize"); n != 1 {
+		t.Fatalf("summarize has %d rows, want 1 from on_done", n)
+	}
+	if n := countRows(t, l.store, "index"); n != 0 {
+		t.Fatalf("index has %d rows, want 0: its include rejects .go", n)
+	}
+	if _, err := l.Complete(ctx, CompleteOptions{Path: "/src/a.go", Treatment: "extract", Then: []string{"indx"}}); !errors.Is(err, ErrUnknownTreatment) {
+		t.Fatalf("Complete with then=indx err = %v, want ErrUnknownTreatment", err)
+	}
+}
+
+func TestLedger_ListsNewestFirst_When_Requested(t *testing.T) {
+	l, s := newFileLedger(t)
+	ctx := context.Background()
+	mustEnqueue(t, l.store, "lint", "/src/a.go", "/src/b.go", "/src/c.go")
+	for i, p := range []string{"/src/c.go", "/src/a.go", "/src/b.go"} {
+		if _, err := l.Complete(ctx, CompleteOptions{Path: p, Treatment: "lint"}); err != nil {
+			t.Fatalf("Complete: %v", err)
+		}
+		// done_at has one-second resolution.
+		if _, err := s.db.Exec("UPDATE queue SET done_at=? WHERE path=?", time.Date(2026, 1, 1, 0, 0, i, 0, time.UTC).Format(time.RFC3339), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := l.List(ctx, ListOptions{Treatment: "lint", Newest: true, Limit: 2})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Path != "/src/b.go" || entries[1].Path != "/src/a.go" {
+		t.Fatalf("entries = %+v, want b.go then a.go", entries)
+	}
+}
+
+func TestLedger_CompactsResultJSON_When_Valid(t *testing.T) {
+	l := newTestLedger(t, "")
+	ctx := context.Background()
+	mustEnqueue(t, l.store, "review", "/src/a.go")
+
+	if _, err := l.Complete(ctx, CompleteOptions{Path: "/src/a.go", Treatment: "review", ResultJSON: `{"score": 7,`}); !errors.Is(err, ErrInvalidResultJSON) {
+		t.Fatalf("Complete(bad JSON) err = %v, want ErrInvalidResultJSON", err)
+	}
+	if _, err := l.Complete(ctx, CompleteOptions{Path: "/src/a.go", Treatment: "review", ResultJSON: "{\n  \"score\": 7\n}"}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	entries, err := l.List(ctx, ListOptions{Where: "$.score > 5"})
+	if err != nil || len(entries) != 1 || entries[0].ResultJSON != `{"score":7}` {
+		t.Fatalf("List = %+v, %v; want the compacted document", entries, err)
+	}
+}
+
+func TestFileHash_ReturnsDigest_When_FileReadable(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sample.txt")
+	if err := os.WriteFile(path, []byte("hello world\n"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	got, err := FileHash(path)
+	if err != nil {
+		t.Fatalf("FileHash error: %v", err)
+	}
+
+	// Known SHA-256 digest for "hello world\n"
+	const want = "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"
+	if got != want {
+		t.Fatalf("FileHash = %s, want %s", got, want)
+	}
+}
+
+func TestFileHash_ReturnsError_When_FileMissing(t *testing.T) {
+	t.Parallel()
+
+	if _, err := FileHash(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
+
+func TestLedger_ClaimWaitsForToken_When_RateLimited(t *testing.T) {
+	l := newTestLedger(t, `{"treatments": {"llm": {"rate_limit": {"requests": 4, "per": "1s", "burst": 1}}}}`)
+	ctx := context.Background()
+	mustEnqueue(t, l.store, "llm", "/src/a.go", "/src/b.go")
+
+	if items, err := l.Claim(ctx, ClaimOptions{Treatment: "llm", N: 2}); err != nil || len(items) != 1 {
+		t.Fatalf("Claim = %v, %v; want one path", items, err)
+	}
+	start := time.Now()
+	items, err := l.Claim(ctx, ClaimOptions{Treatment: "llm", Wait: 5 * time.Second})
+	if err != nil || len(items) != 1 {
+		t.Fatalf("waiting Claim = %v, %v; want the second path", items, err)
+	}
+	if waited := time.Since(start); waited < 150*time.Millisecond || waited > 2*time.Second {
+		t.Fatalf("waited %v for a token, want about 250ms", waited)
+	}
+}
+
+func TestSQLiteStore_SharesRateLimit_When_OpenedTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	var stores [2]*SQLiteStore
+	for i := range stores {
+		s, err := OpenSQLiteStore(path, Options{})
+		if err != nil {
+			t.Fatalf("OpenSQLiteStore: %v", err)
+		}
+		t.Cleanup(func() { _ = s.Close() })
+		stores[i] = s
+	}
+	mustEnqueue(t, stores[0], "llm", "/src/a.go", "/src/b.go", "/src/c.go")
+	q := ClaimQuery{Treatment: "llm", N: 2, Rate: 1.0 / 3600, Burst: 2}
+	if items, err := stores[0].Claim(context.Background(), q); err != nil || len(items) != 2 {
+		t.Fatalf("first handle claimed %v, %v; want 2", items, err)
+	}
+	if items, err := stores[1].Claim(context.Background(), q); err != nil || len(items) != 0 {
+		t.Fatalf("second handle claimed %v, %v; want none from the shared bucket", items, err)
+	}
+}
+
+func TestLedger_RequiresLease_When_ConcurrencyCapped(t *testing.T) {
+	l := newTestLedger(t, `{"treatments": {"heavy": {"concurrency": 1}}}`)
+	ctx := context.Background()
+	mustEnqueue(t, l.store, "heavy", "/src/a.go", "/src/b.go")
+
+	if _, err := l.Claim(ctx, ClaimOptions{Treatment: "heavy"}); !errors.Is(err, ErrLeaseRequired) {
+		t.Fatalf("unleased Claim err = %v, want ErrLeaseRequired", err)
+	}
+	if items, err := l.Claim(ctx, ClaimOptions{Treatment: "heavy", N: 2, Lease: time.Minute}); err != nil || len(items) != 1 {
+		t.Fatalf("Claim = %v, %v; want one path under the cap", items, err)
+	}
+	rows, err := l.Stats(ctx, "heavy")
+	if err != nil || len(rows) != 1 || rows[0].Running != 1 || rows[0].MaxRunning != 1 {
+		t.Fatalf("Stats = %+v, %v; want 1 of 1 running", rows, err)
+	}
+}
+
+func TestLedger_StopsClaims_When_BudgetSpent(t *testing.T) {
+	l := newTestLedger(t, `{"treatments": {"llm": {"budget": {"cost": 1, "tokens": 10000, "per": "24h"}}}}`)
+	ctx := context.Background()
+	mustEnqueue(t, l.store, "llm", "/src/a.go", "/src/b.go")
+
+	items, err := l.Claim(ctx, ClaimOptions{Treatment: "llm"})
+	if err != nil || len(items) != 1 {
+		t.Fatalf("Claim = %v, %v; want one path within budget", items, err)
+	}
+	if _, err := l.Complete(ctx, CompleteOptions{Path: items[0].Path, Treatment: "llm", Cost: -1}); !errors.Is(err, ErrInvalidSpend) {
+		t.Fatalf("Complete with a negative cost err = %v, want ErrInvalidSpend", err)
+	}
+	if _, err := l.Complete(ctx, CompleteOptions{Path: items[0].Path, Treatment: "llm", Cost: 1.5, Tokens: 4500}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if _, err := l.Claim(ctx, ClaimOptions{Treatment: "llm", Wait: time.Minute}); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("Claim past the budget err = %v, want ErrBudgetExhausted without waiting", err)
+	}
+	costs, err := l.Costs(ctx, "llm")
+	if err != nil || len(costs) != 1 || costs[0].Cost != 1.5 || costs[0].Tokens != 4500 {
+		t.Fatalf("Costs = %+v, %v; want the completed run's spend", costs, err)
+	}
+}
@@ -0,0 +1,748 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the queue in process memory. It has no snapshots or
+// counters and loses everything on exit, which suits tests and short-lived
+// embedded queues. Artifacts are kept in memory too.
+type MemoryStore struct {
+	mu        sync.Mutex
+	rows      map[memKey]*memRow
+	runs      []Run
+	findings  map[int64][]Finding
+	artifacts map[string][]byte
+	audit     []AuditEntry
+	buckets   map[string]bucket
+}
+
+var (
+	_ Store         = (*MemoryStore)(nil)
+	_ ArtifactStore = (*MemoryStore)(nil)
+)
+
+type memKey struct{ path, treatment string }
+
+// memRow mirrors a queue row. Empty strings stand for NULL, and timestamps
+// are stored pre-formatted so comparisons match SQLite's string ordering.
+type memRow struct {
+	path, pathHash, contentHash, treatment string
+	doneAt, result, resultJSON             string
+	nextAt, version                        string
+	claimedAt, leasedUntil                 string
+	failedAt, errMsg                       string
+	worker                                 string
+	attempts                               int
+}
+
+// NewMemoryStore returns an empty store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{rows: map[memKey]*memRow{}, findings: map[int64][]Finding{}, artifacts: map[string][]byte{}, buckets: map[string]bucket{}}
+}
+
+// memNow returns the current time as next_at/leased_until and as
+// done_at/failed_at format it.
+func memNow() (t time.Time, datetime, rfc3339 string) {
+	t = time.Now().UTC().Truncate(time.Second)
+	return t, t.Format(time.DateTime), t.Format(time.RFC3339)
+}
+
+// applyModifier evaluates DATETIME(t, m) for the modifiers treatments accept,
+// e.g. "14 days" or "-1 seconds". Anything else yields NULL, as in SQLite.
+func applyModifier(t time.Time, m string) string {
+	f := strings.Fields(m)
+	if len(f) != 2 {
+		return ""
+	}
+	n, err := strconv.ParseFloat(f[0], 64)
+	if err != nil {
+		return ""
+	}
+	switch strings.TrimSuffix(f[1], "s") {
+	case "second":
+		t = t.Add(time.Duration(n * float64(time.Second)))
+	case "minute":
+		t = t.Add(time.Duration(n * float64(time.Minute)))
+	case "hour":
+		t = t.Add(time.Duration(n * float64(time.Hour)))
+	case "day":
+		t = t.Add(time.Duration(n * 24 * float64(time.Hour)))
+	case "month", "year":
+		if n != math.Trunc(n) {
+			return ""
+		}
+		if f[1][0] == 'm' {
+			t = t.AddDate(0, int(n), 0)
+		} else {
+			t = t.AddDate(int(n), 0, 0)
+		}
+	default:
+		return ""
+	}
+	return t.Format(time.DateTime)
+}
+
+func (r *memRow) state(now string) string {
+	switch {
+	case r.doneAt != "":
+		return "done"
+	case r.leasedUntil > now:
+		return "leased"
+	case r.failedAt != "":
+		return "failed"
+	}
+	return "pending"
+}
+
+func (r *memRow) due(now string) bool {
+	return r.nextAt != "" && r.nextAt <= now
+}
+
+// matches implements stateFilters.
+func (r *memRow) matches(state, now string) bool {
+	switch state {
+	case "pending":
+		return r.doneAt == ""
+	case "leased":
+		return r.doneAt == "" && r.leasedUntil > now
+	case "failed":
+		return r.doneAt == "" && r.failedAt != ""
+	case "done":
+		return r.doneAt != ""
+	case "due":
+		return r.doneAt != "" && r.due(now)
+	}
+	return false
+}
+
+// claimable implements the claimable and upstreamDone conditions.
+func (m *MemoryStore) claimable(r *memRow, q ClaimQuery, now string) bool {
+	if r.leasedUntil != "" && r.leasedUntil > now {
+		return false
+	}
+	if r.doneAt == "" {
+		if r.failedAt != "" && !r.due(now) {
+			return false
+		}
+	} else if !r.due(now) && (q.Version == "" || r.version == q.Version) {
+		return false
+	}
+	for _, dep := range q.After {
+		up, ok := m.rows[memKey{r.path, dep}]
+		if !ok || up.contentHash != r.contentHash || up.doneAt == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *memRow) entry(now string) Entry {
+	return Entry{
+		Path: r.path, PathHash: r.pathHash, ContentHash: r.contentHash, Treatment: r.treatment,
+		State: r.state(now), DoneAt: r.doneAt, Result: r.result, NextAt: r.nextAt,
+		LeasedUntil: r.leasedUntil, FailedAt: r.failedAt, Error: r.errMsg,
+		Attempts: r.attempts, Version: r.version, ResultJSON: r.resultJSON,
+	}
+}
+
+// sorted returns the rows keep admits in treatment, path_hash order.
+func (m *MemoryStore) sorted(keep func(*memRow) bool) []*memRow {
+	var out []*memRow
+	for _, r := range m.rows {
+		if keep(r) {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].treatment != out[j].treatment {
+			return out[i].treatment < out[j].treatment
+		}
+		return out[i].pathHash < out[j].pathHash
+	})
+	return out
+}
+
+func (m *MemoryStore) Enqueue(ctx context.Context, treatment string, items []Item) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var inserted int64
+	for _, it := range items {
+		k := memKey{it.Path, treatment}
+		if _, ok := m.rows[k]; !ok {
+			m.rows[k] = &memRow{path: it.Path, pathHash: PathHash(it.Path), contentHash: it.ContentHash, treatment: treatment}
+			inserted++
+		}
+	}
+	m.recordAudit(ctx, "enqueue", treatment, inserted)
+	return len(items), nil
+}
+
+func (m *MemoryStore) Reopen(ctx context.Context, treatment string, items []Item) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := 0
+	for _, it := range items {
+		k := memKey{it.Path, treatment}
+		if r, ok := m.rows[k]; ok && r.contentHash == it.ContentHash {
+			continue
+		}
+		m.rows[k] = &memRow{path: it.Path, pathHash: PathHash(it.Path), contentHash: it.ContentHash, treatment: treatment}
+		changed++
+	}
+	if changed > 0 {
+		m.recordAudit(ctx, "reopen", treatment, int64(changed))
+	}
+	return changed, nil
+}
+
+func (m *MemoryStore) Claim(ctx context.Context, q ClaimQuery) ([]Claimed, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, now, stamp := memNow()
+	var leased string
+	if q.Lease > 0 {
+		leased = applyModifier(t, leaseModifier(q.Lease))
+	}
+	if q.Budget != nil {
+		var cost float64
+		var tokens int64
+		for _, run := range m.runs {
+			if run.Treatment == q.Treatment && run.At >= q.Budget.Since {
+				cost, tokens = cost+run.Cost, tokens+run.Tokens
+			}
+		}
+		if err := budgetErr(q, cost, tokens); err != nil {
+			return nil, err
+		}
+	}
+	limit := q.N
+	if q.MaxLeases > 0 {
+		live := 0
+		for _, r := range m.rows {
+			if r.treatment == q.Treatment && r.leasedUntil > now {
+				live++
+			}
+		}
+		limit = min(limit, q.MaxLeases-live)
+	}
+	var tokens bucket
+	if q.Rate > 0 {
+		b, used := m.buckets[q.Treatment]
+		tokens = b.refill(q, unixNow(), used)
+		limit = tokens.allowance(limit)
+	}
+	var out []Claimed
+	for _, r := range m.sorted(func(r *memRow) bool {
+		return r.treatment == q.Treatment && r.pathHash > q.Cursor && m.claimable(r, q, now)
+	}) {
+		if len(out) >= limit {
+			break
+		}
+		r.claimedAt, r.leasedUntil, r.worker = stamp, leased, q.Worker
+		out = append(out, Claimed{Path: r.path, PathHash: r.pathHash, ContentHash: r.contentHash})
+	}
+	if q.Rate > 0 && len(out) > 0 {
+		tokens.tokens -= float64(len(out))
+		m.buckets[q.Treatment] = tokens
+	}
+	if len(out) > 0 {
+		m.recordAudit(ctx, "claim", q.Treatment, int64(len(out)))
+	}
+	return out, nil
+}
+
+func (m *MemoryStore) NextClaimable(ctx context.Context, q ClaimQuery) (time.Time, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, now, _ := memNow()
+	var next string
+	for _, r := range m.rows {
+		if r.treatment != q.Treatment || r.pathHash <= q.Cursor {
+			continue
+		}
+		if r.leasedUntil > now && (next == "" || r.leasedUntil < next) {
+			next = r.leasedUntil
+		}
+		if r.nextAt > now && (r.doneAt != "" || r.failedAt != "") && (next == "" || r.nextAt < next) {
+			next = r.nextAt
+		}
+	}
+	var at time.Time
+	if next != "" {
+		var err error
+		if at, err = time.ParseInLocation(time.DateTime, next, time.UTC); err != nil {
+			return time.Time{}, false, err
+		}
+	}
+	if b, used := m.buckets[q.Treatment]; q.Rate > 0 && used {
+		if refill, ok := b.refill(q, unixNow(), true).nextToken(q.Rate); ok && (next == "" || refill.Before(at)) {
+			return refill, true, nil
+		}
+	}
+	return at, next != "", nil
+}
+
+func (m *MemoryStore) Complete(ctx context.Context, opts CompleteOptions) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.rows[memKey{opts.Path, opts.Treatment}]
+	if !ok {
+		if err := spendWithoutRow(opts.Cost, opts.Tokens, opts.Path, opts.Treatment); err != nil {
+			return 0, err
+		}
+		m.recordAudit(ctx, "done", opts.Treatment, 0)
+		return 0, nil
+	}
+	t, _, stamp := memNow()
+	r.doneAt, r.result, r.resultJSON, r.version = stamp, opts.Result, opts.ResultJSON, opts.Version
+	r.nextAt = ""
+	if opts.Revisit != "" {
+		r.nextAt = applyModifier(t, opts.Revisit)
+	}
+	r.claimedAt, r.leasedUntil, r.failedAt, r.errMsg = "", "", "", ""
+	run := m.record(r, "done", runExtras{opts.Artifact, r.worker, opts.Cost, opts.Tokens}, stamp)
+	r.worker = ""
+	for _, f := range opts.Findings {
+		f.Run, f.Path, f.Treatment = run, r.path, r.treatment
+		f.Fingerprint = defaultFingerprint(f)
+		m.findings[run] = append(m.findings[run], f)
+	}
+	for _, next := range opts.Then {
+		k := memKey{r.path, next}
+		if f, ok := m.rows[k]; !ok || f.contentHash != r.contentHash {
+			m.rows[k] = &memRow{path: r.path, pathHash: r.pathHash, contentHash: r.contentHash, treatment: next}
+		}
+	}
+	m.recordAudit(ctx, "done", opts.Treatment, 1)
+	return 1, nil
+}
+
+func (m *MemoryStore) Fail(ctx context.Context, opts FailOptions) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.rows[memKey{opts.Path, opts.Treatment}]
+	if !ok || (r.doneAt != "" && (r.claimedAt == "" || r.claimedAt < r.doneAt)) {
+		if err := spendWithoutRow(opts.Cost, opts.Tokens, opts.Path, opts.Treatment); err != nil {
+			return 0, err
+		}
+		m.recordAudit(ctx, "fail", opts.Treatment, 0)
+		return 0, nil
+	}
+	t, _, stamp := memNow()
+	r.doneAt, r.result, r.resultJSON, r.version = "", "", "", ""
+	r.failedAt, r.errMsg = stamp, opts.Error
+	r.attempts++
+	r.nextAt = ""
+	if opts.Revisit != "" {
+		r.nextAt = applyModifier(t, opts.Revisit)
+	} else if opts.Retry != nil && r.attempts < opts.Retry.MaxAttempts {
+		r.nextAt = applyModifier(t, opts.Retry.Backoff)
+	}
+	r.claimedAt, r.leasedUntil = "", ""
+	m.record(r, "failed", runExtras{opts.Artifact, r.worker, opts.Cost, opts.Tokens}, stamp)
+	r.worker = ""
+	m.recordAudit(ctx, "fail", opts.Treatment, 1)
+	return 1, nil
+}
+
+// record appends r's current outcome to runs with x and returns its id; the
+// caller holds m.mu.
+func (m *MemoryStore) record(r *memRow, state string, x runExtras, at string) int64 {
+	run := Run{
+		ID: int64(len(m.runs) + 1), Path: r.path, Treatment: r.treatment, ContentHash: r.contentHash,
+		State: state, Result: r.result, Error: r.errMsg, Artifact: x.artifact, Worker: x.worker,
+		Cost: x.cost, Tokens: x.tokens, At: at,
+	}
+	if state == "done" {
+		run.Version = r.version
+	}
+	m.runs = append(m.runs, run)
+	return run.ID
+}
+
+func (m *MemoryStore) History(ctx context.Context, treatment, path string) ([]Run, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Run
+	for i := len(m.runs) - 1; i >= 0; i-- {
+		if r := m.runs[i]; r.Treatment == treatment && r.Path == path {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func (m *MemoryStore) Findings(ctx context.Context, q FindingsQuery) ([]Finding, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Done runs per path, newest first; Previous reads the second.
+	done := map[memKey][]int64{}
+	for i := len(m.runs) - 1; i >= 0; i-- {
+		if r := m.runs[i]; r.State == "done" {
+			k := memKey{r.Path, r.Treatment}
+			done[k] = append(done[k], r.ID)
+		}
+	}
+	rank := 0
+	if q.Previous {
+		rank = 1
+	}
+	var out []Finding
+	for _, runs := range done {
+		if len(runs) <= rank {
+			continue
+		}
+		run := runs[rank]
+		for _, f := range m.findings[run] {
+			if (q.Treatment == "" || f.Treatment == q.Treatment) && (q.Rule == "" || f.Rule == q.Rule) && (q.Path == "" || f.Path == q.Path) {
+				out = append(out, f)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		switch {
+		case a.Path != b.Path:
+			return a.Path < b.Path
+		case a.Treatment != b.Treatment:
+			return a.Treatment < b.Treatment
+		case a.StartLine != b.StartLine:
+			return a.StartLine < b.StartLine
+		case a.EndLine != b.EndLine:
+			return a.EndLine < b.EndLine
+		case a.Rule != b.Rule:
+			return a.Rule < b.Rule
+		}
+		return a.Message < b.Message
+	})
+	return out, nil
+}
+
+func (m *MemoryStore) PutArtifact(ctx context.Context, r io.Reader) (string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.artifacts[hash]; !ok {
+		m.artifacts[hash] = b
+	}
+	return hash, nil
+}
+
+func (m *MemoryStore) OpenArtifact(ctx context.Context, hash string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.artifacts[hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrArtifactNotFound, hash)
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (m *MemoryStore) Get(ctx context.Context, treatment, path string) (Entry, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return Entry{}, false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.rows[memKey{path, treatment}]
+	if !ok {
+		return Entry{}, false, nil
+	}
+	_, now, _ := memNow()
+	return r.entry(now), true, nil
+}
+
+func (m *MemoryStore) Stats(ctx context.Context, treatment string) ([]Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, now, _ := memNow()
+	var out []Stats
+	for _, r := range m.sorted(func(r *memRow) bool { return treatment == "" || r.treatment == treatment }) {
+		if len(out) == 0 || out[len(out)-1].Treatment != r.treatment {
+			out = append(out, Stats{Treatment: r.treatment})
+		}
+		s := &out[len(out)-1]
+		if r.leasedUntil > now {
+			s.Running++
+		}
+		for state, n := range map[string]*int{"pending": &s.Pending, "leased": &s.Leased, "failed": &s.Failed, "done": &s.Done, "due": &s.Due} {
+			if r.matches(state, now) {
+				*n++
+			}
+		}
+	}
+	return out, nil
+}
+
+func (m *MemoryStore) Versions(ctx context.Context, treatment string) ([]VersionCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := map[[2]string]int{}
+	for _, r := range m.rows {
+		if r.doneAt != "" && (treatment == "" || r.treatment == treatment) {
+			counts[[2]string{r.treatment, r.version}]++
+		}
+	}
+	out := make([]VersionCount, 0, len(counts))
+	for k, n := range counts {
+		out = append(out, VersionCount{Treatment: k[0], Version: k[1], Done: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Treatment != out[j].Treatment {
+			return out[i].Treatment < out[j].Treatment
+		}
+		return out[i].Version < out[j].Version
+	})
+	return out, nil
+}
+
+func (m *MemoryStore) List(ctx context.Context, opts ListOptions) ([]Entry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if _, ok := stateFilters[opts.State]; opts.State != "" && !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownState, opts.State)
+	}
+	conds, err := parseWhere(opts.Where)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, now, _ := memNow()
+	rows := m.sorted(func(r *memRow) bool {
+		return (opts.Treatment == "" || r.treatment == opts.Treatment) && (opts.State == "" || r.matches(opts.State, now)) &&
+			matchWhere(conds, r.resultJSON)
+	})
+	if opts.Newest {
+		latest := func(r *memRow) string {
+			if r.failedAt != "" {
+				return r.failedAt
+			}
+			return r.doneAt
+		}
+		sort.SliceStable(rows, func(i, j int) bool {
+			if a, b := latest(rows[i]), latest(rows[j]); a != b {
+				return a > b
+			}
+			return rows[i].pathHash < rows[j].pathHash
+		})
+	}
+	if opts.Limit > 0 && len(rows) > opts.Limit {
+		rows = rows[:opts.Limit]
+	}
+	out := make([]Entry, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, r.entry(now))
+	}
+	return out, nil
+}
+
+func (m *MemoryStore) Aggregate(ctx context.Context, treatment, agg string) ([]AggregateRow, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	a, err := parseAggregate(agg)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	values := map[string][]float64{}
+	for _, r := range m.rows {
+		if r.doneAt == "" || (treatment != "" && r.treatment != treatment) {
+			continue
+		}
+		if v, ok := extractJSON(r.resultJSON, a.path); ok {
+			if f, ok := v.(float64); ok {
+				values[r.treatment] = append(values[r.treatment], f)
+			}
+		}
+	}
+	out := make([]AggregateRow, 0, len(values))
+	for t, vs := range values {
+		out = append(out, AggregateRow{Treatment: t, Value: aggregateValues(a, vs), Count: len(vs)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Treatment < out[j].Treatment })
+	return out, nil
+}
+
+func (m *MemoryStore) Costs(ctx context.Context, treatment string) ([]CostRow, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byDay := map[[2]string]*CostRow{}
+	for _, run := range m.runs {
+		if (run.Cost == 0 && run.Tokens == 0) || (treatment != "" && run.Treatment != treatment) {
+			continue
+		}
+		k := [2]string{run.Treatment, run.At[:10]}
+		c, ok := byDay[k]
+		if !ok {
+			c = &CostRow{Treatment: k[0], Day: k[1]}
+			byDay[k] = c
+		}
+		c.Runs++
+		c.Cost += run.Cost
+		c.Tokens += run.Tokens
+	}
+	out := make([]CostRow, 0, len(byDay))
+	for _, c := range byDay {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Treatment != out[j].Treatment {
+			return out[i].Treatment < out[j].Treatment
+		}
+		return out[i].Day < out[j].Day
+	})
+	return out, nil
+}
+
+func (m *MemoryStore) Workers(ctx context.Context, q WorkersQuery) ([]WorkerStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, now, _ := memNow()
+	byID := map[string]*WorkerStats{}
+	get := func(id string) *WorkerStats {
+		w, ok := byID[id]
+		if !ok {
+			w = &WorkerStats{Worker: id}
+			byID[id] = w
+		}
+		return w
+	}
+	for _, r := range m.rows {
+		if r.worker == "" || (q.Treatment != "" && r.treatment != q.Treatment) {
+			continue
+		}
+		w := get(r.worker)
+		w.Active++
+		if (r.leasedUntil != "" && r.leasedUntil <= now) || (r.leasedUntil == "" && r.claimedAt < q.StaleBefore) {
+			w.Stale++
+		}
+		w.LastSeen = max(w.LastSeen, r.claimedAt)
+	}
+	for _, run := range m.runs {
+		if run.Worker == "" || (q.Treatment != "" && run.Treatment != q.Treatment) {
+			continue
+		}
+		w := get(run.Worker)
+		if run.State == "done" {
+			w.Done++
+			if run.At >= q.Since {
+				w.Recent++
+			}
+		} else {
+			w.Failed++
+		}
+		w.LastSeen = max(w.LastSeen, run.At)
+	}
+	return sortedWorkers(byID), nil
+}
+
+func (m *MemoryStore) Reset(ctx context.Context, treatment string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for k := range m.rows {
+		if k.treatment == treatment {
+			delete(m.rows, k)
+			n++
+		}
+	}
+	m.recordAudit(ctx, "reset", treatment, n)
+	return n, nil
+}
+
+// recordAudit appends a mutation to the audit log; the caller holds m.mu,
+// so the entry is written with the change it describes.
+func (m *MemoryStore) recordAudit(ctx context.Context, command, treatment string, rows int64) {
+	e := auditEntry(ctx, command, treatment, rows)
+	e.ID = int64(len(m.audit) + 1)
+	m.audit = append(m.audit, e)
+}
+
+func (m *MemoryStore) AuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []AuditEntry
+	for i := len(m.audit) - 1; i >= 0 && (q.Limit <= 0 || len(out) < q.Limit); i-- {
+		e := m.audit[i]
+		if (q.Since == "" || e.At >= q.Since) && (q.Command == "" || e.Command == q.Command) {
+			out = append(out, e)
+		}
+	}
+	slices.Reverse(out)
+	return out, nil
+}
+
+// Close is a no-op; the rows stay readable until the store is dropped.
+func (m *MemoryStore) Close() error {
+	return nil
+}
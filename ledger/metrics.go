@@ -0,0 +1,146 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Counters live in the ledger rather than in process memory so that short
+// CLI invocations and a long-running server add to the same totals.
+const countersTable = `CREATE TABLE IF NOT EXISTS counters (
+  treatment TEXT NOT NULL,
+  name TEXT NOT NULL,
+  value REAL NOT NULL DEFAULT 0,
+  PRIMARY KEY (treatment, name)
+)`
+
+const (
+	counterEnqueued  = "enqueued"
+	counterClaimed   = "claimed"
+	counterCompleted = "completed"
+	latencySum       = "latency_sum"
+	latencyCount     = "latency_count"
+	latencyBucket    = "latency_le_"
+)
+
+// latencyBuckets are the claim-to-done histogram bounds in seconds.
+var latencyBuckets = []float64{0.5, 1, 5, 15, 60, 300, 900, 3600, 14400}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func bumpCounter(ctx context.Context, tx execer, treatment, name string, delta float64) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO counters (treatment, name, value) VALUES (?, ?, ?)
+		ON CONFLICT (treatment, name) DO UPDATE SET value = value + excluded.value
+	`, treatment, name, delta)
+	return err
+}
+
+// observeLatency records one claim-to-done duration in the histogram.
+func observeLatency(ctx context.Context, tx execer, treatment string, seconds float64) error {
+	if err := bumpCounter(ctx, tx, treatment, latencySum, seconds); err != nil {
+		return err
+	}
+	if err := bumpCounter(ctx, tx, treatment, latencyCount, 1); err != nil {
+		return err
+	}
+	for _, le := range latencyBuckets {
+		if seconds <= le {
+			if err := bumpCounter(ctx, tx, treatment, latencyBucket+formatFloat(le), 1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func readCounters(ctx context.Context, db *sql.DB) (map[string]map[string]float64, error) {
+	rows, err := db.QueryContext(ctx, "SELECT treatment, name, value FROM counters")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	out := map[string]map[string]float64{}
+	for rows.Next() {
+		var t, name string
+		var v float64
+		if err := rows.Scan(&t, &name, &v); err != nil {
+			return nil, err
+		}
+		if out[t] == nil {
+			out[t] = map[string]float64{}
+		}
+		out[t][name] = v
+	}
+	return out, rows.Err()
+}
+
+// writeMetrics renders queue gauges from the status aggregate plus the
+// persisted counters.
+func writeMetrics(w io.Writer, status []Stats, counters map[string]map[string]float64) {
+	gauges := []struct {
+		name, help string
+		value      func(Stats) int
+	}{
+		{"next_queue_pending", "Rows not yet done, including leased and failed rows.", func(r Stats) int { return r.Pending }},
+		{"next_queue_leased", "Rows under a live lease.", func(r Stats) int { return r.Leased }},
+		{"next_queue_failed", "Rows whose last attempt failed.", func(r Stats) int { return r.Failed }},
+		{"next_queue_done", "Rows with a result.", func(r Stats) int { return r.Done }},
+		{"next_queue_due", "Done rows whose revisit time has passed.", func(r Stats) int { return r.Due }},
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for _, r := range status {
+			fmt.Fprintf(w, "%s{treatment=\"%s\"} %d\n", g.name, escapeLabel(r.Treatment), g.value(r))
+		}
+	}
+
+	treatments := make([]string, 0, len(counters))
+	for t := range counters {
+		treatments = append(treatments, t)
+	}
+	sort.Strings(treatments)
+
+	totals := []struct{ name, key, help string }{
+		{"next_enqueued_total", counterEnqueued, "Paths newly added to the queue."},
+		{"next_claimed_total", counterClaimed, "Paths handed out by claim."},
+		{"next_completed_total", counterCompleted, "Paths marked done."},
+	}
+	for _, c := range totals {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, t := range treatments {
+			fmt.Fprintf(w, "%s{treatment=\"%s\"} %s\n", c.name, escapeLabel(t), formatFloat(counters[t][c.key]))
+		}
+	}
+
+	const hist = "next_claim_to_done_seconds"
+	fmt.Fprintf(w, "# HELP %s Time from claim to done.\n# TYPE %s histogram\n", hist, hist)
+	for _, t := range treatments {
+		c, label := counters[t], escapeLabel(t)
+		for _, le := range latencyBuckets {
+			fmt.Fprintf(w, "%s_bucket{treatment=\"%s\",le=\"%s\"} %s\n",
+				hist, label, formatFloat(le), formatFloat(c[latencyBucket+formatFloat(le)]))
+		}
+		fmt.Fprintf(w, "%s_bucket{treatment=\"%s\",le=\"+Inf\"} %s\n", hist, label, formatFloat(c[latencyCount]))
+		fmt.Fprintf(w, "%s_sum{treatment=\"%s\"} %s\n", hist, label, formatFloat(c[latencySum]))
+		fmt.Fprintf(w, "%s_count{treatment=\"%s\"} %s\n", hist, label, formatFloat(c[latencyCount]))
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
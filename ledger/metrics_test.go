@@ -0,0 +1,49 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics_ReportsGaugesCountersAndLatency(t *testing.T) {
+	l, s := newFileLedger(t)
+	ctx := context.Background()
+	mustEnqueue(t, s, "lint", "/src/a.go", "/src/b.go")
+	mustEnqueue(t, s, "lint", "/src/a.go") // duplicate: not counted again
+
+	if _, err := l.Claim(ctx, ClaimOptions{Treatment: "lint"}); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	// Pretend the claim happened ten seconds ago.
+	if _, err := s.db.Exec(`UPDATE queue SET claimed_at=strftime('%Y-%m-%dT%H:%M:%SZ', 'now', '-10 seconds') WHERE claimed_at IS NOT NULL`); err != nil {
+		t.Fatalf("backdate claim: %v", err)
+	}
+	for _, p := range []string{"/src/a.go", "/src/b.go"} {
+		if _, err := l.Complete(ctx, CompleteOptions{Path: p, Treatment: "lint"}); err != nil {
+			t.Fatalf("Complete: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := l.WriteMetrics(ctx, &buf); err != nil {
+		t.Fatalf("WriteMetrics: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`next_queue_done{treatment="lint"} 2`,
+		`next_queue_pending{treatment="lint"} 0`,
+		`next_enqueued_total{treatment="lint"} 2`,
+		`next_claimed_total{treatment="lint"} 1`,
+		`next_completed_total{treatment="lint"} 2`,
+		`next_claim_to_done_seconds_bucket{treatment="lint",le="5"} 0`,
+		`next_claim_to_done_seconds_bucket{treatment="lint",le="15"} 1`,
+		`next_claim_to_done_seconds_count{treatment="lint"} 1`,
+		"# TYPE next_claim_to_done_seconds histogram",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("metrics missing %q\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// jsonPath accepts the subset of SQLite JSON paths filters may use: $,
+// then .key or [index] steps.
+var jsonPath = regexp.MustCompile(`^\$(\.[A-Za-z_][A-Za-z0-9_]*|\[[0-9]+\])*$`)
+
+// condition is one "path op value" term of a where filter. Value is a
+// float64, a string or nil for null.
+type condition struct {
+	path  string
+	op    string
+	value any
+}
+
+var conditionExpr = regexp.MustCompile(`^(\$[^\s=<>!]*)\s*(<=|>=|!=|==|<|>|=)\s*(.*)$`)
+
+// parseWhere parses a filter such as "$.score < 5 and $.lang = 'go'":
+// terms joined by "and", each a JSON path, a comparison operator and a
+// number, quoted string, true, false or null. Nothing is interpolated into
+// SQL, so a filter cannot do more than compare.
+func parseWhere(s string) ([]condition, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var out []condition
+	for _, term := range splitAnd(s) {
+		c, err := parseCondition(strings.TrimSpace(term))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidQuery, err)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// splitAnd splits on the word "and" outside quoted strings.
+func splitAnd(s string) []string {
+	var terms []string
+	var quote rune
+	start := 0
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case (i == 0 || s[i-1] == ' ') && len(s) > i+4 && strings.EqualFold(s[i:i+3], "and") && s[i+3] == ' ':
+			terms = append(terms, s[start:i])
+			start = i + 3
+		}
+	}
+	return append(terms, s[start:])
+}
+
+func parseCondition(term string) (condition, error) {
+	m := conditionExpr.FindStringSubmatch(term)
+	if m == nil {
+		return condition{}, fmt.Errorf("want $.path op value, got %q", term)
+	}
+	path, op := m[1], m[2]
+	if !jsonPath.MatchString(path) {
+		return condition{}, fmt.Errorf("bad path %q", path)
+	}
+	v, err := parseLiteral(strings.TrimSpace(m[3]))
+	if err != nil {
+		return condition{}, err
+	}
+	if op == "==" {
+		op = "="
+	}
+	if v == nil && op != "=" && op != "!=" {
+		return condition{}, errors.New("null only compares with = or !=")
+	}
+	return condition{path: path, op: op, value: v}, nil
+}
+
+// parseLiteral reads a value the way SQLite's json_extract reports it:
+// true and false as 1 and 0.
+func parseLiteral(s string) (any, error) {
+	switch {
+	case s == "null":
+		return nil, nil
+	case s == "true":
+		return 1.0, nil
+	case s == "false":
+		return 0.0, nil
+	case len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0]:
+		return s[1 : len(s)-1], nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad value %q", s)
+	}
+	return f, nil
+}
+
+// whereSQL renders conditions against the queue's result_json column.
+func whereSQL(conds []condition) (string, []any) {
+	var sb strings.Builder
+	var args []any
+	for _, c := range conds {
+		switch {
+		case c.value == nil:
+			// json_type tells JSON null from a missing value; json_extract
+			// reports both as NULL.
+			sb.WriteString(" AND json_type(result_json, ?) " + c.op + " 'null'")
+			args = append(args, c.path)
+		default:
+			sb.WriteString(" AND json_extract(result_json, ?) " + c.op + " ?")
+			args = append(args, c.path, c.value)
+		}
+	}
+	return sb.String(), args
+}
+
+// matchWhere evaluates conditions against a result JSON document with
+// SQLite's semantics: a missing value or document matches nothing, null
+// only compares with null, and numbers sort before strings.
+func matchWhere(conds []condition, doc string) bool {
+	for _, c := range conds {
+		v, ok := extractJSON(doc, c.path)
+		if !ok {
+			return false
+		}
+		if c.value == nil || v == nil {
+			if c.value != nil || (c.op == "=") != (v == nil) {
+				return false
+			}
+			continue
+		}
+		if !compareOp(c.op, compareValues(v, c.value)) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareOp(op string, cmp int) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+func compareValues(a, b any) int {
+	fa, aNum := a.(float64)
+	fb, bNum := b.(float64)
+	switch {
+	case aNum && bNum:
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case aNum:
+		return -1
+	case bNum:
+		return 1
+	}
+	return strings.Compare(a.(string), b.(string))
+}
+
+// extractJSON follows path into doc. ok is false when doc is empty or
+// invalid or the path is missing; objects and arrays yield their JSON text,
+// booleans 1 or 0, and JSON null nil.
+func extractJSON(doc, path string) (v any, ok bool) {
+	if doc == "" {
+		return nil, false
+	}
+	var cur any
+	if json.Unmarshal([]byte(doc), &cur) != nil {
+		return nil, false
+	}
+	for _, step := range jsonPathSteps(path) {
+		switch node := cur.(type) {
+		case map[string]any:
+			if cur, ok = node[step]; !ok {
+				return nil, false
+			}
+		case []any:
+			i, err := strconv.Atoi(strings.Trim(step, "[]"))
+			if err != nil || !strings.HasPrefix(step, "[") || i >= len(node) {
+				return nil, false
+			}
+			cur = node[i]
+		default:
+			return nil, false
+		}
+	}
+	switch x := cur.(type) {
+	case bool:
+		if x {
+			return 1.0, true
+		}
+		return 0.0, true
+	case map[string]any, []any:
+		b, _ := json.Marshal(x)
+		return string(b), true
+	}
+	return cur, true
+}
+
+// jsonPathSteps splits a validated path into keys and "[n]" indexes.
+func jsonPathSteps(path string) []string {
+	var steps []string
+	rest := strings.TrimPrefix(path, "$")
+	for rest != "" {
+		if rest[0] == '[' {
+			end := strings.IndexByte(rest, ']')
+			steps = append(steps, rest[:end+1])
+			rest = rest[end+1:]
+			continue
+		}
+		rest = rest[1:]
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		steps = append(steps, rest[:end])
+		rest = rest[end:]
+	}
+	return steps
+}
+
+// aggregate is a parsed status aggregate such as avg($.score).
+type aggregate struct {
+	fn   string
+	path string
+}
+
+var aggregateFuncs = map[string]string{"avg": "AVG", "sum": "SUM", "min": "MIN", "max": "MAX", "count": "COUNT"}
+
+var aggregateExpr = regexp.MustCompile(`^\s*([a-zA-Z]+)\(\s*(\S+?)\s*\)\s*$`)
+
+// parseAggregate parses fn(path) where fn is avg, sum, min, max or count.
+func parseAggregate(s string) (aggregate, error) {
+	m := aggregateExpr.FindStringSubmatch(s)
+	if m == nil {
+		return aggregate{}, fmt.Errorf("%w: want fn($.path), got %q", ErrInvalidQuery, s)
+	}
+	fn := strings.ToLower(m[1])
+	if _, ok := aggregateFuncs[fn]; !ok {
+		return aggregate{}, fmt.Errorf("%w: unknown aggregate %q (want avg, sum, min, max or count)", ErrInvalidQuery, m[1])
+	}
+	if !jsonPath.MatchString(m[2]) {
+		return aggregate{}, fmt.Errorf("%w: bad path %q", ErrInvalidQuery, m[2])
+	}
+	return aggregate{fn: fn, path: m[2]}, nil
+}
+
+// queueAggregate computes agg per treatment over the numeric values at its
+// path in done rows' result JSON.
+func queueAggregate(ctx context.Context, db *sql.DB, treatment, agg string) ([]AggregateRow, error) {
+	a, err := parseAggregate(agg)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT treatment, `+aggregateFuncs[a.fn]+`(json_extract(result_json, ?)), COUNT(*)
+		FROM queue
+		WHERE done_at IS NOT NULL AND result_json IS NOT NULL
+		  AND json_type(result_json, ?) IN ('integer', 'real')
+		  AND (? = '' OR treatment = ?)
+		GROUP BY treatment ORDER BY treatment
+	`, a.path, a.path, treatment, treatment)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var out []AggregateRow
+	for rows.Next() {
+		var r AggregateRow
+		if err := rows.Scan(&r.Treatment, &r.Value, &r.Count); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// aggregateValues applies a to a non-empty vs the way SQLite would.
+func aggregateValues(a aggregate, vs []float64) float64 {
+	v := vs[0]
+	switch a.fn {
+	case "count":
+		v = float64(len(vs))
+	case "sum", "avg":
+		v = 0
+		for _, x := range vs {
+			v += x
+		}
+		if a.fn == "avg" {
+			v /= float64(len(vs))
+		}
+	case "min":
+		for _, x := range vs[1:] {
+			v = math.Min(v, x)
+		}
+	case "max":
+		for _, x := range vs[1:] {
+			v = math.Max(v, x)
+		}
+	}
+	return v
+}
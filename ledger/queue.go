@@ -0,0 +1,634 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Queue operations behind SQLiteStore, one SQL statement or transaction
+// each. Treatment definitions are applied by the Ledger.
+
+// nowRFC3339 is the SQL expression used for event timestamps, matching the
+// RFC 3339 format done_at has always used.
+const nowRFC3339 = `strftime('%Y-%m-%dT%H:%M:%SZ', 'now')`
+
+// stateFilters maps list --state values to WHERE fragments.
+var stateFilters = map[string]string{
+	"pending": "done_at IS NULL",
+	"leased":  "done_at IS NULL AND leased_until > DATETIME('now')",
+	"failed":  "done_at IS NULL AND failed_at IS NOT NULL",
+	"done":    "done_at IS NOT NULL",
+	"due":     "done_at IS NOT NULL AND next_at <= DATETIME('now')",
+}
+
+// heldClaim selects rows claimed since they were last done, if ever. Done
+// and fail clear claimed_at, so a claim stamped in the same second as the
+// previous done still counts.
+const heldClaim = `claimed_at >= COALESCE(done_at, '')`
+
+// liveLease selects rows a worker currently holds a lease on, done or not;
+// the concurrency cap counts them.
+const liveLease = `leased_until > DATETIME('now')`
+
+// claimable selects rows a worker may take: pending and not failed (unless a
+// retry is scheduled and due), or done and either due for revisit or
+// recorded under a stale version; in every case not under a live lease. It
+// binds the current version twice.
+const claimable = `(leased_until IS NULL OR leased_until <= DATETIME('now'))
+	AND ((done_at IS NULL AND (failed_at IS NULL OR next_at <= DATETIME('now')))
+	  OR (done_at IS NOT NULL AND (next_at <= DATETIME('now')
+	      OR (? <> '' AND COALESCE(version, '') <> ?))))`
+
+// upstreamDone requires every treatment in the bound JSON array to be done
+// for the row's path at its current content hash.
+const upstreamDone = `NOT EXISTS (
+	SELECT 1 FROM json_each(?) dep WHERE NOT EXISTS (
+		SELECT 1 FROM queue up
+		WHERE up.path = queue.path AND up.treatment = dep.value
+		  AND up.content_hash = queue.content_hash AND up.done_at IS NOT NULL))`
+
+// leaseModifier renders a lease as a SQLite datetime modifier.
+func leaseModifier(d time.Duration) string {
+	return fmt.Sprintf("+%d seconds", int64(d.Seconds()))
+}
+
+func enqueue(ctx context.Context, db *sql.DB, treatment string, items []Item) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT OR IGNORE INTO queue
+		(path, path_hash, content_hash, treatment, done_at, result, next_at)
+		VALUES (?, ?, ?, ?, NULL, NULL, NULL)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = stmt.Close() }()
+
+	var inserted int64
+	for _, it := range items {
+		res, err := stmt.ExecContext(ctx, it.Path, PathHash(it.Path), it.ContentHash, treatment)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert %q: %w", it.Path, err)
+		}
+		n, _ := res.RowsAffected()
+		inserted += n
+	}
+	if err := bumpCounter(ctx, tx, treatment, counterEnqueued, float64(inserted)); err != nil {
+		return 0, err
+	}
+	if err := recordAudit(ctx, tx, "enqueue", treatment, inserted); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(items), nil
+}
+
+// reopenRow resets a row to pending under a new content hash, binding the
+// hash, path, treatment and the hash again; rows already at that hash are
+// left alone.
+const reopenRow = `
+	UPDATE queue SET content_hash=?, done_at=NULL, result=NULL, result_json=NULL, next_at=NULL,
+		claimed_at=NULL, leased_until=NULL, failed_at=NULL, error=NULL, attempts=0, version=NULL, worker=NULL
+	WHERE path=? AND treatment=? AND content_hash != ?`
+
+// reopen inserts new paths like enqueue and resets rows whose content hash
+// changed to pending under the new hash. It works through UPDATE and INSERT
+// OR IGNORE rather than an upsert so ledgers from older schema files, whose
+// key may be path alone, behave the same.
+func reopen(ctx context.Context, db *sql.DB, treatment string, items []Item) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	update, err := tx.PrepareContext(ctx, reopenRow)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = update.Close() }()
+	insert, err := tx.PrepareContext(ctx, `
+		INSERT OR IGNORE INTO queue (path, path_hash, content_hash, treatment)
+		VALUES (?, ?, ?, ?)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = insert.Close() }()
+
+	var changed int64
+	for _, it := range items {
+		res, err := update.ExecContext(ctx, it.ContentHash, it.Path, treatment, it.ContentHash)
+		if err != nil {
+			return 0, fmt.Errorf("failed to reopen %q: %w", it.Path, err)
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			if res, err = insert.ExecContext(ctx, it.Path, PathHash(it.Path), it.ContentHash, treatment); err != nil {
+				return 0, fmt.Errorf("failed to insert %q: %w", it.Path, err)
+			}
+			n, _ = res.RowsAffected()
+		}
+		changed += n
+	}
+	if err := bumpCounter(ctx, tx, treatment, counterEnqueued, float64(changed)); err != nil {
+		return 0, err
+	}
+	if changed > 0 {
+		if err := recordAudit(ctx, tx, "reopen", treatment, changed); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(changed), nil
+}
+
+// claim selects up to N claimable rows after the cursor and stamps them. The
+// transaction is IMMEDIATE so concurrent claimers cannot lease the same row
+// or spend the same rate-limit tokens.
+func claim(ctx context.Context, db *sql.DB, opts ClaimQuery) ([]Claimed, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := checkBudget(ctx, tx, opts); err != nil {
+		return nil, err
+	}
+	limit := opts.N
+	if opts.MaxLeases > 0 {
+		var live int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM queue WHERE treatment=? AND "+liveLease, opts.Treatment).Scan(&live); err != nil {
+			return nil, err
+		}
+		if limit = min(limit, opts.MaxLeases-live); limit <= 0 {
+			return nil, nil
+		}
+	}
+	var tokens bucket
+	if opts.Rate > 0 {
+		if tokens, err = loadBucket(ctx, tx, opts); err != nil {
+			return nil, err
+		}
+		if limit = tokens.allowance(limit); limit == 0 {
+			return nil, nil
+		}
+	}
+
+	after, err := json.Marshal(append([]string{}, opts.After...))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT path, path_hash, content_hash FROM queue
+		WHERE treatment=? AND path_hash > ? AND `+claimable+` AND `+upstreamDone+`
+		ORDER BY path_hash
+		LIMIT ?
+	`, opts.Treatment, opts.Cursor, opts.Version, opts.Version, string(after), limit)
+	if err != nil {
+		return nil, err
+	}
+	var items []Claimed
+	for rows.Next() {
+		var it Claimed
+		if err := rows.Scan(&it.Path, &it.PathHash, &it.ContentHash); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var lease *string
+	if opts.Lease > 0 {
+		m := leaseModifier(opts.Lease)
+		lease = &m
+	}
+	for _, it := range items {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE queue SET claimed_at=`+nowRFC3339+`, leased_until=DATETIME('now', ?), worker=NULLIF(?, '')
+			WHERE path=? AND treatment=?
+		`, lease, opts.Worker, it.Path, opts.Treatment); err != nil {
+			return nil, err
+		}
+	}
+	if len(items) > 0 {
+		if err := bumpCounter(ctx, tx, opts.Treatment, counterClaimed, float64(len(items))); err != nil {
+			return nil, err
+		}
+		if opts.Rate > 0 {
+			tokens.tokens -= float64(len(items))
+			if err := saveBucket(ctx, tx, opts.Treatment, tokens); err != nil {
+				return nil, err
+			}
+		}
+		if err := recordAudit(ctx, tx, "claim", opts.Treatment, int64(len(items))); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// nextClaimableAt reports when the earliest lease expires, revisit comes due
+// or rate-limit token is refilled for the treatment, so waiters can sleep
+// exactly that long.
+func nextClaimableAt(ctx context.Context, db *sql.DB, opts ClaimQuery) (time.Time, bool, error) {
+	t, ok, err := nextEventAt(ctx, db, opts)
+	if err != nil || opts.Rate <= 0 {
+		return t, ok, err
+	}
+	refill, waiting, err := peekBucket(ctx, db, opts)
+	if err != nil || !waiting {
+		return t, ok, err
+	}
+	if ok && t.Before(refill) {
+		return t, true, nil
+	}
+	return refill, true, nil
+}
+
+func nextEventAt(ctx context.Context, db *sql.DB, opts ClaimQuery) (time.Time, bool, error) {
+	var at sql.NullString
+	err := db.QueryRowContext(ctx, `
+		SELECT MIN(t) FROM (
+			SELECT leased_until AS t FROM queue
+			WHERE treatment=? AND path_hash > ? AND leased_until > DATETIME('now')
+			UNION ALL
+			SELECT next_at FROM queue
+			WHERE treatment=? AND path_hash > ? AND next_at > DATETIME('now')
+			  AND (done_at IS NOT NULL OR failed_at IS NOT NULL)
+		)
+	`, opts.Treatment, opts.Cursor, opts.Treatment, opts.Cursor).Scan(&at)
+	if err != nil || !at.Valid {
+		return time.Time{}, false, err
+	}
+	t, err := time.ParseInLocation(time.DateTime, at.String, time.UTC)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return t, true, nil
+}
+
+// markDone records a result and releases any lease. It returns the number of
+// rows updated, which is zero when the path was never enqueued. A claim made
+// since the previous completion feeds the claim-to-done histogram. The path
+// is enqueued for each treatment in opts.Then within the same transaction,
+// reusing its hashes.
+func markDone(ctx context.Context, db *sql.DB, opts CompleteOptions) (int64, error) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	var nextAt, version, resultJSON *string
+	if opts.Revisit != "" {
+		// SQLite datetime modifier
+		nextAt = &opts.Revisit
+	}
+	if opts.Version != "" {
+		version = &opts.Version
+	}
+	if opts.ResultJSON != "" {
+		resultJSON = &opts.ResultJSON
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var latency sql.NullFloat64
+	err = tx.QueryRowContext(ctx, `
+		SELECT (julianday(?) - julianday(claimed_at)) * 86400 FROM queue
+		WHERE path=? AND treatment=? AND `+heldClaim+`
+	`, now, opts.Path, opts.Treatment).Scan(&latency)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	worker, err := claimWorker(ctx, tx, opts.Path, opts.Treatment)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE queue
+		SET done_at=?, result=?, result_json=?, next_at=DATETIME('now', ?), version=?,
+		    claimed_at=NULL, leased_until=NULL, failed_at=NULL, error=NULL, worker=NULL
+		WHERE path=? AND treatment=?
+	`, now, opts.Result, resultJSON, nextAt, version, opts.Path, opts.Treatment)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		if err := spendWithoutRow(opts.Cost, opts.Tokens, opts.Path, opts.Treatment); err != nil {
+			return 0, err
+		}
+	}
+	if n > 0 {
+		if err := bumpCounter(ctx, tx, opts.Treatment, counterCompleted, float64(n)); err != nil {
+			return 0, err
+		}
+		if err := enqueueFollowUps(ctx, tx, opts); err != nil {
+			return 0, err
+		}
+		run, err := recordRun(ctx, tx, opts.Path, opts.Treatment, runExtras{opts.Artifact, worker, opts.Cost, opts.Tokens})
+		if err != nil {
+			return 0, err
+		}
+		if err := recordFindings(ctx, tx, run, opts); err != nil {
+			return 0, err
+		}
+	}
+	if latency.Valid {
+		if err := observeLatency(ctx, tx, opts.Treatment, math.Max(latency.Float64, 0)); err != nil {
+			return 0, err
+		}
+	}
+	if err := recordAudit(ctx, tx, "done", opts.Treatment, n); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// markFailed records an error and releases the lease. With a revisit the row
+// becomes claimable again once it is due; otherwise it stays failed until
+// reset. A done row claimed again, because it came due or its version went
+// stale, fails too: its done result is cleared, and stays in its runs.
+func markFailed(ctx context.Context, db *sql.DB, opts FailOptions) (int64, error) {
+	var nextAt, backoff *string
+	if opts.Revisit != "" {
+		nextAt = &opts.Revisit
+	}
+	var maxAttempts int
+	if r := opts.Retry; r != nil {
+		maxAttempts, backoff = r.MaxAttempts, &r.Backoff
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	worker, err := claimWorker(ctx, tx, opts.Path, opts.Treatment)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE queue
+		SET failed_at=`+nowRFC3339+`, error=?, attempts=attempts+1,
+		    next_at=CASE WHEN ? IS NOT NULL THEN DATETIME('now', ?)
+		                 WHEN attempts+1 < ? THEN DATETIME('now', ?) END,
+		    claimed_at=NULL, leased_until=NULL, worker=NULL,
+		    done_at=NULL, result=NULL, result_json=NULL, version=NULL
+		WHERE path=? AND treatment=? AND (done_at IS NULL OR `+heldClaim+`)
+	`, opts.Error, nextAt, nextAt, maxAttempts, backoff, opts.Path, opts.Treatment)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		if err := spendWithoutRow(opts.Cost, opts.Tokens, opts.Path, opts.Treatment); err != nil {
+			return 0, err
+		}
+	}
+	if n > 0 {
+		if _, err := recordRun(ctx, tx, opts.Path, opts.Treatment, runExtras{opts.Artifact, worker, opts.Cost, opts.Tokens}); err != nil {
+			return 0, err
+		}
+	}
+	if err := recordAudit(ctx, tx, "fail", opts.Treatment, n); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// claimWorker returns the worker holding the row's outstanding claim, or ""
+// when it is unclaimed or was claimed anonymously.
+func claimWorker(ctx context.Context, tx *sql.Tx, path, treatment string) (string, error) {
+	var w sql.NullString
+	err := tx.QueryRowContext(ctx, "SELECT worker FROM queue WHERE path=? AND treatment=?", path, treatment).Scan(&w)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	return w.String, err
+}
+
+func queueStatus(ctx context.Context, db *sql.DB, treatment string) ([]Stats, error) {
+	query := `
+		SELECT treatment,
+		       COUNT(*) FILTER (WHERE ` + stateFilters["pending"] + `) as pending,
+		       COUNT(*) FILTER (WHERE ` + stateFilters["leased"] + `) as leased,
+		       COUNT(*) FILTER (WHERE ` + stateFilters["failed"] + `) as failed,
+		       COUNT(*) FILTER (WHERE ` + stateFilters["done"] + `) as done,
+		       COUNT(*) FILTER (WHERE ` + stateFilters["due"] + `) as due,
+		       COUNT(*) FILTER (WHERE ` + liveLease + `) as running
+		FROM queue
+	`
+	args := []interface{}{}
+	if treatment != "" {
+		query += " WHERE treatment=?"
+		args = append(args, treatment)
+	}
+	query += " GROUP BY treatment ORDER BY treatment"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []Stats
+	for rows.Next() {
+		var r Stats
+		if err := rows.Scan(&r.Treatment, &r.Pending, &r.Leased, &r.Failed, &r.Done, &r.Due, &r.Running); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// entryColumns selects an Entry, deriving its state.
+const entryColumns = `path, path_hash, content_hash, treatment,
+	CASE
+	  WHEN done_at IS NOT NULL THEN 'done'
+	  WHEN leased_until > DATETIME('now') THEN 'leased'
+	  WHEN failed_at IS NOT NULL THEN 'failed'
+	  ELSE 'pending'
+	END,
+	COALESCE(done_at, ''), COALESCE(result, ''), COALESCE(next_at, ''),
+	COALESCE(leased_until, ''), COALESCE(failed_at, ''), COALESCE(error, ''),
+	attempts, COALESCE(version, ''), COALESCE(result_json, '')`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEntry(row scanner) (Entry, error) {
+	var e Entry
+	err := row.Scan(&e.Path, &e.PathHash, &e.ContentHash, &e.Treatment, &e.State,
+		&e.DoneAt, &e.Result, &e.NextAt, &e.LeasedUntil, &e.FailedAt, &e.Error, &e.Attempts, &e.Version, &e.ResultJSON)
+	return e, err
+}
+
+func getEntry(ctx context.Context, db *sql.DB, treatment, path string) (Entry, bool, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+entryColumns+" FROM queue WHERE path=? AND treatment=?", path, treatment)
+	e, err := scanEntry(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entry{}, false, nil
+	}
+	return e, err == nil, err
+}
+
+func listEntries(ctx context.Context, db *sql.DB, opts ListOptions) ([]Entry, error) {
+	query := "SELECT " + entryColumns + " FROM queue WHERE 1=1"
+	var args []interface{}
+	if opts.Treatment != "" {
+		query += " AND treatment=?"
+		args = append(args, opts.Treatment)
+	}
+	if opts.State != "" {
+		filter, ok := stateFilters[opts.State]
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownState, opts.State)
+		}
+		query += " AND " + filter
+	}
+	conds, err := parseWhere(opts.Where)
+	if err != nil {
+		return nil, err
+	}
+	where, whereArgs := whereSQL(conds)
+	query += where
+	args = append(args, whereArgs...)
+	if opts.Newest {
+		// failed_at is cleared on done, so this is the latest event.
+		query += " ORDER BY COALESCE(failed_at, done_at, '') DESC, path_hash"
+	} else {
+		query += " ORDER BY treatment, path_hash"
+	}
+	if opts.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, opts.Limit)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []Entry
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// queueVersions counts done rows per treatment and recorded version. Rows
+// done before versioning report an empty version.
+func queueVersions(ctx context.Context, db *sql.DB, treatment string) ([]VersionCount, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT treatment, COALESCE(version, ''), COUNT(*) FROM queue
+		WHERE done_at IS NOT NULL AND (? = '' OR treatment = ?)
+		GROUP BY 1, 2
+		ORDER BY 1, 2
+	`, treatment, treatment)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var out []VersionCount
+	for rows.Next() {
+		var r VersionCount
+		if err := rows.Scan(&r.Treatment, &r.Version, &r.Done); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// enqueueFollowUps queues the completed path for each of opts.Then at its
+// content hash, reopening follow-up rows left from older content like
+// reopen does.
+func enqueueFollowUps(ctx context.Context, tx *sql.Tx, opts CompleteOptions) error {
+	if len(opts.Then) == 0 {
+		return nil
+	}
+	var pathHash, contentHash string
+	err := tx.QueryRowContext(ctx, "SELECT path_hash, content_hash FROM queue WHERE path=? AND treatment=?", opts.Path, opts.Treatment).Scan(&pathHash, &contentHash)
+	if err != nil {
+		return err
+	}
+	for _, next := range opts.Then {
+		res, err := tx.ExecContext(ctx, reopenRow, contentHash, opts.Path, next, contentHash)
+		if err != nil {
+			return err
+		}
+		added, _ := res.RowsAffected()
+		if added == 0 {
+			if res, err = tx.ExecContext(ctx, `
+				INSERT OR IGNORE INTO queue (path, path_hash, content_hash, treatment)
+				VALUES (?, ?, ?, ?)
+			`, opts.Path, pathHash, contentHash, next); err != nil {
+				return err
+			}
+			added, _ = res.RowsAffected()
+		}
+		if err := bumpCounter(ctx, tx, next, counterEnqueued, float64(added)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resetTreatment(ctx context.Context, db *sql.DB, treatment string) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.ExecContext(ctx, "DELETE FROM queue WHERE treatment=?", treatment)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := recordAudit(ctx, tx, "reset", treatment, n); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
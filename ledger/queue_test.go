@@ -0,0 +1,172 @@
+package ledger
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClaim_SkipsLeasedRows_When_LeaseLive(t *testing.T) {
+	s := newTestStore(t)
+	db := s.db
+	ctx := context.Background()
+	mustEnqueue(t, s, "lint", "/src/a.go", "/src/b.go")
+
+	first, err := claim(ctx, db, ClaimQuery{Treatment: "lint", N: 1, Lease: time.Minute})
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	second, err := claim(ctx, db, ClaimQuery{Treatment: "lint", N: 2, Lease: time.Minute})
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if len(first) != 1 || len(second) != 1 {
+		t.Fatalf("claimed %d then %d, want 1 then 1", len(first), len(second))
+	}
+	if first[0].Path == second[0].Path {
+		t.Fatalf("both claims returned %s", first[0].Path)
+	}
+
+	third, err := claim(ctx, db, ClaimQuery{Treatment: "lint", N: 1})
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if len(third) != 0 {
+		t.Fatalf("claimed %v while every row is leased", third)
+	}
+}
+
+func TestClaim_ReturnsRow_When_LeaseExpired(t *testing.T) {
+	s := newTestStore(t)
+	db := s.db
+	ctx := context.Background()
+	mustEnqueue(t, s, "lint", "/src/a.go")
+
+	if _, err := db.Exec(`UPDATE queue SET leased_until=DATETIME('now', '-1 minute')`); err != nil {
+		t.Fatalf("expire lease: %v", err)
+	}
+	items, err := claim(ctx, db, ClaimQuery{Treatment: "lint", N: 1})
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("claimed %d, want 1", len(items))
+	}
+}
+
+func TestMarkFailed_HidesRowUntilRetryDue(t *testing.T) {
+	s := newTestStore(t)
+	db := s.db
+	ctx := context.Background()
+	mustEnqueue(t, s, "lint", "/src/a.go", "/src/b.go")
+
+	if _, err := markFailed(ctx, db, FailOptions{Path: "/src/a.go", Treatment: "lint", Error: "boom"}); err != nil {
+		t.Fatalf("markFailed: %v", err)
+	}
+	if _, err := markFailed(ctx, db, FailOptions{Path: "/src/b.go", Treatment: "lint", Error: "flaky", Revisit: "-1 second"}); err != nil {
+		t.Fatalf("markFailed: %v", err)
+	}
+
+	items, err := claim(ctx, db, ClaimQuery{Treatment: "lint", N: 5})
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if len(items) != 1 || items[0].Path != "/src/b.go" {
+		t.Fatalf("claimed %v, want only the due retry /src/b.go", items)
+	}
+
+	failed, err := listEntries(ctx, db, ListOptions{Treatment: "lint", State: "failed"})
+	if err != nil {
+		t.Fatalf("listEntries: %v", err)
+	}
+	if len(failed) != 2 || failed[0].Attempts != 1 {
+		t.Fatalf("failed entries = %+v", failed)
+	}
+}
+
+func TestQueueStatus_CountsStates(t *testing.T) {
+	s := newTestStore(t)
+	db := s.db
+	ctx := context.Background()
+	mustEnqueue(t, s, "lint", "/src/a.go", "/src/b.go", "/src/c.go")
+
+	if _, err := db.Exec(`UPDATE queue SET leased_until=DATETIME('now', '+1 hour') WHERE path='/src/a.go'`); err != nil {
+		t.Fatalf("lease: %v", err)
+	}
+	if _, err := markDone(ctx, db, CompleteOptions{Path: "/src/b.go", Treatment: "lint", Revisit: "-1 second"}); err != nil {
+		t.Fatalf("markDone: %v", err)
+	}
+	if _, err := markFailed(ctx, db, FailOptions{Path: "/src/c.go", Treatment: "lint"}); err != nil {
+		t.Fatalf("markFailed: %v", err)
+	}
+
+	rows, err := queueStatus(ctx, db, "lint")
+	if err != nil {
+		t.Fatalf("queueStatus: %v", err)
+	}
+	want := Stats{Treatment: "lint", Pending: 2, Leased: 1, Failed: 1, Done: 1, Due: 1, Running: 1}
+	if len(rows) != 1 || rows[0] != want {
+		t.Fatalf("status = %+v, want %+v", rows, want)
+	}
+}
+
+func TestClaim_ReturnsDoneRows_When_VersionStale(t *testing.T) {
+	s := newTestStore(t)
+	db := s.db
+	ctx := context.Background()
+	mustEnqueue(t, s, "review", "/src/a.go", "/src/b.go")
+	for _, p := range []string{"/src/a.go", "/src/b.go"} {
+		if _, err := markDone(ctx, db, CompleteOptions{Path: p, Treatment: "review", Result: "ok", Version: "v1"}); err != nil {
+			t.Fatalf("markDone: %v", err)
+		}
+	}
+
+	if items, err := claim(ctx, db, ClaimQuery{Treatment: "review", N: 5, Version: "v1"}); err != nil || len(items) != 0 {
+		t.Fatalf("claim at v1 = %v, %v; want nothing", items, err)
+	}
+	items, err := claim(ctx, db, ClaimQuery{Treatment: "review", N: 5, Version: "v2"})
+	if err != nil || len(items) != 2 {
+		t.Fatalf("claim at v2 = %v, %v; want both stale rows", items, err)
+	}
+
+	// Stale results stay visible until redone.
+	done, err := listEntries(ctx, db, ListOptions{Treatment: "review", State: "done"})
+	if err != nil || len(done) != 2 || done[0].Version != "v1" || done[0].Result != "ok" {
+		t.Fatalf("done entries = %+v, %v", done, err)
+	}
+	if _, err := markDone(ctx, db, CompleteOptions{Path: "/src/a.go", Treatment: "review", Version: "v2"}); err != nil {
+		t.Fatalf("markDone: %v", err)
+	}
+	versions, err := queueVersions(ctx, db, "review")
+	if err != nil {
+		t.Fatalf("queueVersions: %v", err)
+	}
+	want := []VersionCount{{Treatment: "review", Version: "v1", Done: 1}, {Treatment: "review", Version: "v2", Done: 1}}
+	if len(versions) != 2 || versions[0] != want[0] || versions[1] != want[1] {
+		t.Fatalf("versions = %+v, want %+v", versions, want)
+	}
+}
+
+func TestMarkDone_EnqueuesFollowUps_When_ThenGiven(t *testing.T) {
+	s := newTestStore(t)
+	db := s.db
+	ctx := context.Background()
+	mustEnqueue(t, s, "extract", "/src/a.go")
+
+	if _, err := markDone(ctx, db, CompleteOptions{Path: "/src/a.go", Treatment: "extract", Then: []string{"summarize", "index"}}); err != nil {
+		t.Fatalf("markDone: %v", err)
+	}
+	for _, treatment := range []string{"summarize", "index"} {
+		items, err := claim(ctx, db, ClaimQuery{Treatment: treatment, N: 5})
+		if err != nil || len(items) != 1 || items[0].ContentHash != "hash-a.go" {
+			t.Fatalf("claim %s = %+v, %v; want a.go with the extract content hash", treatment, items, err)
+		}
+	}
+
+	if _, err := markDone(ctx, db, CompleteOptions{Path: "/src/missing.go", Treatment: "extract", Then: []string{"summarize"}}); err != nil {
+		t.Fatalf("markDone: %v", err)
+	}
+	if n := countRows(t, s, "summarize"); n != 1 {
+		t.Fatalf("summarize has %d rows, want 1 (nothing for a path never enqueued)", n)
+	}
+}
@@ -0,0 +1,84 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+)
+
+// bucketsTable holds one token bucket per rate-limited treatment. Claims
+// refill and spend it in their own transaction, so every process sharing
+// the ledger draws from the same bucket. updated_at is Unix seconds.
+const bucketsTable = `CREATE TABLE IF NOT EXISTS buckets (
+  treatment TEXT PRIMARY KEY,
+  tokens REAL NOT NULL,
+  updated_at REAL NOT NULL
+)`
+
+// bucket is a token bucket's state at a point in time.
+type bucket struct {
+	tokens float64
+	at     float64 // Unix seconds
+}
+
+// refill returns b topped up at rate tokens per second to now, capped at
+// burst. A bucket never used starts full.
+func (b bucket) refill(q ClaimQuery, now float64, used bool) bucket {
+	if !used {
+		return bucket{tokens: float64(q.Burst), at: now}
+	}
+	return bucket{tokens: math.Min(float64(q.Burst), b.tokens+math.Max(now-b.at, 0)*q.Rate), at: now}
+}
+
+// allowance is how many rows a claim may take from the bucket.
+func (b bucket) allowance(n int) int {
+	return min(n, int(math.Floor(b.tokens)))
+}
+
+// nextToken is when the bucket holds a whole token again, and false when it
+// already does.
+func (b bucket) nextToken(rate float64) (time.Time, bool) {
+	if b.tokens >= 1 {
+		return time.Time{}, false
+	}
+	secs := b.at + (1-b.tokens)/rate
+	return time.Unix(0, int64(secs*float64(time.Second))).UTC(), true
+}
+
+func unixNow() float64 {
+	return float64(time.Now().UnixNano()) / float64(time.Second)
+}
+
+// loadBucket reads and refills q's bucket.
+func loadBucket(ctx context.Context, tx *sql.Tx, q ClaimQuery) (bucket, error) {
+	var b bucket
+	err := tx.QueryRowContext(ctx, "SELECT tokens, updated_at FROM buckets WHERE treatment=?", q.Treatment).Scan(&b.tokens, &b.at)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return b, err
+	}
+	return b.refill(q, unixNow(), err == nil), nil
+}
+
+func saveBucket(ctx context.Context, tx *sql.Tx, treatment string, b bucket) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO buckets (treatment, tokens, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (treatment) DO UPDATE SET tokens = excluded.tokens, updated_at = excluded.updated_at
+	`, treatment, b.tokens, b.at)
+	return err
+}
+
+// peekBucket reports when q's bucket next holds a token, for waiting claims.
+func peekBucket(ctx context.Context, db *sql.DB, q ClaimQuery) (time.Time, bool, error) {
+	var b bucket
+	err := db.QueryRowContext(ctx, "SELECT tokens, updated_at FROM buckets WHERE treatment=?", q.Treatment).Scan(&b.tokens, &b.at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	at, ok := b.refill(q, unixNow(), true).nextToken(q.Rate)
+	return at, ok, nil
+}
@@ -0,0 +1,135 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// queueTable is the current queue schema, kept in step with the project's
+// .quality/schema.sql. Open runs it so an embedded ledger needs no schema
+// file.
+const queueTable = `CREATE TABLE IF NOT EXISTS queue (
+  path TEXT NOT NULL,
+  path_hash TEXT NOT NULL,
+  content_hash TEXT NOT NULL,
+  treatment TEXT NOT NULL,
+  done_at TEXT,
+  result TEXT,
+  next_at TEXT,
+  claimed_at TEXT,
+  leased_until TEXT,
+  failed_at TEXT,
+  error TEXT,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  version TEXT,
+  result_json TEXT,
+  worker TEXT,
+  PRIMARY KEY (path, treatment)
+);
+CREATE INDEX IF NOT EXISTS idx_pending ON queue(treatment, path_hash)
+  WHERE done_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_revisit ON queue(treatment, next_at)
+  WHERE next_at IS NOT NULL;`
+
+// queueColumns lists columns added to queue after the original schema, so
+// ledgers created by older builds are upgraded in place by migrate.
+var queueColumns = []struct{ name, decl string }{
+	{"claimed_at", "TEXT"},
+	{"leased_until", "TEXT"},
+	{"failed_at", "TEXT"},
+	{"error", "TEXT"},
+	{"attempts", "INTEGER NOT NULL DEFAULT 0"},
+	{"version", "TEXT"},
+	{"result_json", "TEXT"},
+	{"worker", "TEXT"},
+}
+
+// runsColumns lists columns added to runs after it was introduced.
+var runsColumns = []struct{ name, decl string }{
+	{"worker", "TEXT"},
+	{"cost", "REAL"},
+	{"tokens", "INTEGER"},
+}
+
+// findingsColumns lists columns added to findings after it was introduced.
+var findingsColumns = []struct{ name, decl string }{
+	{"fingerprint", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// auxTables are the ledger's bookkeeping tables besides queue. Each
+// statement is idempotent.
+var auxTables = []string{
+	countersTable,
+	runsTable,
+	findingsTable,
+	auditTable,
+	bucketsTable,
+}
+
+// migrate brings an existing queue table up to date and creates the
+// auxiliary tables. Ledgers without a queue table are left alone; Open or
+// the CLI's .quality/schema.sql is what creates it. It runs in an IMMEDIATE
+// transaction and reads the columns inside it, so processes opening an old
+// ledger at once take turns and only the first alters it.
+func migrate(db *sql.DB) error {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	have, err := tableColumns(tx, "queue")
+	if err != nil || len(have) == 0 {
+		return err
+	}
+	for _, stmt := range auxTables {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if err := addColumns(tx, "queue", have, queueColumns); err != nil {
+		return err
+	}
+	if have, err = tableColumns(tx, "runs"); err != nil {
+		return err
+	}
+	if err := addColumns(tx, "runs", have, runsColumns); err != nil {
+		return err
+	}
+	if have, err = tableColumns(tx, "findings"); err != nil {
+		return err
+	}
+	if err := addColumns(tx, "findings", have, findingsColumns); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func addColumns(tx *sql.Tx, table string, have map[string]bool, cols []struct{ name, decl string }) error {
+	for _, c := range cols {
+		if have[c.name] {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, c.name, c.decl)); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", table, c.name, err)
+		}
+	}
+	return nil
+}
+
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	cols := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
+}
@@ -0,0 +1,202 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ncruces/go-sqlite3/driver"
+)
+
+const (
+	snapshotDirName    = "snapshots"
+	snapshotExt        = ".db"
+	snapshotTimeLayout = "20060102-150405.000000"
+)
+
+// snapshotInfo describes one pre-operation copy of the ledger.
+type snapshotInfo struct {
+	Name    string
+	Path    string
+	Reason  string
+	Created time.Time
+	Size    int64
+}
+
+func snapshotDir(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), snapshotDirName)
+}
+
+// snapshotBefore copies the ledger aside before a destructive operation and
+// prunes old snapshots down to the retention count. It returns the snapshot
+// path, or "" when snapshots are disabled.
+func snapshotBefore(ctx context.Context, db *sql.DB, dbPath string, keep int, reason string) (string, error) {
+	if keep < 0 {
+		return "", nil
+	}
+	dst, err := copySnapshot(ctx, db, dbPath, reason)
+	if err != nil {
+		return "", err
+	}
+	if err := pruneSnapshots(snapshotDir(dbPath), keep, ""); err != nil {
+		return dst, err
+	}
+	return dst, nil
+}
+
+// copySnapshot copies the ledger into the snapshot directory without
+// pruning and returns the copy's path.
+func copySnapshot(ctx context.Context, db *sql.DB, dbPath, reason string) (string, error) {
+	dir := snapshotDir(dbPath)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+	name := time.Now().UTC().Format(snapshotTimeLayout) + "-" + reason + snapshotExt
+	dst := filepath.Join(dir, name)
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?", dst); err != nil {
+		return "", fmt.Errorf("snapshot failed: %w", err)
+	}
+	return dst, nil
+}
+
+// listSnapshots returns snapshots oldest first.
+func listSnapshots(dir string) ([]snapshotInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snaps []snapshotInfo
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, snapshotExt) {
+			continue
+		}
+		base := strings.TrimSuffix(name, snapshotExt)
+		if len(base) < len(snapshotTimeLayout)+2 || base[len(snapshotTimeLayout)] != '-' {
+			continue
+		}
+		created, err := time.Parse(snapshotTimeLayout, base[:len(snapshotTimeLayout)])
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snapshotInfo{
+			Name:    name,
+			Path:    filepath.Join(dir, name),
+			Reason:  base[len(snapshotTimeLayout)+1:],
+			Created: created,
+			Size:    info.Size(),
+		})
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Name < snaps[j].Name })
+	return snaps, nil
+}
+
+// pruneSnapshots removes the oldest snapshots beyond keep, leaving alone
+// the one named except, if any.
+func pruneSnapshots(dir string, keep int, except string) error {
+	snaps, err := listSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	snaps = slices.DeleteFunc(snaps, func(sn snapshotInfo) bool { return sn.Name == except })
+	for len(snaps) > keep {
+		if err := os.Remove(snaps[0].Path); err != nil {
+			return err
+		}
+		snaps = snaps[1:]
+	}
+	return nil
+}
+
+// restoreSnapshot overwrites the live ledger with src using the online backup
+// API, so WAL readers and other connections see a consistent database. src
+// is only read. The live audit log is saved in a temporary table on the
+// same connection and put back after the restore, followed by e, the entry
+// for the restore itself.
+func restoreSnapshot(ctx context.Context, db *sql.DB, src string, e AuditEntry) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.ExecContext(ctx, "CREATE TEMP TABLE saved_audit AS SELECT * FROM main.audit"); err != nil {
+		return fmt.Errorf("restore: save audit log: %w", err)
+	}
+	defer func() { _, _ = conn.ExecContext(context.WithoutCancel(ctx), "DROP TABLE IF EXISTS temp.saved_audit") }()
+	err = conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(driver.Conn)
+		if !ok {
+			return fmt.Errorf("restore: unexpected driver connection %T", driverConn)
+		}
+		return c.Raw().Restore("main", src)
+	})
+	if err != nil {
+		return err
+	}
+	if err := restoreAudit(ctx, conn, e); err != nil {
+		return fmt.Errorf("restore: keep audit log: %w", err)
+	}
+	return nil
+}
+
+// restoreAudit replaces the restored audit log with the saved live one,
+// which snapshots only ever hold a prefix of, and appends e.
+func restoreAudit(ctx context.Context, conn *sql.Conn, e AuditEntry) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, stmt := range []string{
+		auditTable,
+		"DELETE FROM main.audit",
+		"INSERT INTO main.audit SELECT * FROM temp.saved_audit",
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if err := insertAudit(ctx, tx, "main.audit", e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// undoSnapshot restores the named snapshot, first copying the live ledger
+// aside as an "undo" snapshot so the restore can itself be undone. Pruning
+// runs after the restore and skips the restored snapshot, so it is kept
+// even when that leaves one more snapshot than the retention count.
+func undoSnapshot(ctx context.Context, db *sql.DB, dbPath string, keep int, name string) error {
+	if name == "" || filepath.Base(name) != name {
+		return fmt.Errorf("%w: invalid name %q", ErrSnapshotNotFound, name)
+	}
+	src := filepath.Join(snapshotDir(dbPath), name)
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("%w: %q", ErrSnapshotNotFound, name)
+	}
+	if keep >= 0 {
+		if _, err := copySnapshot(ctx, db, dbPath, "undo"); err != nil {
+			return err
+		}
+	}
+	if err := restoreSnapshot(ctx, db, src, auditEntry(ctx, "undo", "", 0)); err != nil {
+		return err
+	}
+	if keep < 0 {
+		return nil
+	}
+	return pruneSnapshots(snapshotDir(dbPath), keep, name)
+}
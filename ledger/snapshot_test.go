@@ -0,0 +1,176 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotBefore_PrunesOldest_When_OverRetention(t *testing.T) {
+	s, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "ledger.db"), Options{SnapshotKeep: 2})
+	if err != nil {
+		t.Fatalf("OpenSQLiteStore: %v", err)
+	}
+	defer func() { _ = s.Close() }()
+
+	var taken []string
+	for i := 0; i < 3; i++ {
+		p, err := s.Snapshot(context.Background(), "reset")
+		if err != nil {
+			t.Fatalf("Snapshot: %v", err)
+		}
+		taken = append(taken, filepath.Base(p))
+	}
+
+	snaps, err := listSnapshots(snapshotDir(s.path))
+	if err != nil {
+		t.Fatalf("listSnapshots: %v", err)
+	}
+	if len(snaps) != 2 {
+		t.Fatalf("kept %d snapshots, want 2", len(snaps))
+	}
+	if snaps[0].Name != taken[1] || snaps[1].Name != taken[2] {
+		t.Fatalf("kept %s, %s; want %s, %s", snaps[0].Name, snaps[1].Name, taken[1], taken[2])
+	}
+}
+
+func TestSnapshotBefore_Skips_When_Disabled(t *testing.T) {
+	s, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "ledger.db"), Options{SnapshotKeep: -1})
+	if err != nil {
+		t.Fatalf("OpenSQLiteStore: %v", err)
+	}
+	defer func() { _ = s.Close() }()
+
+	p, err := s.Snapshot(context.Background(), "reset")
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if p != "" {
+		t.Fatalf("snapshot path = %q, want none", p)
+	}
+	if _, err := os.Stat(snapshotDir(s.path)); !os.IsNotExist(err) {
+		t.Fatalf("snapshot dir should not exist, stat err = %v", err)
+	}
+}
+
+func TestLedger_UndoesReset(t *testing.T) {
+	l, _ := newFileLedger(t)
+	ctx := context.Background()
+	mustEnqueue(t, l.store, "review", "/src/a.go", "/src/b.go")
+
+	if n, err := l.Reset(ctx, "review"); err != nil || n != 2 {
+		t.Fatalf("Reset = %d, %v", n, err)
+	}
+	snaps, err := l.Snapshots(ctx)
+	if err != nil || len(snaps) != 1 || snaps[0].Reason != "reset" {
+		t.Fatalf("Snapshots = %+v, %v; want one reset snapshot", snaps, err)
+	}
+	if err := l.Undo(ctx, snaps[0].Name); err != nil {
+		t.Fatalf("Undo: %v", err)
+	}
+	if n := countRows(t, l.store, "review"); n != 2 {
+		t.Fatalf("after undo %d rows, want 2", n)
+	}
+	after, err := l.Snapshots(ctx)
+	if err != nil || len(after) != 2 || after[0] != snaps[0] || after[1].Reason != "undo" {
+		t.Fatalf("Snapshots after undo = %+v, %v; want the reset snapshot kept and an undo snapshot", after, err)
+	}
+	if err := l.Undo(ctx, after[1].Name); err != nil {
+		t.Fatalf("Undo of the undo: %v", err)
+	}
+	if n := countRows(t, l.store, "review"); n != 0 {
+		t.Fatalf("after undoing the undo %d rows, want 0", n)
+	}
+	if err := l.Undo(ctx, "../ledger.db"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Fatalf("Undo outside the snapshot dir err = %v, want ErrSnapshotNotFound", err)
+	}
+}
+
+func TestLedger_KeepsAuditLog_When_Undoing(t *testing.T) {
+	l, _ := newFileLedger(t)
+	ctx := WithActor(context.Background(), Actor{User: "alice", Host: "laptop", PID: 7, Cmdline: "next reset"})
+	if _, err := l.store.Enqueue(ctx, "review", []Item{{Path: "/src/a.go", ContentHash: "h1"}}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	if _, err := l.Reset(ctx, "review"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	snaps, err := l.Snapshots(ctx)
+	if err != nil || len(snaps) != 1 {
+		t.Fatalf("Snapshots = %+v, %v", snaps, err)
+	}
+	if err := l.Undo(ctx, snaps[0].Name); err != nil {
+		t.Fatalf("Undo: %v", err)
+	}
+	entries, err := l.AuditLog(ctx, AuditQuery{})
+	if err != nil {
+		t.Fatalf("AuditLog: %v", err)
+	}
+	var commands []string
+	for _, e := range entries {
+		if e.User != "alice" || e.Host != "laptop" || e.PID != 7 {
+			t.Errorf("entry %+v not attributed to the context's actor", e)
+		}
+		commands = append(commands, e.Command)
+	}
+	if strings.Join(commands, ",") != "enqueue,reset,undo" {
+		t.Fatalf("audit commands = %v, want [enqueue reset undo]", commands)
+	}
+}
+
+func TestLedger_KeepsRestoredSnapshot_When_RetentionIsOne(t *testing.T) {
+	l, err := Open(filepath.Join(t.TempDir(), "ledger.db"), Options{SnapshotKeep: 1})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+	ctx := context.Background()
+	mustEnqueue(t, l.store, "review", "/src/a.go")
+
+	if _, err := l.Reset(ctx, "review"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	snaps, err := l.Snapshots(ctx)
+	if err != nil || len(snaps) != 1 {
+		t.Fatalf("Snapshots = %+v, %v", snaps, err)
+	}
+	src := filepath.Join(snapshotDir(l.Path()), snaps[0].Name)
+	before, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Undo(ctx, snaps[0].Name); err != nil {
+		t.Fatalf("Undo: %v", err)
+	}
+	after, err := l.Snapshots(ctx)
+	if err != nil || len(after) != 2 || after[0].Name != snaps[0].Name || after[1].Reason != "undo" {
+		t.Fatalf("Snapshots after undo = %+v, %v; want the restored snapshot and the undo one", after, err)
+	}
+	if got, err := os.ReadFile(src); err != nil || !bytes.Equal(got, before) {
+		t.Fatalf("undo modified the restored snapshot (err %v)", err)
+	}
+
+	// Restoring it again brings back the same rows and keeps the live log.
+	if err := l.Undo(ctx, snaps[0].Name); err != nil {
+		t.Fatalf("second Undo: %v", err)
+	}
+	if n := countRows(t, l.store, "review"); n != 1 {
+		t.Fatalf("after second undo %d rows, want 1", n)
+	}
+	entries, err := l.AuditLog(ctx, AuditQuery{})
+	if err != nil {
+		t.Fatalf("AuditLog: %v", err)
+	}
+	var commands []string
+	for _, e := range entries {
+		commands = append(commands, e.Command)
+	}
+	if strings.Join(commands, ",") != "enqueue,reset,undo,undo" {
+		t.Fatalf("audit commands = %v, want [enqueue reset undo undo]", commands)
+	}
+}
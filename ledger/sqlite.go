@@ -0,0 +1,173 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	_ "github.com/ncruces/go-sqlite3/driver" // registers "sqlite3"
+	_ "github.com/ncruces/go-sqlite3/embed"
+)
+
+// SQLiteStore keeps the queue in a SQLite file. Besides Store it implements
+// Snapshotter, with copies in a snapshots directory next to the file,
+// CounterStore, and ArtifactStore, with blobs in an artifacts directory next
+// to the file.
+type SQLiteStore struct {
+	db   *sql.DB
+	path string
+	keep int
+}
+
+var (
+	_ Store         = (*SQLiteStore)(nil)
+	_ Snapshotter   = (*SQLiteStore)(nil)
+	_ CounterStore  = (*SQLiteStore)(nil)
+	_ ArtifactStore = (*SQLiteStore)(nil)
+)
+
+// OpenSQLiteStore opens or creates the SQLite file at path, creating the
+// queue table if it does not exist yet.
+func OpenSQLiteStore(path string, opts Options) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if _, err := db.Exec(queueTable); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("create queue: %w", err)
+	}
+	s, err := NewSQLiteStore(db, path, opts)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSQLiteStore wraps an already open database whose file is at path,
+// upgrading its queue table if one exists. Close closes db.
+func NewSQLiteStore(db *sql.DB, path string, opts Options) (*SQLiteStore, error) {
+	if err := migrate(db); err != nil {
+		return nil, fmt.Errorf("schema migration failed: %w", err)
+	}
+	keep := opts.SnapshotKeep
+	if keep == 0 {
+		keep = DefaultSnapshotKeep
+	}
+	return &SQLiteStore{db: db, path: path, keep: keep}, nil
+}
+
+func (s *SQLiteStore) Enqueue(ctx context.Context, treatment string, items []Item) (int, error) {
+	return enqueue(ctx, s.db, treatment, items)
+}
+
+func (s *SQLiteStore) Reopen(ctx context.Context, treatment string, items []Item) (int, error) {
+	return reopen(ctx, s.db, treatment, items)
+}
+
+func (s *SQLiteStore) Claim(ctx context.Context, q ClaimQuery) ([]Claimed, error) {
+	return claim(ctx, s.db, q)
+}
+
+func (s *SQLiteStore) NextClaimable(ctx context.Context, q ClaimQuery) (time.Time, bool, error) {
+	return nextClaimableAt(ctx, s.db, q)
+}
+
+func (s *SQLiteStore) Complete(ctx context.Context, opts CompleteOptions) (int64, error) {
+	return markDone(ctx, s.db, opts)
+}
+
+func (s *SQLiteStore) Fail(ctx context.Context, opts FailOptions) (int64, error) {
+	return markFailed(ctx, s.db, opts)
+}
+
+func (s *SQLiteStore) History(ctx context.Context, treatment, path string) ([]Run, error) {
+	return queueHistory(ctx, s.db, treatment, path)
+}
+
+func (s *SQLiteStore) Findings(ctx context.Context, q FindingsQuery) ([]Finding, error) {
+	return queueFindings(ctx, s.db, q)
+}
+
+func (s *SQLiteStore) PutArtifact(_ context.Context, r io.Reader) (string, error) {
+	return putArtifact(artifactDir(s.path), r)
+}
+
+func (s *SQLiteStore) OpenArtifact(_ context.Context, hash string) (io.ReadCloser, error) {
+	return openArtifact(artifactDir(s.path), hash)
+}
+
+func (s *SQLiteStore) Get(ctx context.Context, treatment, path string) (Entry, bool, error) {
+	return getEntry(ctx, s.db, treatment, path)
+}
+
+func (s *SQLiteStore) Stats(ctx context.Context, treatment string) ([]Stats, error) {
+	return queueStatus(ctx, s.db, treatment)
+}
+
+func (s *SQLiteStore) Versions(ctx context.Context, treatment string) ([]VersionCount, error) {
+	return queueVersions(ctx, s.db, treatment)
+}
+
+func (s *SQLiteStore) List(ctx context.Context, opts ListOptions) ([]Entry, error) {
+	return listEntries(ctx, s.db, opts)
+}
+
+func (s *SQLiteStore) Aggregate(ctx context.Context, treatment, agg string) ([]AggregateRow, error) {
+	return queueAggregate(ctx, s.db, treatment, agg)
+}
+
+func (s *SQLiteStore) Costs(ctx context.Context, treatment string) ([]CostRow, error) {
+	return queueCosts(ctx, s.db, treatment)
+}
+
+func (s *SQLiteStore) Workers(ctx context.Context, q WorkersQuery) ([]WorkerStats, error) {
+	return queueWorkers(ctx, s.db, q)
+}
+
+func (s *SQLiteStore) Reset(ctx context.Context, treatment string) (int64, error) {
+	return resetTreatment(ctx, s.db, treatment)
+}
+
+func (s *SQLiteStore) AuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	return queueAudit(ctx, s.db, q)
+}
+
+// Snapshot copies the file with VACUUM INTO and prunes the oldest copies
+// beyond Options.SnapshotKeep.
+func (s *SQLiteStore) Snapshot(ctx context.Context, reason string) (string, error) {
+	return snapshotBefore(ctx, s.db, s.path, s.keep, reason)
+}
+
+func (s *SQLiteStore) Snapshots(context.Context) ([]Snapshot, error) {
+	snaps, err := listSnapshots(snapshotDir(s.path))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Snapshot, 0, len(snaps))
+	for _, sn := range snaps {
+		out = append(out, Snapshot{Name: sn.Name, Reason: sn.Reason, Created: sn.Created, Size: sn.Size})
+	}
+	return out, nil
+}
+
+// Restore snapshots the live file as "undo" before restoring name, and
+// keeps name, so an undo can be reverted by restoring that snapshot.
+func (s *SQLiteStore) Restore(ctx context.Context, name string) error {
+	return undoSnapshot(ctx, s.db, s.path, s.keep, name)
+}
+
+func (s *SQLiteStore) Counters(ctx context.Context) (map[string]map[string]float64, error) {
+	return readCounters(ctx, s.db)
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,172 @@
+package ledger
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Store persists queue rows. A Ledger applies treatment definitions and
+// wakes waiting claims; the Store only has to implement the queue semantics
+// below, which the storetest package checks. SQLiteStore is the durable
+// implementation and MemoryStore a pure in-memory one.
+//
+// Rows are keyed by (path, treatment). Timestamps use the formats the SQLite
+// ledger has always stored: RFC 3339 for done_at and failed_at, and
+// "2006-01-02 15:04:05" UTC for next_at and leased_until, each to the second.
+// Revisit values are SQLite datetime modifiers such as "14 days".
+//
+// Enqueue, Claim, Complete, Fail and Reset, and Reopen when it changes rows
+// and Claim when it returns rows, append an audit entry for the context's
+// actor (see WithActor) atomically with the change, so the log holds exactly
+// the mutations that took effect.
+type Store interface {
+	// Enqueue inserts a pending row per item, leaving existing rows for the
+	// same path and treatment untouched. It reports len(items).
+	Enqueue(ctx context.Context, treatment string, items []Item) (int, error)
+	// Reopen inserts items like Enqueue and also resets any existing row
+	// whose content hash differs to pending under the new hash, clearing its
+	// result, failure, attempts, lease and version. It reports how many rows
+	// were inserted or reset.
+	Reopen(ctx context.Context, treatment string, items []Item) (int, error)
+	// Claim returns up to q.N claimable rows after q.Cursor in path_hash
+	// order and stamps them, leasing them for q.Lease when positive.
+	// Concurrent claims never return the same leased row.
+	Claim(ctx context.Context, q ClaimQuery) ([]Claimed, error)
+	// NextClaimable reports the earliest future lease expiry or revisit
+	// among q's rows, or token refill for a rate-limited q, if any.
+	NextClaimable(ctx context.Context, q ClaimQuery) (time.Time, bool, error)
+	// Complete records a result, clears any failure and lease, and enqueues
+	// the path for opts.Then with the same content hash, reopening follow-up
+	// rows recorded at another hash. It appends a done
+	// run, with opts.Findings, to the path's history and returns the rows
+	// updated. Spend on a path with no row is ErrInvalidSpend.
+	Complete(ctx context.Context, opts CompleteOptions) (int64, error)
+	// Fail records an error on a row not yet done, or on a done row claimed
+	// since it was done (clearing its result), bumps its attempts and
+	// releases its lease. Without opts.Revisit, opts.Retry is applied to
+	// the bumped attempts in the same update, so concurrent failures count
+	// each other. It appends a failed run to the path's history and returns
+	// the rows updated; spend when none is updated is ErrInvalidSpend.
+	Fail(ctx context.Context, opts FailOptions) (int64, error)
+	// History returns the runs recorded for one path, newest first. Runs
+	// survive Reopen and Reset.
+	History(ctx context.Context, treatment, path string) ([]Run, error)
+	// Findings returns the findings of each path's latest done run, or of
+	// the done run before it when q.Previous is set, that match q, ordered
+	// by path, treatment, start line, end line, rule and message. Findings
+	// recorded without a fingerprint get the ledger's default one.
+	Findings(ctx context.Context, q FindingsQuery) ([]Finding, error)
+	// Get returns one row, and false when it does not exist.
+	Get(ctx context.Context, treatment, path string) (Entry, bool, error)
+	// Stats counts rows per treatment, or for one treatment when non-empty.
+	// MaxRunning is left for the Ledger to fill in.
+	Stats(ctx context.Context, treatment string) ([]Stats, error)
+	// Versions counts done rows per treatment and recorded version. Current
+	// is left for the Ledger to fill in.
+	Versions(ctx context.Context, treatment string) ([]VersionCount, error)
+	// List returns rows matching opts, or ErrUnknownState. opts.Where is
+	// applied to result JSON with SQLite's json_extract semantics, or
+	// rejected with ErrInvalidQuery.
+	List(ctx context.Context, opts ListOptions) ([]Entry, error)
+	// Aggregate computes agg, such as "avg($.score)", per treatment over the
+	// numeric values at its path in done rows' result JSON, or for one
+	// treatment when non-empty. A malformed agg is ErrInvalidQuery.
+	Aggregate(ctx context.Context, treatment, agg string) ([]AggregateRow, error)
+	// Workers summarizes claims and runs per worker id, ordered by id.
+	// Anonymous claims and runs are not reported.
+	Workers(ctx context.Context, q WorkersQuery) ([]WorkerStats, error)
+	// Costs totals the cost and tokens recorded by runs per treatment and
+	// UTC day, ordered by both, or for one treatment when non-empty. Runs
+	// that recorded neither are not counted.
+	Costs(ctx context.Context, treatment string) ([]CostRow, error)
+	// Reset deletes every row for treatment and reports how many.
+	Reset(ctx context.Context, treatment string) (int64, error)
+	// AuditLog returns the entries at or after q.Since, an RFC 3339 UTC
+	// time, whose command is q.Command, oldest first. A positive q.Limit
+	// keeps only the newest entries. The log survives Reset.
+	AuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, error)
+	Close() error
+}
+
+// ClaimQuery is a claim with the treatment's definition already applied.
+type ClaimQuery struct {
+	Treatment string
+	Cursor    string
+	N         int
+	Lease     time.Duration
+	// Version is the treatment's current version; done rows recorded under
+	// another version are claimable again. Empty disables the check.
+	Version string
+	// After lists upstream treatments that must be done for the same path
+	// and content hash before a row is claimable.
+	After []string
+	// Worker is recorded as the claimed rows' holder until they are done,
+	// failed or reopened, and their runs are attributed to it.
+	Worker string
+	// Rate, when positive, limits claims with a token bucket per treatment
+	// shared by every claimer of the store: it refills at Rate tokens per
+	// second up to Burst, starts full, and each claimed row takes a token.
+	// An empty bucket makes Claim return nothing.
+	Rate  float64
+	Burst int
+	// MaxLeases, when positive, caps the treatment's live leases: Claim
+	// returns at most MaxLeases minus those already held, and nothing once
+	// the cap is reached.
+	MaxLeases int
+	// Budget, when set, makes Claim return ErrBudgetExhausted once the
+	// treatment's runs since Budget.Since have spent its cost or tokens.
+	Budget *BudgetQuery
+}
+
+// BudgetQuery is a treatment budget resolved for one claim. Zero limits are
+// unchecked; Since is an RFC 3339 UTC time, or empty for every run.
+type BudgetQuery struct {
+	Cost   float64
+	Tokens int64
+	Since  string
+}
+
+// WorkersQuery selects the workers Store.Workers reports. Since and
+// StaleBefore are RFC 3339 UTC times.
+type WorkersQuery struct {
+	// Treatment restricts claims and runs to one treatment when non-empty.
+	Treatment string
+	// Since starts the window done runs are counted as Recent in.
+	Since string
+	// StaleBefore marks unleased claims made before it as stale; leased
+	// claims are stale once their lease expires.
+	StaleBefore string
+}
+
+// Snapshotter is implemented by stores that can copy themselves aside.
+// Ledger.Reset takes a snapshot first when the store supports it.
+type Snapshotter interface {
+	// Snapshot copies the store aside, returning the copy's name or "" when
+	// snapshots are disabled.
+	Snapshot(ctx context.Context, reason string) (string, error)
+	// Snapshots lists the retained snapshots, oldest first.
+	Snapshots(ctx context.Context) ([]Snapshot, error)
+	// Restore replaces the store's contents with the named snapshot, or
+	// returns ErrSnapshotNotFound. It keeps the snapshot and, when
+	// snapshots are enabled, first takes one of the contents it replaces.
+	// The audit log is kept, with an "undo" entry for the restore.
+	Restore(ctx context.Context, name string) error
+}
+
+// CounterStore is implemented by stores that keep running totals per
+// treatment for the metrics endpoint: enqueued, claimed and completed counts
+// and the claim-to-done latency histogram.
+type CounterStore interface {
+	Counters(ctx context.Context) (map[string]map[string]float64, error)
+}
+
+// ArtifactStore is implemented by stores that keep full treatment output,
+// addressed by the sha256 hex digest of its content so identical output is
+// stored once.
+type ArtifactStore interface {
+	// PutArtifact stores r's content and returns its digest.
+	PutArtifact(ctx context.Context, r io.Reader) (string, error)
+	// OpenArtifact returns a stored blob, or ErrArtifactNotFound.
+	OpenArtifact(ctx context.Context, hash string) (io.ReadCloser, error)
+}
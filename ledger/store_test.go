@@ -0,0 +1,23 @@
+package ledger_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dkoosis/next/ledger"
+	"github.com/dkoosis/next/ledger/storetest"
+)
+
+func TestSQLiteStore_Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) ledger.Store {
+		s, err := ledger.OpenSQLiteStore(filepath.Join(t.TempDir(), "ledger.db"), ledger.Options{})
+		if err != nil {
+			t.Fatalf("OpenSQLiteStore: %v", err)
+		}
+		return s
+	})
+}
+
+func TestMemoryStore_Conformance(t *testing.T) {
+	storetest.Run(t, func(*testing.T) ledger.Store { return ledger.NewMemoryStore() })
+}
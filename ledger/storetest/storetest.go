@@ -0,0 +1,887 @@
+// Package storetest checks a ledger.Store implementation against the queue
+// semantics the next CLI relies on. A backend's tests call Run with a
+// constructor for empty stores:
+//
+//	func TestMyStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) ledger.Store { return newMyStore(t) })
+//	}
+package storetest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dkoosis/next/ledger"
+)
+
+// Run runs the conformance suite, calling newStore for a fresh, empty store
+// in each subtest. Stores are closed by the suite.
+func Run(t *testing.T, newStore func(t *testing.T) ledger.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s ledger.Store)
+	}{
+		{"EnqueueIgnoresExistingRows", testEnqueue},
+		{"ReopenResetsChangedRows", testReopen},
+		{"ClaimFollowsPathHashOrder", testClaimOrder},
+		{"ClaimResumesAfterCursor", testClaimCursor},
+		{"LeaseHidesClaimedRows", testLease},
+		{"CompleteRecordsResult", testComplete},
+		{"RevisitMakesDoneRowsDue", testRevisit},
+		{"FailHidesRowUntilRetryDue", testFail},
+		{"FailRecordsReclaimedDoneRow", testFailReclaimed},
+		{"FailAppliesRetryToBumpedAttempts", testFailRetry},
+		{"StaleVersionIsClaimable", testVersion},
+		{"AfterWaitsForUpstream", testAfter},
+		{"CompleteEnqueuesFollowUps", testThen},
+		{"StatsCountStates", testStats},
+		{"ListFiltersAndLimits", testList},
+		{"ResetDeletesOneTreatment", testReset},
+		{"NextClaimableReportsEarliest", testNextClaimable},
+		{"HistoryKeepsEveryRun", testHistory},
+		{"ArtifactsAreStoredOnce", testArtifacts},
+		{"FindingsFollowLatestDoneRun", testFindings},
+		{"WhereFiltersResultJSON", testWhere},
+		{"AggregateResultJSON", testAggregate},
+		{"AuditLogRecordsMutationsAndSurvivesReset", testAudit},
+		{"WorkersTrackClaimsAndRuns", testWorkers},
+		{"RateLimitSpendsTokens", testRateLimit},
+		{"MaxLeasesCapsLiveLeases", testMaxLeases},
+		{"MaxLeasesAppliesWithRateLimit", testMaxLeasesWithRate},
+		{"BudgetStopsClaimsOnceSpent", testBudget},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore(t)
+			t.Cleanup(func() { _ = s.Close() })
+			tt.fn(t, s)
+		})
+	}
+}
+
+func enqueue(t *testing.T, s ledger.Store, treatment string, paths ...string) {
+	t.Helper()
+	items := make([]ledger.Item, 0, len(paths))
+	for _, p := range paths {
+		items = append(items, ledger.Item{Path: p, ContentHash: "hash-" + path.Base(p)})
+	}
+	if _, err := s.Enqueue(context.Background(), treatment, items); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+}
+
+func claim(t *testing.T, s ledger.Store, q ledger.ClaimQuery) []string {
+	t.Helper()
+	items, err := s.Claim(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Claim(%+v): %v", q, err)
+	}
+	paths := make([]string, 0, len(items))
+	for _, it := range items {
+		paths = append(paths, it.Path)
+	}
+	return paths
+}
+
+func complete(t *testing.T, s ledger.Store, opts ledger.CompleteOptions) {
+	t.Helper()
+	if n, err := s.Complete(context.Background(), opts); err != nil || n != 1 {
+		t.Fatalf("Complete(%+v) = %d, %v; want 1 row", opts, n, err)
+	}
+}
+
+func fail(t *testing.T, s ledger.Store, opts ledger.FailOptions) {
+	t.Helper()
+	if n, err := s.Fail(context.Background(), opts); err != nil || n != 1 {
+		t.Fatalf("Fail(%+v) = %d, %v; want 1 row", opts, n, err)
+	}
+}
+
+func get(t *testing.T, s ledger.Store, treatment, p string) ledger.Entry {
+	t.Helper()
+	e, ok, err := s.Get(context.Background(), treatment, p)
+	if err != nil || !ok {
+		t.Fatalf("Get(%s, %s) = %v, %v", treatment, p, ok, err)
+	}
+	return e
+}
+
+// byHash orders paths as claims must return them.
+func byHash(paths ...string) []string {
+	out := append([]string(nil), paths...)
+	sort.Slice(out, func(i, j int) bool { return ledger.PathHash(out[i]) < ledger.PathHash(out[j]) })
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testEnqueue(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	n, err := s.Enqueue(ctx, "lint", []ledger.Item{{Path: "/src/a.go", ContentHash: "h1"}, {Path: "/src/b.go", ContentHash: "h2"}})
+	if err != nil || n != 2 {
+		t.Fatalf("Enqueue = %d, %v; want 2", n, err)
+	}
+	if _, err := s.Enqueue(ctx, "lint", []ledger.Item{{Path: "/src/a.go", ContentHash: "h3"}}); err != nil {
+		t.Fatalf("Enqueue again: %v", err)
+	}
+	e := get(t, s, "lint", "/src/a.go")
+	want := ledger.Entry{Path: "/src/a.go", PathHash: ledger.PathHash("/src/a.go"), ContentHash: "h1", Treatment: "lint", State: "pending"}
+	if e != want {
+		t.Fatalf("Get = %+v, want %+v", e, want)
+	}
+	if _, ok, err := s.Get(ctx, "other", "/src/a.go"); err != nil || ok {
+		t.Fatalf("Get in another treatment = %v, %v; want no row", ok, err)
+	}
+}
+
+func testReopen(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	enqueue(t, s, "lint", "/src/a.go", "/src/b.go")
+	claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 2, Lease: time.Hour})
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "lint", Result: "ok", Revisit: "14 days", Version: "v1"})
+	fail(t, s, ledger.FailOptions{Path: "/src/b.go", Treatment: "lint", Error: "boom"})
+
+	n, err := s.Reopen(ctx, "lint", []ledger.Item{
+		{Path: "/src/a.go", ContentHash: "edited"},
+		{Path: "/src/b.go", ContentHash: "hash-b.go"},
+		{Path: "/src/c.go", ContentHash: "new"},
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("Reopen = %d, %v; want 2 (a.go changed, c.go new)", n, err)
+	}
+	want := ledger.Entry{Path: "/src/a.go", PathHash: ledger.PathHash("/src/a.go"), ContentHash: "edited", Treatment: "lint", State: "pending"}
+	if e := get(t, s, "lint", "/src/a.go"); e != want {
+		t.Fatalf("reopened row = %+v, want %+v", e, want)
+	}
+	if e := get(t, s, "lint", "/src/b.go"); e.State != "failed" || e.Attempts != 1 {
+		t.Fatalf("unchanged row = %+v, want it left failed", e)
+	}
+	if e := get(t, s, "lint", "/src/c.go"); e.State != "pending" || e.ContentHash != "new" {
+		t.Fatalf("new row = %+v", e)
+	}
+}
+
+func testClaimOrder(t *testing.T, s ledger.Store) {
+	paths := []string{"/src/a.go", "/src/b.go", "/src/c.go", "/src/d.go"}
+	enqueue(t, s, "lint", paths...)
+	want := byHash(paths...)
+
+	if got := claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 3}); !equal(got, want[:3]) {
+		t.Fatalf("claimed %v, want %v", got, want[:3])
+	}
+	// Without a lease claimed rows stay claimable.
+	if got := claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 10}); !equal(got, want) {
+		t.Fatalf("claimed %v, want %v", got, want)
+	}
+	if got := claim(t, s, ledger.ClaimQuery{Treatment: "other", N: 10}); len(got) != 0 {
+		t.Fatalf("claimed %v from an empty treatment", got)
+	}
+}
+
+func testClaimCursor(t *testing.T, s ledger.Store) {
+	paths := byHash("/src/a.go", "/src/b.go", "/src/c.go")
+	enqueue(t, s, "lint", paths...)
+
+	got := claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 10, Cursor: ledger.PathHash(paths[0])})
+	if !equal(got, paths[1:]) {
+		t.Fatalf("claimed %v after the first hash, want %v", got, paths[1:])
+	}
+}
+
+func testLease(t *testing.T, s ledger.Store) {
+	enqueue(t, s, "lint", "/src/a.go", "/src/b.go")
+
+	first := claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 1, Lease: time.Hour})
+	second := claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 2, Lease: time.Hour})
+	if len(first) != 1 || len(second) != 1 || first[0] == second[0] {
+		t.Fatalf("claimed %v then %v, want two different rows", first, second)
+	}
+	if got := claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 2}); len(got) != 0 {
+		t.Fatalf("claimed %v while every row is leased", got)
+	}
+	e := get(t, s, "lint", first[0])
+	if e.State != "leased" || e.LeasedUntil == "" {
+		t.Fatalf("leased row = %+v", e)
+	}
+	// Completing releases the lease.
+	complete(t, s, ledger.CompleteOptions{Path: first[0], Treatment: "lint"})
+	if e := get(t, s, "lint", first[0]); e.LeasedUntil != "" {
+		t.Fatalf("lease survived completion: %+v", e)
+	}
+}
+
+func testComplete(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	enqueue(t, s, "lint", "/src/a.go")
+
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "lint", Result: "ok", Version: "v1"})
+	e := get(t, s, "lint", "/src/a.go")
+	if e.State != "done" || e.Result != "ok" || e.Version != "v1" || e.NextAt != "" {
+		t.Fatalf("done row = %+v", e)
+	}
+	if _, err := time.Parse(time.RFC3339, e.DoneAt); err != nil {
+		t.Fatalf("done_at %q is not RFC 3339: %v", e.DoneAt, err)
+	}
+	if got := claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 1}); len(got) != 0 {
+		t.Fatalf("claimed done row %v", got)
+	}
+	if n, err := s.Complete(ctx, ledger.CompleteOptions{Path: "/src/missing.go", Treatment: "lint"}); err != nil || n != 0 {
+		t.Fatalf("Complete(missing) = %d, %v; want 0 rows", n, err)
+	}
+}
+
+func testRevisit(t *testing.T, s ledger.Store) {
+	enqueue(t, s, "lint", "/src/a.go", "/src/b.go")
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "lint", Revisit: "-1 seconds"})
+	complete(t, s, ledger.CompleteOptions{Path: "/src/b.go", Treatment: "lint", Revisit: "14 days"})
+
+	e := get(t, s, "lint", "/src/b.go")
+	next, err := time.ParseInLocation(time.DateTime, e.NextAt, time.UTC)
+	if err != nil {
+		t.Fatalf("next_at %q: %v", e.NextAt, err)
+	}
+	if d := time.Until(next); d < 13*24*time.Hour || d > 15*24*time.Hour {
+		t.Fatalf("next_at %s is %v away, want about 14 days", e.NextAt, d)
+	}
+	if got := claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 5}); !equal(got, []string{"/src/a.go"}) {
+		t.Fatalf("claimed %v, want only the due /src/a.go", got)
+	}
+}
+
+func testFail(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	enqueue(t, s, "lint", "/src/a.go", "/src/b.go", "/src/c.go")
+	claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 3, Lease: time.Hour})
+
+	fail(t, s, ledger.FailOptions{Path: "/src/a.go", Treatment: "lint", Error: "boom"})
+	fail(t, s, ledger.FailOptions{Path: "/src/b.go", Treatment: "lint", Error: "flaky", Revisit: "-1 seconds"})
+	fail(t, s, ledger.FailOptions{Path: "/src/b.go", Treatment: "lint", Error: "flaky", Revisit: "-1 seconds"})
+
+	e := get(t, s, "lint", "/src/b.go")
+	if e.State != "failed" || e.Error != "flaky" || e.Attempts != 2 || e.LeasedUntil != "" {
+		t.Fatalf("failed row = %+v", e)
+	}
+	if got := claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 5}); !equal(got, []string{"/src/b.go"}) {
+		t.Fatalf("claimed %v, want only the due retry /src/b.go", got)
+	}
+
+	complete(t, s, ledger.CompleteOptions{Path: "/src/b.go", Treatment: "lint"})
+	if e := get(t, s, "lint", "/src/b.go"); e.FailedAt != "" || e.Error != "" || e.Attempts != 2 {
+		t.Fatalf("completed row = %+v, want failure cleared and attempts kept", e)
+	}
+	if n, err := s.Fail(ctx, ledger.FailOptions{Path: "/src/b.go", Treatment: "lint"}); err != nil || n != 0 {
+		t.Fatalf("Fail on a done row = %d, %v; want 0 rows", n, err)
+	}
+}
+
+func testFailReclaimed(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	enqueue(t, s, "review", "/src/a.go", "/src/b.go")
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "review", Result: "ok", Version: "v1"})
+	complete(t, s, ledger.CompleteOptions{Path: "/src/b.go", Treatment: "review", Result: "ok", Revisit: "-1 seconds"})
+
+	got := claim(t, s, ledger.ClaimQuery{Treatment: "review", N: 2, Lease: time.Hour, Version: "v2"})
+	if !equal(got, byHash("/src/a.go", "/src/b.go")) {
+		t.Fatalf("claimed %v, want the stale and the due row", got)
+	}
+	for _, p := range got {
+		fail(t, s, ledger.FailOptions{Path: p, Treatment: "review", Error: "boom"})
+		e := get(t, s, "review", p)
+		if e.State != "failed" || e.Error != "boom" || e.Result != "" || e.LeasedUntil != "" {
+			t.Fatalf("failed row = %+v, want failed with the lease released", e)
+		}
+		if runs, err := s.History(ctx, "review", p); err != nil || len(runs) != 2 || runs[0].State != "failed" {
+			t.Fatalf("History(%s) = %+v, %v; want the failure after the done run", p, runs, err)
+		}
+	}
+	if n, err := s.Fail(ctx, ledger.FailOptions{Path: "/src/a.go", Treatment: "review"}); err != nil || n != 1 {
+		t.Fatalf("Fail on a failed row = %d, %v; want 1 row", n, err)
+	}
+}
+
+func testFailRetry(t *testing.T, s ledger.Store) {
+	enqueue(t, s, "review", "/src/a.go")
+	retry := &ledger.RetryPolicy{MaxAttempts: 2, Backoff: "-1 seconds"}
+
+	fail(t, s, ledger.FailOptions{Path: "/src/a.go", Treatment: "review", Error: "boom", Retry: retry})
+	if e := get(t, s, "review", "/src/a.go"); e.Attempts != 1 || e.NextAt == "" {
+		t.Fatalf("after one failure %+v, want attempts 1 and a retry scheduled", e)
+	}
+	fail(t, s, ledger.FailOptions{Path: "/src/a.go", Treatment: "review", Error: "boom", Retry: retry})
+	if e := get(t, s, "review", "/src/a.go"); e.Attempts != 2 || e.NextAt != "" {
+		t.Fatalf("after max_attempts failures %+v, want no retry", e)
+	}
+	if got := claim(t, s, ledger.ClaimQuery{Treatment: "review", N: 1}); len(got) != 0 {
+		t.Fatalf("claimed %v after the retries ran out", got)
+	}
+}
+
+func testVersion(t *testing.T, s ledger.Store) {
+	enqueue(t, s, "review", "/src/a.go", "/src/b.go")
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "review", Version: "v1"})
+	complete(t, s, ledger.CompleteOptions{Path: "/src/b.go", Treatment: "review", Version: "v2"})
+
+	if got := claim(t, s, ledger.ClaimQuery{Treatment: "review", N: 5}); len(got) != 0 {
+		t.Fatalf("claimed %v without a current version", got)
+	}
+	if got := claim(t, s, ledger.ClaimQuery{Treatment: "review", N: 5, Version: "v2"}); !equal(got, []string{"/src/a.go"}) {
+		t.Fatalf("claimed %v at v2, want the v1 row", got)
+	}
+
+	versions, err := s.Versions(context.Background(), "review")
+	if err != nil {
+		t.Fatalf("Versions: %v", err)
+	}
+	want := []ledger.VersionCount{{Treatment: "review", Version: "v1", Done: 1}, {Treatment: "review", Version: "v2", Done: 1}}
+	if len(versions) != 2 || versions[0] != want[0] || versions[1] != want[1] {
+		t.Fatalf("versions = %+v, want %+v", versions, want)
+	}
+}
+
+func testAfter(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	enqueue(t, s, "gofmt", "/src/a.go")
+	if _, err := s.Enqueue(ctx, "gofmt", []ledger.Item{{Path: "/src/b.go", ContentHash: "older"}}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	enqueue(t, s, "review", "/src/a.go", "/src/b.go", "/src/c.go")
+	q := ledger.ClaimQuery{Treatment: "review", N: 5, After: []string{"gofmt"}}
+
+	if got := claim(t, s, q); len(got) != 0 {
+		t.Fatalf("claimed %v before gofmt ran", got)
+	}
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "gofmt"})
+	complete(t, s, ledger.CompleteOptions{Path: "/src/b.go", Treatment: "gofmt"})
+	// b.go's gofmt result is for other content and c.go has no gofmt row.
+	if got := claim(t, s, q); !equal(got, []string{"/src/a.go"}) {
+		t.Fatalf("claimed %v, want only /src/a.go", got)
+	}
+}
+
+func testThen(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	enqueue(t, s, "extract", "/src/a.go")
+	enqueue(t, s, "index", "/src/a.go")
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "index", Result: "kept"})
+
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "extract", Then: []string{"summarize", "index"}})
+	if e := get(t, s, "summarize", "/src/a.go"); e.State != "pending" || e.ContentHash != "hash-a.go" {
+		t.Fatalf("follow-up = %+v, want pending with the extract content hash", e)
+	}
+	if e := get(t, s, "index", "/src/a.go"); e.Result != "kept" {
+		t.Fatalf("existing follow-up row was replaced: %+v", e)
+	}
+	if _, err := s.Complete(ctx, ledger.CompleteOptions{Path: "/src/missing.go", Treatment: "extract", Then: []string{"summarize"}}); err != nil {
+		t.Fatalf("Complete(missing): %v", err)
+	}
+	if _, ok, err := s.Get(ctx, "summarize", "/src/missing.go"); err != nil || ok {
+		t.Fatalf("follow-up for a path never enqueued = %v, %v", ok, err)
+	}
+
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "summarize", Result: "old summary"})
+	if _, err := s.Reopen(ctx, "extract", []ledger.Item{{Path: "/src/a.go", ContentHash: "hash-a2"}}); err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "extract", Then: []string{"summarize"}})
+	if e := get(t, s, "summarize", "/src/a.go"); e.State != "pending" || e.ContentHash != "hash-a2" || e.Result != "" {
+		t.Fatalf("follow-up after the content changed = %+v, want pending at hash-a2", e)
+	}
+}
+
+func testStats(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	enqueue(t, s, "lint", "/src/a.go", "/src/b.go", "/src/c.go", "/src/d.go")
+	enqueue(t, s, "review", "/src/a.go")
+	claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 1, Lease: time.Hour, Cursor: ledger.PathHash(byHash("/src/a.go", "/src/b.go", "/src/c.go", "/src/d.go")[2])})
+	paths := byHash("/src/a.go", "/src/b.go", "/src/c.go")
+	complete(t, s, ledger.CompleteOptions{Path: paths[0], Treatment: "lint", Revisit: "-1 seconds"})
+	complete(t, s, ledger.CompleteOptions{Path: paths[1], Treatment: "lint"})
+	fail(t, s, ledger.FailOptions{Path: paths[2], Treatment: "lint"})
+
+	rows, err := s.Stats(ctx, "")
+	if err != nil {
+		t.Fatalf("Stats: %v", err)
+	}
+	want := []ledger.Stats{
+		{Treatment: "lint", Pending: 2, Leased: 1, Failed: 1, Done: 2, Due: 1, Running: 1},
+		{Treatment: "review", Pending: 1},
+	}
+	if len(rows) != 2 || rows[0] != want[0] || rows[1] != want[1] {
+		t.Fatalf("Stats = %+v, want %+v", rows, want)
+	}
+	if rows, err := s.Stats(ctx, "review"); err != nil || len(rows) != 1 || rows[0] != want[1] {
+		t.Fatalf("Stats(review) = %+v, %v", rows, err)
+	}
+}
+
+func testList(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	paths := byHash("/src/a.go", "/src/b.go", "/src/c.go")
+	enqueue(t, s, "lint", paths...)
+	enqueue(t, s, "review", "/src/a.go")
+	fail(t, s, ledger.FailOptions{Path: paths[1], Treatment: "lint", Error: "boom"})
+	complete(t, s, ledger.CompleteOptions{Path: paths[2], Treatment: "lint", Result: "ok"})
+
+	all, err := s.List(ctx, ledger.ListOptions{})
+	if err != nil || len(all) != 4 || all[0].Path != paths[0] || all[3].Treatment != "review" {
+		t.Fatalf("List = %+v, %v; want lint rows in hash order, then review", all, err)
+	}
+	for state, want := range map[string][]string{
+		"pending": paths[:2],
+		"failed":  paths[1:2],
+		"done":    paths[2:],
+		"due":     nil,
+		"leased":  nil,
+	} {
+		entries, err := s.List(ctx, ledger.ListOptions{Treatment: "lint", State: state})
+		if err != nil {
+			t.Fatalf("List(%s): %v", state, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Path)
+		}
+		if !equal(got, want) {
+			t.Errorf("List(%s) = %v, want %v", state, got, want)
+		}
+	}
+	if entries, err := s.List(ctx, ledger.ListOptions{Treatment: "lint", Limit: 1}); err != nil || len(entries) != 1 || entries[0].Path != paths[0] {
+		t.Fatalf("List(limit 1) = %+v, %v", entries, err)
+	}
+	if entries, err := s.List(ctx, ledger.ListOptions{Treatment: "lint", Newest: true}); err != nil || len(entries) != 3 || entries[2].Path != paths[0] {
+		t.Fatalf("List(newest) = %+v, %v; want the row with no result last", entries, err)
+	}
+	if _, err := s.List(ctx, ledger.ListOptions{State: "bogus"}); !errors.Is(err, ledger.ErrUnknownState) {
+		t.Fatalf("List(bogus) err = %v, want ErrUnknownState", err)
+	}
+}
+
+func testReset(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	enqueue(t, s, "lint", "/src/a.go", "/src/b.go")
+	enqueue(t, s, "review", "/src/a.go")
+
+	if n, err := s.Reset(ctx, "lint"); err != nil || n != 2 {
+		t.Fatalf("Reset = %d, %v; want 2", n, err)
+	}
+	rows, err := s.Stats(ctx, "")
+	if err != nil || len(rows) != 1 || rows[0].Treatment != "review" {
+		t.Fatalf("Stats after reset = %+v, %v", rows, err)
+	}
+}
+
+func testNextClaimable(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	q := ledger.ClaimQuery{Treatment: "lint", N: 1}
+	if _, ok, err := s.NextClaimable(ctx, q); err != nil || ok {
+		t.Fatalf("NextClaimable on an empty store = %v, %v", ok, err)
+	}
+	enqueue(t, s, "lint", "/src/a.go", "/src/b.go")
+	claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 1, Lease: time.Hour})
+	paths := byHash("/src/a.go", "/src/b.go")
+	complete(t, s, ledger.CompleteOptions{Path: paths[1], Treatment: "lint", Revisit: "10 minutes"})
+
+	at, ok, err := s.NextClaimable(ctx, q)
+	if err != nil || !ok {
+		t.Fatalf("NextClaimable = %v, %v", ok, err)
+	}
+	if d := time.Until(at); d < 8*time.Minute || d > 11*time.Minute {
+		t.Fatalf("next claimable in %v, want the revisit about 10 minutes out", d)
+	}
+}
+
+func testHistory(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	enqueue(t, s, "lint", "/src/a.go", "/src/b.go")
+	fail(t, s, ledger.FailOptions{Path: "/src/a.go", Treatment: "lint", Error: "boom", Revisit: "-1 seconds"})
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "lint", Result: "ok", Version: "v1", Artifact: "blob"})
+	complete(t, s, ledger.CompleteOptions{Path: "/src/b.go", Treatment: "lint", Result: "other"})
+	if _, err := s.Reset(ctx, "lint"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	runs, err := s.History(ctx, "lint", "/src/a.go")
+	if err != nil || len(runs) != 2 {
+		t.Fatalf("History = %+v, %v; want 2 runs surviving the reset", runs, err)
+	}
+	done, failed := runs[0], runs[1]
+	if done.ID <= failed.ID || done.State != "done" || done.Result != "ok" || done.Version != "v1" || done.Artifact != "blob" || done.ContentHash != "hash-a.go" {
+		t.Fatalf("newest run = %+v, want the done run", done)
+	}
+	if failed.State != "failed" || failed.Error != "boom" || failed.Artifact != "" || failed.Version != "" {
+		t.Fatalf("oldest run = %+v, want the failed run", failed)
+	}
+	if _, err := time.Parse(time.RFC3339, done.At); err != nil {
+		t.Fatalf("run at %q is not RFC 3339: %v", done.At, err)
+	}
+	if runs, err := s.History(ctx, "review", "/src/a.go"); err != nil || len(runs) != 0 {
+		t.Fatalf("History(review) = %+v, %v; want none", runs, err)
+	}
+}
+
+func testArtifacts(t *testing.T, s ledger.Store) {
+	as, ok := s.(ledger.ArtifactStore)
+	if !ok {
+		t.Skip("store keeps no artifacts")
+	}
+	ctx := context.Background()
+	hash, err := as.PutArtifact(ctx, strings.NewReader("full output\n"))
+	if err != nil {
+		t.Fatalf("PutArtifact: %v", err)
+	}
+	sum := sha256.Sum256([]byte("full output\n"))
+	if hash != hex.EncodeToString(sum[:]) {
+		t.Fatalf("PutArtifact = %s, want the content's sha256", hash)
+	}
+	again, err := as.PutArtifact(ctx, strings.NewReader("full output\n"))
+	if err != nil || again != hash {
+		t.Fatalf("PutArtifact again = %s, %v; want %s", again, err, hash)
+	}
+
+	rc, err := as.OpenArtifact(ctx, hash)
+	if err != nil {
+		t.Fatalf("OpenArtifact: %v", err)
+	}
+	b, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil || string(b) != "full output\n" {
+		t.Fatalf("artifact = %q, %v", b, err)
+	}
+	for _, bad := range []string{hex.EncodeToString(make([]byte, sha256.Size)), "../ledger.db"} {
+		if _, err := as.OpenArtifact(ctx, bad); !errors.Is(err, ledger.ErrArtifactNotFound) {
+			t.Fatalf("OpenArtifact(%q) err = %v, want ErrArtifactNotFound", bad, err)
+		}
+	}
+}
+
+func testFindings(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	enqueue(t, s, "lint", "/src/a.go", "/src/b.go")
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "lint", Findings: []ledger.Finding{
+		{Rule: "unused", Level: "warning", StartLine: 3, EndLine: 3, Message: "x is unused"},
+	}})
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "lint", Findings: []ledger.Finding{
+		{Rule: "shadow", Level: "error", StartLine: 9, EndLine: 10, Message: "err shadowed"},
+		{Rule: "unused", Level: "warning", StartLine: 4, EndLine: 4, Message: "x is unused"},
+	}})
+	complete(t, s, ledger.CompleteOptions{Path: "/src/b.go", Treatment: "lint", Findings: []ledger.Finding{
+		{Rule: "unused", Level: "note", StartLine: 1, EndLine: 1, Message: "y is unused"},
+	}})
+	// A failure after the done run leaves the findings current.
+	if _, err := s.Reopen(ctx, "lint", []ledger.Item{{Path: "/src/b.go", ContentHash: "edited"}}); err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	fail(t, s, ledger.FailOptions{Path: "/src/b.go", Treatment: "lint", Error: "boom"})
+
+	got, err := s.Findings(ctx, ledger.FindingsQuery{Treatment: "lint"})
+	if err != nil || len(got) != 3 {
+		t.Fatalf("Findings = %+v, %v; want the 3 from the latest done runs", got, err)
+	}
+	if got[0].Path != "/src/a.go" || got[0].StartLine != 4 || got[1].Rule != "shadow" || got[2].Path != "/src/b.go" {
+		t.Fatalf("Findings = %+v, want ordered by path and line", got)
+	}
+	runs, err := s.History(ctx, "lint", "/src/a.go")
+	if err != nil || got[0].Run != runs[0].ID || got[0].Treatment != "lint" {
+		t.Fatalf("finding %+v not linked to the latest run %+v (%v)", got[0], runs, err)
+	}
+
+	if got, err := s.Findings(ctx, ledger.FindingsQuery{Rule: "unused", Path: "/src/b.go"}); err != nil || len(got) != 1 || got[0].Level != "note" {
+		t.Fatalf("Findings(unused, b.go) = %+v, %v", got, err)
+	}
+	if got[0].Fingerprint == "" || got[0].Fingerprint != ledger.Fingerprint("unused", "x is unused") {
+		t.Fatalf("finding %+v lacks the default fingerprint", got[0])
+	}
+
+	prev, err := s.Findings(ctx, ledger.FindingsQuery{Path: "/src/a.go", Previous: true})
+	if err != nil || len(prev) != 1 || prev[0].StartLine != 3 {
+		t.Fatalf("Findings(previous) = %+v, %v; want the first run's finding", prev, err)
+	}
+	if prev, err := s.Findings(ctx, ledger.FindingsQuery{Path: "/src/b.go", Previous: true}); err != nil || len(prev) != 0 {
+		t.Fatalf("Findings(previous) for one run = %+v, %v; want none", prev, err)
+	}
+
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "lint"})
+	if got, err := s.Findings(ctx, ledger.FindingsQuery{Path: "/src/a.go"}); err != nil || len(got) != 0 {
+		t.Fatalf("Findings after a clean run = %+v, %v; want none", got, err)
+	}
+}
+
+func testWhere(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	paths := byHash("/src/a.go", "/src/b.go", "/src/c.go", "/src/d.go")
+	enqueue(t, s, "review", paths...)
+	complete(t, s, ledger.CompleteOptions{Path: paths[0], Treatment: "review", ResultJSON: `{"score":3,"lang":"go","ok":true,"tags":{"hot":1}}`})
+	complete(t, s, ledger.CompleteOptions{Path: paths[1], Treatment: "review", ResultJSON: `{"score":7.5,"lang":"py","ok":false,"note":null}`})
+	complete(t, s, ledger.CompleteOptions{Path: paths[2], Treatment: "review", ResultJSON: `{"score":"n/a"}`})
+	complete(t, s, ledger.CompleteOptions{Path: paths[3], Treatment: "review"})
+
+	for where, want := range map[string][]string{
+		"$.score < 5":                     paths[:1],
+		"$.score >= 3 and $.lang != 'go'": paths[1:2],
+		"$.lang = \"go\"":                 paths[:1],
+		"$.ok == true":                    paths[:1],
+		"$.ok = false AND $.score > 7":    paths[1:2],
+		"$.tags.hot = 1":                  paths[:1],
+		"$.note = null":                   paths[1:2],
+		"$.lang != null":                  paths[:2],
+		"$.score > 100":                   paths[2:3], // text sorts after numbers
+		"$.missing = 1":                   nil,
+	} {
+		entries, err := s.List(ctx, ledger.ListOptions{Treatment: "review", Where: where})
+		if err != nil {
+			t.Fatalf("List(where %q): %v", where, err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Path)
+		}
+		if !equal(got, want) {
+			t.Errorf("List(where %q) = %v, want %v", where, got, want)
+		}
+	}
+	if e := get(t, s, "review", paths[0]); e.ResultJSON == "" {
+		t.Fatalf("entry %+v lacks its result JSON", e)
+	}
+	for _, bad := range []string{"score < 5", "$.score ~ 5", "$.a; DROP TABLE queue < 1", "$.score < five", "$.note > null"} {
+		if _, err := s.List(ctx, ledger.ListOptions{Where: bad}); !errors.Is(err, ledger.ErrInvalidQuery) {
+			t.Errorf("List(where %q) err = %v, want ErrInvalidQuery", bad, err)
+		}
+	}
+}
+
+func testAggregate(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	enqueue(t, s, "review", "/src/a.go", "/src/b.go", "/src/c.go", "/src/d.go")
+	enqueue(t, s, "lint", "/src/a.go")
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "review", ResultJSON: `{"score":2}`})
+	complete(t, s, ledger.CompleteOptions{Path: "/src/b.go", Treatment: "review", ResultJSON: `{"score":7}`})
+	complete(t, s, ledger.CompleteOptions{Path: "/src/c.go", Treatment: "review", ResultJSON: `{"score":"n/a"}`})
+	complete(t, s, ledger.CompleteOptions{Path: "/src/a.go", Treatment: "lint", ResultJSON: `{"issues":4}`})
+
+	for agg, want := range map[string]float64{"avg($.score)": 4.5, "SUM($.score)": 9, "min( $.score )": 2, "max($.score)": 7, "count($.score)": 2} {
+		rows, err := s.Aggregate(ctx, "", agg)
+		if err != nil {
+			t.Fatalf("Aggregate(%s): %v", agg, err)
+		}
+		if len(rows) != 1 || rows[0] != (ledger.AggregateRow{Treatment: "review", Value: want, Count: 2}) {
+			t.Errorf("Aggregate(%s) = %+v, want review %v over 2 rows", agg, rows, want)
+		}
+	}
+	if rows, err := s.Aggregate(ctx, "lint", "sum($.issues)"); err != nil || len(rows) != 1 || rows[0].Value != 4 {
+		t.Fatalf("Aggregate(lint) = %+v, %v", rows, err)
+	}
+	for _, bad := range []string{"median($.score)", "avg(score)", "avg($.score) + 1"} {
+		if _, err := s.Aggregate(ctx, "", bad); !errors.Is(err, ledger.ErrInvalidQuery) {
+			t.Errorf("Aggregate(%q) err = %v, want ErrInvalidQuery", bad, err)
+		}
+	}
+}
+
+func testAudit(t *testing.T, s ledger.Store) {
+	actor := ledger.Actor{User: "ci", Host: "build-1", PID: 42, Cmdline: "next reset --treatment=lint"}
+	ctx := ledger.WithActor(context.Background(), actor)
+	items := []ledger.Item{{Path: "/src/a.go", ContentHash: "h1"}, {Path: "/src/b.go", ContentHash: "h2"}}
+	if _, err := s.Enqueue(ctx, "lint", items); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if n, err := s.Reopen(ctx, "lint", items); err != nil || n != 0 {
+		t.Fatalf("Reopen unchanged = %d, %v", n, err)
+	}
+	if got, err := s.Claim(ctx, ledger.ClaimQuery{Treatment: "other", N: 1}); err != nil || len(got) != 0 {
+		t.Fatalf("Claim empty = %+v, %v", got, err)
+	}
+	if _, err := s.Reset(ctx, "lint"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	for range 2 {
+		if _, err := s.Enqueue(ctx, "lint", items[:1]); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+
+	all, err := s.AuditLog(ctx, ledger.AuditQuery{})
+	if err != nil || len(all) != 4 {
+		t.Fatalf("AuditLog = %+v, %v; want enqueue, reset, enqueue, enqueue and no no-op entries", all, err)
+	}
+	var rows []string
+	for i, e := range all {
+		if i > 0 && e.ID <= all[i-1].ID {
+			t.Fatalf("AuditLog = %+v; want ids ascending", all)
+		}
+		if e.Actor != actor || e.Treatment != "lint" {
+			t.Fatalf("entry %+v not attributed to the context's actor", e)
+		}
+		if _, err := time.Parse(time.RFC3339, e.At); err != nil {
+			t.Fatalf("entry time %q: %v", e.At, err)
+		}
+		rows = append(rows, fmt.Sprintf("%s:%d", e.Command, e.Rows))
+	}
+	// The repeated enqueue inserted nothing, so it records no rows.
+	if want := []string{"enqueue:2", "reset:2", "enqueue:1", "enqueue:0"}; !equal(rows, want) {
+		t.Fatalf("AuditLog = %v, want %v", rows, want)
+	}
+	for _, tt := range []struct {
+		q    ledger.AuditQuery
+		want int
+	}{
+		{ledger.AuditQuery{Command: "reset"}, 1},
+		{ledger.AuditQuery{Since: all[0].At}, 4},
+		{ledger.AuditQuery{Since: "9999-01-01T00:00:00Z"}, 0},
+		{ledger.AuditQuery{Command: "enqueue", Limit: 1}, 1},
+	} {
+		got, err := s.AuditLog(ctx, tt.q)
+		if err != nil || len(got) != tt.want {
+			t.Errorf("AuditLog(%+v) = %+v, %v; want %d entries", tt.q, got, err, tt.want)
+		}
+	}
+	if got, _ := s.AuditLog(ctx, ledger.AuditQuery{Command: "enqueue", Limit: 1}); len(got) == 1 && got[0].ID != all[3].ID {
+		t.Errorf("AuditLog(limit 1) = %+v, want the newest enqueue", got)
+	}
+}
+
+func testWorkers(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	paths := byHash("/src/a.go", "/src/b.go", "/src/c.go")
+	enqueue(t, s, "lint", paths...)
+	claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 2, Lease: time.Hour, Worker: "w1"})
+	complete(t, s, ledger.CompleteOptions{Path: paths[0], Treatment: "lint"})
+	claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 1, Worker: "w2"})
+	fail(t, s, ledger.FailOptions{Path: paths[2], Treatment: "lint", Error: "boom", Revisit: "-1 seconds"})
+	claim(t, s, ledger.ClaimQuery{Treatment: "lint", N: 1, Worker: "w2"})
+
+	got, err := s.Workers(ctx, ledger.WorkersQuery{StaleBefore: "9999-01-01T00:00:00Z"})
+	if err != nil || len(got) != 2 {
+		t.Fatalf("Workers = %+v, %v; want w1 and w2", got, err)
+	}
+	w1, w2 := got[0], got[1]
+	if w1.Worker != "w1" || w1.Active != 1 || w1.Stale != 0 || w1.Done != 1 || w1.Recent != 1 || w1.LastSeen == "" {
+		t.Errorf("w1 = %+v; want one live lease and one done run", w1)
+	}
+	if w2.Worker != "w2" || w2.Active != 1 || w2.Stale != 1 || w2.Failed != 1 || w2.Done != 0 {
+		t.Errorf("w2 = %+v; want one stale unleased claim and one failed run", w2)
+	}
+	if runs, err := s.History(ctx, "lint", paths[0]); err != nil || len(runs) != 1 || runs[0].Worker != "w1" {
+		t.Errorf("History = %+v, %v; want the run attributed to w1", runs, err)
+	}
+	if got, err := s.Workers(ctx, ledger.WorkersQuery{Treatment: "review"}); err != nil || len(got) != 0 {
+		t.Errorf("Workers(review) = %+v, %v; want none", got, err)
+	}
+}
+
+func testRateLimit(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	enqueue(t, s, "llm", "/src/a.go", "/src/b.go", "/src/c.go", "/src/d.go")
+	q := ledger.ClaimQuery{Treatment: "llm", N: 3, Lease: time.Hour, Rate: 1.0 / 3600, Burst: 2}
+
+	if got := claim(t, s, q); len(got) != 2 {
+		t.Fatalf("first claim = %v, want the burst of 2", got)
+	}
+	q.N = 1
+	if got := claim(t, s, q); len(got) != 0 {
+		t.Fatalf("claim with an empty bucket = %v, want none", got)
+	}
+	at, ok, err := s.NextClaimable(ctx, q)
+	if err != nil || !ok || time.Until(at) < 50*time.Minute {
+		t.Fatalf("NextClaimable = %v, %v, %v; want the refill about an hour out", at, ok, err)
+	}
+	if got := claim(t, s, ledger.ClaimQuery{Treatment: "llm", N: 1}); len(got) != 1 {
+		t.Fatalf("claim without a rate = %v, want one path", got)
+	}
+}
+
+func testMaxLeasesWithRate(t *testing.T, s ledger.Store) {
+	paths := byHash("/src/a.go", "/src/b.go", "/src/c.go", "/src/d.go", "/src/e.go")
+	enqueue(t, s, "heavy", paths...)
+	q := ledger.ClaimQuery{Treatment: "heavy", N: 5, Lease: time.Hour, MaxLeases: 2, Rate: 1, Burst: 10}
+
+	if got := claim(t, s, q); !equal(got, paths[:2]) {
+		t.Fatalf("claim = %v, want the cap of 2 despite 10 tokens", got)
+	}
+	q.MaxLeases = 3
+	q.Rate, q.Burst = 1.0/3600, 1
+	if got := claim(t, s, q); !equal(got, paths[2:3]) {
+		t.Fatalf("claim = %v, want one path for the cap and one token for the rate", got)
+	}
+}
+
+func testMaxLeases(t *testing.T, s ledger.Store) {
+	paths := byHash("/src/a.go", "/src/b.go", "/src/c.go", "/src/d.go")
+	enqueue(t, s, "heavy", paths...)
+	q := ledger.ClaimQuery{Treatment: "heavy", N: 3, Lease: time.Hour, MaxLeases: 2}
+
+	if got := claim(t, s, q); !equal(got, paths[:2]) {
+		t.Fatalf("first claim = %v, want %v", got, paths[:2])
+	}
+	if got := claim(t, s, q); len(got) != 0 {
+		t.Fatalf("claim at the cap = %v, want none", got)
+	}
+	complete(t, s, ledger.CompleteOptions{Path: paths[0], Treatment: "heavy"})
+	if got := claim(t, s, q); !equal(got, paths[2:3]) {
+		t.Fatalf("claim after a done = %v, want %v", got, paths[2:3])
+	}
+	rows, err := s.Stats(context.Background(), "heavy")
+	if err != nil || len(rows) != 1 || rows[0].Running != 2 {
+		t.Fatalf("Stats = %+v, %v; want 2 running", rows, err)
+	}
+}
+
+func testBudget(t *testing.T, s ledger.Store) {
+	ctx := context.Background()
+	paths := byHash("/src/a.go", "/src/b.go", "/src/c.go")
+	enqueue(t, s, "llm", paths...)
+	budget := &ledger.BudgetQuery{Cost: 0.75}
+	claim(t, s, ledger.ClaimQuery{Treatment: "llm", N: 2, Budget: budget})
+	complete(t, s, ledger.CompleteOptions{Path: paths[0], Treatment: "llm", Cost: 0.25, Tokens: 100})
+	fail(t, s, ledger.FailOptions{Path: paths[1], Treatment: "llm", Cost: 0.5, Tokens: 50})
+
+	runs, err := s.History(ctx, "llm", paths[0])
+	if err != nil || len(runs) != 1 || runs[0].Cost != 0.25 || runs[0].Tokens != 100 {
+		t.Fatalf("History = %+v, %v; want the run's spend", runs, err)
+	}
+	costs, err := s.Costs(ctx, "")
+	today := time.Now().UTC().Format(time.DateOnly)
+	want := ledger.CostRow{Treatment: "llm", Day: today, Runs: 2, Cost: 0.75, Tokens: 150}
+	if err != nil || len(costs) != 1 || costs[0] != want {
+		t.Fatalf("Costs = %+v, %v; want [%+v]", costs, err, want)
+	}
+	if costs, err := s.Costs(ctx, "lint"); err != nil || len(costs) != 0 {
+		t.Fatalf("Costs(lint) = %+v, %v; want none", costs, err)
+	}
+	if n, err := s.Complete(ctx, ledger.CompleteOptions{Path: "/src/gone.go", Treatment: "llm", Cost: 0.1}); !errors.Is(err, ledger.ErrInvalidSpend) || n != 0 {
+		t.Fatalf("Complete with spend on no row = %d, %v; want ErrInvalidSpend", n, err)
+	}
+	if n, err := s.Fail(ctx, ledger.FailOptions{Path: "/src/gone.go", Treatment: "llm", Tokens: 10}); !errors.Is(err, ledger.ErrInvalidSpend) || n != 0 {
+		t.Fatalf("Fail with spend on no row = %d, %v; want ErrInvalidSpend", n, err)
+	}
+
+	if _, err := s.Claim(ctx, ledger.ClaimQuery{Treatment: "llm", Budget: budget}); !errors.Is(err, ledger.ErrBudgetExhausted) {
+		t.Fatalf("claim over the cost budget err = %v, want ErrBudgetExhausted", err)
+	}
+	tokens := &ledger.BudgetQuery{Tokens: 150}
+	if _, err := s.Claim(ctx, ledger.ClaimQuery{Treatment: "llm", Budget: tokens}); !errors.Is(err, ledger.ErrBudgetExhausted) {
+		t.Fatalf("claim over the token budget err = %v, want ErrBudgetExhausted", err)
+	}
+	later := &ledger.BudgetQuery{Cost: 0.75, Since: "9999-01-01T00:00:00Z"}
+	if got := claim(t, s, ledger.ClaimQuery{Treatment: "llm", N: 1, Budget: later}); !equal(got, paths[2:]) {
+		t.Fatalf("claim with the spend outside the window = %v, want %v", got, paths[2:])
+	}
+}
@@ -0,0 +1,256 @@
+package ledger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// treatmentsFileName lives next to the ledger file, so the CLI's default is
+// .quality/treatments.json.
+const treatmentsFileName = "treatments.json"
+
+// modifierPattern accepts the SQLite datetime modifiers used for revisit and
+// retry backoff, e.g. "14 days" or "+1 hour".
+var modifierPattern = regexp.MustCompile(`^[+-]?\d+(\.\d+)? (second|minute|hour|day|month|year)s?$`)
+
+// treatmentsFile is the on-disk format:
+//
+//	{"treatments": {"lint": {"command": "golangci-lint run", "include": ["**/*.go"]}}}
+type treatmentsFile struct {
+	Treatments map[string]TreatmentDef `json:"treatments"`
+}
+
+// treatmentConfig holds the parsed treatments file. Without a file defs is
+// nil and every treatment name is accepted, as before definitions existed.
+type treatmentConfig struct {
+	path string
+	root string // include/exclude globs are relative to this directory
+	defs map[string]TreatmentDef
+}
+
+func treatmentsPath(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), treatmentsFileName)
+}
+
+// loadTreatments reads and validates the treatments file at p. An empty p or
+// a missing file is not an error.
+func loadTreatments(p string) (treatmentConfig, error) {
+	cfg := treatmentConfig{path: p}
+	if p == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(p) // #nosec G304 -- the file sits next to the user's ledger
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var f treatmentsFile
+	if err := dec.Decode(&f); err != nil {
+		return cfg, fmt.Errorf("%s: %w", p, err)
+	}
+	after := make(map[string][]string, len(f.Treatments))
+	for name, def := range f.Treatments {
+		if err := validateTreatment(name, def); err != nil {
+			return cfg, fmt.Errorf("%s: treatment %q: %w", p, name, err)
+		}
+		after[name] = def.After
+		for _, next := range def.OnDone {
+			if _, ok := f.Treatments[next]; !ok {
+				return cfg, fmt.Errorf("%s: treatment %q: on_done %q: not defined", p, name, next)
+			}
+		}
+	}
+	if _, err := TreatmentOrder(after); err != nil {
+		return cfg, fmt.Errorf("%s: %w", p, err)
+	}
+	root, err := filepath.Abs(filepath.Dir(filepath.Dir(p)))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.root = root
+	cfg.defs = f.Treatments
+	if cfg.defs == nil {
+		cfg.defs = map[string]TreatmentDef{}
+	}
+	return cfg, nil
+}
+
+func validateTreatment(name string, def TreatmentDef) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("empty name")
+	}
+	if def.Revisit != "" && !modifierPattern.MatchString(def.Revisit) {
+		return fmt.Errorf("revisit %q: want a modifier like \"14 days\"", def.Revisit)
+	}
+	if def.Timeout != "" {
+		if d, err := time.ParseDuration(def.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("timeout %q: want a positive duration like \"10m\"", def.Timeout)
+		}
+	}
+	if def.Concurrency < 0 {
+		return fmt.Errorf("concurrency %d: must not be negative", def.Concurrency)
+	}
+	if r := def.RateLimit; r != nil {
+		if r.Requests < 1 {
+			return fmt.Errorf("rate_limit.requests %d: must be at least 1", r.Requests)
+		}
+		if d, err := time.ParseDuration(r.Per); err != nil || d <= 0 {
+			return fmt.Errorf("rate_limit.per %q: want a positive duration like \"1m\"", r.Per)
+		}
+		if r.Burst < 0 {
+			return fmt.Errorf("rate_limit.burst %d: must not be negative", r.Burst)
+		}
+	}
+	if b := def.Budget; b != nil {
+		if b.Cost < 0 || b.Tokens < 0 || b.Cost == 0 && b.Tokens == 0 {
+			return fmt.Errorf("budget: want a positive cost or tokens")
+		}
+		if d, err := time.ParseDuration(b.Per); b.Per != "" && (err != nil || d <= 0) {
+			return fmt.Errorf("budget.per %q: want a positive duration like \"24h\"", b.Per)
+		}
+	}
+	if r := def.Retry; r != nil {
+		if r.MaxAttempts < 1 {
+			return fmt.Errorf("retry.max_attempts %d: must be at least 1", r.MaxAttempts)
+		}
+		if !modifierPattern.MatchString(r.Backoff) {
+			return fmt.Errorf("retry.backoff %q: want a modifier like \"10 minutes\"", r.Backoff)
+		}
+	}
+	for _, g := range append(append([]string(nil), def.Include...), def.Exclude...) {
+		if _, err := path.Match(strings.ReplaceAll(g, "**", "*"), ""); err != nil {
+			return fmt.Errorf("glob %q: %w", g, err)
+		}
+	}
+	return nil
+}
+
+// defined reports whether a treatments file was loaded.
+func (c treatmentConfig) defined() bool { return c.defs != nil }
+
+// lookup returns the definition for name. Without a treatments file every
+// name is accepted with an empty definition.
+func (c treatmentConfig) lookup(name string) (TreatmentDef, error) {
+	if !c.defined() {
+		return TreatmentDef{}, nil
+	}
+	def, ok := c.defs[name]
+	if !ok {
+		return def, fmt.Errorf("%w %q (defined in %s: %s)", ErrUnknownTreatment, name, c.path, strings.Join(c.names(), ", "))
+	}
+	return def, nil
+}
+
+func (c treatmentConfig) names() []string {
+	names := make([]string, 0, len(c.defs))
+	for n := range c.defs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// filter returns the items whose paths def selects.
+func (c treatmentConfig) filter(def TreatmentDef, items []Item) []Item {
+	kept := items[:0:0]
+	for _, it := range items {
+		if c.selects(def, it.Path) {
+			kept = append(kept, it)
+		}
+	}
+	return kept
+}
+
+// selects reports whether def's include/exclude globs admit the absolute
+// path p. Paths outside the project root are matched as absolute paths.
+func (c treatmentConfig) selects(def TreatmentDef, p string) bool {
+	rel, err := filepath.Rel(c.root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel = p
+	}
+	rel = filepath.ToSlash(rel)
+
+	included := len(def.Include) == 0
+	for _, g := range def.Include {
+		if matchGlob(g, rel) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, g := range def.Exclude {
+		if matchGlob(g, rel) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchGlob matches a slash-separated name against pattern, where "**"
+// spans any number of directories. Like .gitignore, a pattern without a
+// slash matches the base name at any depth.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(strings.TrimPrefix(name, "/"), "/"))
+}
+
+func matchSegments(pat, parts []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pat[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], parts[0]); !ok {
+			return false
+		}
+		pat, parts = pat[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// treatmentList merges definitions with queue counts. Treatments with rows
+// but no definition are included with Defined false so typos stand out.
+func (c treatmentConfig) treatmentList(status []Stats) []Treatment {
+	stats := make(map[string]Stats, len(status))
+	for _, r := range status {
+		stats[r.Treatment] = r
+	}
+	out := make([]Treatment, 0, len(c.defs)+len(status))
+	for _, name := range c.names() {
+		row := stats[name]
+		row.Treatment = name
+		out = append(out, Treatment{Name: name, Defined: true, TreatmentDef: c.defs[name], Stats: row})
+		delete(stats, name)
+	}
+	for _, r := range status {
+		if _, ok := stats[r.Treatment]; ok {
+			out = append(out, Treatment{Name: r.Treatment, Stats: r})
+		}
+	}
+	return out
+}
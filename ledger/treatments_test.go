@@ -0,0 +1,67 @@
+package ledger
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatchGlob_HandlesDoubleStarAndBaseNames(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/next/main.go", true},
+		{"**/*.go", "main.go", true},
+		{"src/**/*.go", "src/a/b/c.go", true},
+		{"src/**/*.go", "lib/a.go", false},
+		{"vendor/**", "vendor/x/y.go", true},
+		{"*_test.go", "pkg/a_test.go", true},
+		{"*.go", "main.py", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadTreatments_AcceptsAnyName_When_FileMissing(t *testing.T) {
+	cfg, err := loadTreatments(filepath.Join(t.TempDir(), "treatments.json"))
+	if err != nil {
+		t.Fatalf("loadTreatments: %v", err)
+	}
+	if _, err := cfg.lookup("anything"); err != nil {
+		t.Fatalf("lookup without a file: %v", err)
+	}
+}
+
+func TestLoadTreatments_RejectsInvalidDefinitions(t *testing.T) {
+	for _, body := range []string{
+		`{"treatments": {"lint": {"revisit": "fortnightly"}}}`,
+		`{"treatments": {"lint": {"timeout": "-1m"}}}`,
+		`{"treatments": {"lint": {"retry": {"max_attempts": 0, "backoff": "1 hour"}}}}`,
+		`{"treatments": {"lint": {"rate_limit": {"requests": 0, "per": "1m"}}}}`,
+		`{"treatments": {"lint": {"rate_limit": {"requests": 60, "per": "minute"}}}}`,
+		`{"treatments": {"llm": {"budget": {"per": "24h"}}}}`,
+		`{"treatments": {"llm": {"budget": {"cost": 5, "per": "day"}}}}`,
+		`{"treatments": {"lint": {"include": ["[a-"]}}}`,
+		`{"treatments": {"lint": {"comand": "typo"}}}`,
+		`{"treatments": {"lint": {"on_done": ["missing"]}}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "ledger.db")
+		writeTreatments(t, path, body)
+		if _, err := loadTreatments(treatmentsPath(path)); err == nil {
+			t.Errorf("loadTreatments(%s) succeeded, want error", body)
+		}
+	}
+}
+
+func TestLoadTreatments_RejectsCycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.db")
+	writeTreatments(t, path, `{"treatments": {"a": {"after": ["b"]}, "b": {"after": ["a"]}}}`)
+	if _, err := loadTreatments(treatmentsPath(path)); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Fatalf("err = %v, want a cycle error", err)
+	}
+}
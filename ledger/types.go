@@ -0,0 +1,290 @@
+package ledger
+
+import "time"
+
+// The ledger's row, option and result types. They carry JSON tags because
+// `next serve` encodes them directly; the client package re-exports them
+// under its wire names, so remote callers see the same shapes.
+
+// Item is one hashed path to add to the queue. Paths are absolute on
+// the caller's machine; hashing happens there too, not in the ledger.
+type Item struct {
+	Path        string `json:"path"`
+	ContentHash string `json:"content_hash"`
+}
+
+// Claimed is a path handed to a worker.
+type Claimed struct {
+	Path        string `json:"path"`
+	PathHash    string `json:"path_hash"`
+	ContentHash string `json:"content_hash"`
+}
+
+// CompleteOptions records a result for a path; see Ledger.Complete.
+type CompleteOptions struct {
+	Path      string `json:"path"`
+	Treatment string `json:"treatment"`
+	Result    string `json:"result,omitempty"`
+	Revisit   string `json:"revisit,omitempty"`
+	// Version is recorded with the result; it defaults to the treatment's
+	// defined version.
+	Version string `json:"version,omitempty"`
+	// Then enqueues the same path and content for these treatments in the
+	// same transaction, in addition to the definition's on_done.
+	Then []string `json:"then,omitempty"`
+	// Artifact is the sha256 of the full output, stored beforehand with
+	// PutArtifact, and is linked to the run this records.
+	Artifact string `json:"artifact,omitempty"`
+	// Findings are stored with the run and replace the path's current
+	// findings; Path and Treatment on each are ignored.
+	Findings []Finding `json:"findings,omitempty"`
+	// ResultJSON is a JSON document stored alongside Result, which list
+	// filters and status aggregates can query, e.g. {"score":7}.
+	ResultJSON string `json:"result_json,omitempty"`
+	// Cost and Tokens record the run's spend, e.g. an LLM call's price and
+	// token count, for status --cost and treatment budgets.
+	Cost   float64 `json:"cost,omitempty"`
+	Tokens int64   `json:"tokens,omitempty"`
+}
+
+// FailOptions records a failure for a path; see Ledger.Fail.
+type FailOptions struct {
+	Path      string  `json:"path"`
+	Treatment string  `json:"treatment"`
+	Error     string  `json:"error,omitempty"`
+	Revisit   string  `json:"revisit,omitempty"`
+	Artifact  string  `json:"artifact,omitempty"`
+	Cost      float64 `json:"cost,omitempty"`
+	Tokens    int64   `json:"tokens,omitempty"`
+	// Retry, used when Revisit is empty, reschedules the row after
+	// Retry.Backoff while its attempts, counting this failure, stay below
+	// Retry.MaxAttempts. The Ledger sets it from the treatment definition.
+	Retry *RetryPolicy `json:"-"`
+}
+
+// Stats aggregates one treatment. Pending counts every row not yet done;
+// Leased and Failed are subsets of it. Running counts live leases, including
+// those on done rows claimed again for a revisit, and MaxRunning is the
+// treatment's concurrency cap, zero when it has none.
+type Stats struct {
+	Treatment  string `json:"treatment"`
+	Pending    int    `json:"pending"`
+	Leased     int    `json:"leased"`
+	Failed     int    `json:"failed"`
+	Done       int    `json:"done"`
+	Due        int    `json:"due"`
+	Running    int    `json:"running"`
+	MaxRunning int    `json:"max_running,omitempty"`
+}
+
+// VersionCount counts done results per treatment version. Current is false
+// for results recorded under a version other than the treatment's current
+// one; those are claimable again.
+type VersionCount struct {
+	Treatment string `json:"treatment"`
+	Version   string `json:"version"`
+	Done      int    `json:"done"`
+	Current   bool   `json:"current"`
+}
+
+// ListOptions filters list results. State is one of pending, leased, failed,
+// done or due. Newest orders by most recent result instead of path_hash.
+// Where filters on result JSON, e.g. "$.score < 5 and $.lang = 'go'".
+type ListOptions struct {
+	Treatment string
+	State     string
+	Limit     int
+	Newest    bool
+	Where     string
+}
+
+// Entry is one queue row.
+type Entry struct {
+	Path        string `json:"path"`
+	PathHash    string `json:"path_hash"`
+	ContentHash string `json:"content_hash"`
+	Treatment   string `json:"treatment"`
+	State       string `json:"state"`
+	DoneAt      string `json:"done_at,omitempty"`
+	Result      string `json:"result,omitempty"`
+	NextAt      string `json:"next_at,omitempty"`
+	LeasedUntil string `json:"leased_until,omitempty"`
+	FailedAt    string `json:"failed_at,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Attempts    int    `json:"attempts"`
+	Version     string `json:"version,omitempty"`
+	ResultJSON  string `json:"result_json,omitempty"`
+}
+
+// Run is one recorded done or fail for a path, kept after the queue row has
+// moved on. State is "done" or "failed"; At is RFC 3339.
+type Run struct {
+	ID          int64   `json:"id"`
+	Path        string  `json:"path"`
+	Treatment   string  `json:"treatment"`
+	ContentHash string  `json:"content_hash"`
+	State       string  `json:"state"`
+	Result      string  `json:"result,omitempty"`
+	Error       string  `json:"error,omitempty"`
+	Artifact    string  `json:"artifact,omitempty"`
+	Version     string  `json:"version,omitempty"`
+	Worker      string  `json:"worker,omitempty"`
+	Cost        float64 `json:"cost,omitempty"`
+	Tokens      int64   `json:"tokens,omitempty"`
+	At          string  `json:"at"`
+}
+
+// Finding is one checker result for a path, such as a SARIF result. Lines
+// are 1-based; zero means unknown. Run is the run that recorded it.
+// Fingerprint identifies the finding across runs independently of its
+// lines; the ledger derives one from the rule and message when it is empty.
+type Finding struct {
+	Run         int64  `json:"run,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Treatment   string `json:"treatment,omitempty"`
+	Rule        string `json:"rule"`
+	Level       string `json:"level,omitempty"`
+	StartLine   int    `json:"start_line,omitempty"`
+	EndLine     int    `json:"end_line,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// FindingsQuery filters the current findings: those recorded by each
+// path's latest done run. Empty fields match everything. Previous selects
+// each path's done run before the latest instead.
+type FindingsQuery struct {
+	Treatment string
+	Rule      string
+	Path      string
+	Previous  bool
+}
+
+// AggregateRow is one treatment's aggregate over the numeric values at a
+// result JSON path; Count is how many done rows had one. Treatments without
+// any are not reported.
+type AggregateRow struct {
+	Treatment string  `json:"treatment"`
+	Value     float64 `json:"value"`
+	Count     int     `json:"count"`
+}
+
+// Actor identifies the process behind a change: its OS user, host, pid
+// and command line. Remote callers send theirs in X-Next-* headers so the
+// server can audit on their behalf.
+type Actor struct {
+	User    string `json:"user"`
+	Host    string `json:"host"`
+	PID     int    `json:"pid"`
+	Cmdline string `json:"cmdline"`
+}
+
+// AuditEntry is one recorded mutation. Command is the operation, such as
+// "done" or "reset"; Rows is how many queue rows it affected and At is
+// RFC 3339.
+type AuditEntry struct {
+	ID int64  `json:"id"`
+	At string `json:"at"`
+	Actor
+	Command   string `json:"command"`
+	Treatment string `json:"treatment,omitempty"`
+	Rows      int64  `json:"rows"`
+}
+
+// AuditQuery filters the audit log. Since is RFC 3339; Limit keeps only
+// the newest entries. Empty fields match everything.
+type AuditQuery struct {
+	Since   string
+	Command string
+	Limit   int
+}
+
+// WorkerStats summarizes one worker id's claims and runs. Active counts
+// claims not yet done or failed and Stale those among them whose lease
+// expired or, without a lease, that are older than the stale threshold.
+// Recent counts done runs within the window; LastSeen is RFC 3339.
+type WorkerStats struct {
+	Worker   string `json:"worker"`
+	Active   int    `json:"active"`
+	Stale    int    `json:"stale"`
+	Done     int    `json:"done"`
+	Failed   int    `json:"failed"`
+	Recent   int    `json:"recent"`
+	LastSeen string `json:"last_seen"`
+}
+
+// TreatmentDef is one treatment's entry in .quality/treatments.json. Revisit
+// and Retry.Backoff are SQLite datetime modifiers such as "14 days"; Timeout
+// is a Go duration used as the default claim lease.
+type TreatmentDef struct {
+	// Version names the current revision of the treatment (a rule set or
+	// prompt); bumping it makes results from other versions claimable.
+	Version string `json:"version,omitempty"`
+	// After lists upstream treatments that must be done for a path's
+	// current content before this treatment can claim it.
+	After []string `json:"after,omitempty"`
+	// OnDone lists treatments the path is enqueued for when this one
+	// completes, as with done --then.
+	OnDone      []string     `json:"on_done,omitempty"`
+	Command     string       `json:"command,omitempty"`
+	Include     []string     `json:"include,omitempty"`
+	Exclude     []string     `json:"exclude,omitempty"`
+	Revisit     string       `json:"revisit,omitempty"`
+	Timeout     string       `json:"timeout,omitempty"`
+	Concurrency int          `json:"concurrency,omitempty"`
+	Retry       *RetryPolicy `json:"retry,omitempty"`
+	RateLimit   *RateLimit   `json:"rate_limit,omitempty"`
+	Budget      *Budget      `json:"budget,omitempty"`
+}
+
+// RetryPolicy reschedules failures after Backoff until MaxAttempts failures
+// have been recorded; after that a failed path stays failed.
+type RetryPolicy struct {
+	MaxAttempts int    `json:"max_attempts"`
+	Backoff     string `json:"backoff"`
+}
+
+// RateLimit caps claims of a treatment at Requests paths per Per, a Go
+// duration, across every worker sharing the ledger. Burst is how many may be
+// claimed at once after an idle spell; zero means Requests.
+type RateLimit struct {
+	Requests int    `json:"requests"`
+	Per      string `json:"per"`
+	Burst    int    `json:"burst,omitempty"`
+}
+
+// Budget stops claims of a treatment once the cost or tokens recorded by
+// its runs within Per, a Go duration, reach Cost or Tokens; zero limits are
+// unchecked and an empty Per counts every run.
+type Budget struct {
+	Cost   float64 `json:"cost,omitempty"`
+	Tokens int64   `json:"tokens,omitempty"`
+	Per    string  `json:"per,omitempty"`
+}
+
+// CostRow totals the spend recorded by one treatment's runs on one UTC day,
+// formatted 2006-01-02. Runs counts those that recorded any.
+type CostRow struct {
+	Treatment string  `json:"treatment"`
+	Day       string  `json:"day"`
+	Runs      int     `json:"runs"`
+	Cost      float64 `json:"cost"`
+	Tokens    int64   `json:"tokens"`
+}
+
+// Treatment is a defined treatment with its current queue counts. Defined is
+// false for treatments that have rows in the ledger but no definition.
+type Treatment struct {
+	Name    string `json:"name"`
+	Defined bool   `json:"defined"`
+	TreatmentDef
+	Stats Stats `json:"stats"`
+}
+
+// Snapshot is a pre-operation copy of the ledger kept next to its file.
+type Snapshot struct {
+	Name    string    `json:"name"`
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+}
@@ -0,0 +1,81 @@
+package ledger
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	minPoll = 100 * time.Millisecond
+	maxPoll = 5 * time.Second
+)
+
+// claimWaiting claims, and while nothing is claimable sleeps until the next
+// lease expiry or revisit time, backing off exponentially in between. wake
+// returns a channel closed on the next change made through the Ledger, so
+// in-process waiters retry immediately instead of waiting out the backoff.
+// It returns an empty slice once wait has elapsed.
+func claimWaiting(ctx context.Context, s Store, opts ClaimQuery, wait time.Duration, wake func() <-chan struct{}) ([]Claimed, error) {
+	deadline := time.Now().Add(wait)
+	backoff := minPoll
+	for {
+		var changed <-chan struct{}
+		if wake != nil {
+			// Subscribe before claiming so a change in between is not missed.
+			changed = wake()
+		}
+		items, err := s.Claim(ctx, opts)
+		if err != nil || len(items) > 0 {
+			return items, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return items, nil
+		}
+
+		sleep := min(backoff, remaining)
+		if due, ok, err := s.NextClaimable(ctx, opts); err != nil {
+			return nil, err
+		} else if ok {
+			sleep = min(sleep, max(time.Until(due), minPoll))
+		}
+
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-changed:
+			timer.Stop()
+			backoff = minPoll
+			continue
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, maxPoll)
+	}
+}
+
+// notifier lets long-polling claims sleep until the ledger changes.
+type notifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newNotifier() *notifier {
+	return &notifier{ch: make(chan struct{})}
+}
+
+// wait returns a channel that is closed by the next broadcast.
+func (n *notifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.ch
+}
+
+func (n *notifier) broadcast() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.ch)
+	n.ch = make(chan struct{})
+}
@@ -0,0 +1,79 @@
+package ledger
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClaimWaiting_ReturnsEmpty_When_WaitElapses(t *testing.T) {
+	s := NewMemoryStore()
+
+	start := time.Now()
+	items, err := claimWaiting(context.Background(), s, ClaimQuery{Treatment: "lint", N: 1}, 200*time.Millisecond, nil)
+	if err != nil {
+		t.Fatalf("claimWaiting: %v", err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("claimed %v from an empty queue", items)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("returned after %v, before the wait elapsed", elapsed)
+	}
+}
+
+func TestClaimWaiting_ReturnsRow_When_RevisitComesDue(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	mustEnqueue(t, s, "lint", "/src/a.go")
+	if _, err := s.Complete(ctx, CompleteOptions{Path: "/src/a.go", Treatment: "lint", Revisit: "+1 seconds"}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	items, err := claimWaiting(ctx, s, ClaimQuery{Treatment: "lint", N: 1}, 5*time.Second, nil)
+	if err != nil {
+		t.Fatalf("claimWaiting: %v", err)
+	}
+	if len(items) != 1 || items[0].Path != "/src/a.go" {
+		t.Fatalf("claimed %v, want the due revisit", items)
+	}
+}
+
+func TestLedger_WakesWaitingClaim_When_Enqueued(t *testing.T) {
+	l := newTestLedger(t, "")
+	ctx := context.Background()
+
+	type result struct {
+		items []Claimed
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		items, err := l.Claim(ctx, ClaimOptions{Treatment: "lint", Wait: 10 * time.Second})
+		done <- result{items, err}
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	if _, err := l.Enqueue(ctx, "lint", []Item{{Path: "/src/a.go", ContentHash: "h1"}}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	select {
+	case r := <-done:
+		if r.err != nil || len(r.items) != 1 {
+			t.Fatalf("claim = %v, %v", r.items, r.err)
+		}
+	case <-time.After(maxPoll):
+		t.Fatal("waiting claim was not woken by enqueue")
+	}
+}
+
+func TestLedger_StopsWaiting_When_ContextCanceled(t *testing.T) {
+	l := newTestLedger(t, "")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if _, err := l.Claim(ctx, ClaimOptions{Treatment: "lint", Wait: time.Minute}); err == nil {
+		t.Fatal("claim outlived its context")
+	}
+}
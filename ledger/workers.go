@@ -0,0 +1,83 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+)
+
+// queueWorkers merges each worker's outstanding claims from queue with its
+// recorded runs.
+func queueWorkers(ctx context.Context, db *sql.DB, q WorkersQuery) ([]WorkerStats, error) {
+	byID := map[string]*WorkerStats{}
+	get := func(id string) *WorkerStats {
+		w, ok := byID[id]
+		if !ok {
+			w = &WorkerStats{Worker: id}
+			byID[id] = w
+		}
+		return w
+	}
+
+	claims, err := db.QueryContext(ctx, `
+		SELECT worker, COUNT(*),
+		       COUNT(*) FILTER (WHERE leased_until <= DATETIME('now')
+		                          OR (leased_until IS NULL AND claimed_at < ?)),
+		       MAX(claimed_at)
+		FROM queue WHERE worker IS NOT NULL AND (? = '' OR treatment = ?)
+		GROUP BY worker
+	`, q.StaleBefore, q.Treatment, q.Treatment)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = claims.Close() }()
+	for claims.Next() {
+		var id, seen string
+		var active, stale int
+		if err := claims.Scan(&id, &active, &stale, &seen); err != nil {
+			return nil, err
+		}
+		w := get(id)
+		w.Active, w.Stale, w.LastSeen = active, stale, seen
+	}
+	if err := claims.Err(); err != nil {
+		return nil, err
+	}
+
+	runs, err := db.QueryContext(ctx, `
+		SELECT worker,
+		       COUNT(*) FILTER (WHERE state = 'done'),
+		       COUNT(*) FILTER (WHERE state = 'failed'),
+		       COUNT(*) FILTER (WHERE state = 'done' AND at >= ?),
+		       MAX(at)
+		FROM runs WHERE worker IS NOT NULL AND (? = '' OR treatment = ?)
+		GROUP BY worker
+	`, q.Since, q.Treatment, q.Treatment)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = runs.Close() }()
+	for runs.Next() {
+		var id, seen string
+		var done, failed, recent int
+		if err := runs.Scan(&id, &done, &failed, &recent, &seen); err != nil {
+			return nil, err
+		}
+		w := get(id)
+		w.Done, w.Failed, w.Recent = done, failed, recent
+		w.LastSeen = max(w.LastSeen, seen)
+	}
+	if err := runs.Err(); err != nil {
+		return nil, err
+	}
+	return sortedWorkers(byID), nil
+}
+
+func sortedWorkers(byID map[string]*WorkerStats) []WorkerStats {
+	out := make([]WorkerStats, 0, len(byID))
+	for _, w := range byID {
+		out = append(out, *w)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Worker < out[j].Worker })
+	return out
+}
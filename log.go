@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dkoosis/next/client"
+)
+
+func logCmd() {
+	if err := doLogCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doLogCmd() error {
+	fs := flag.NewFlagSet("log", flag.ExitOnError)
+	since := fs.String("since", "", "only entries this recent: a duration such as 24h, or an RFC 3339 time")
+	command := fs.String("command", "", "only this operation: enqueue, reopen, claim, done, fail, reset or undo")
+	limit := fs.Int("limit", 0, "show only the newest N entries (0 = all)")
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	req := client.AuditRequest{Command: *command, Limit: *limit}
+	if *since != "" {
+		t, err := parseSince(*since, time.Now())
+		if err != nil {
+			return err
+		}
+		req.Since = t.Format(time.RFC3339)
+	}
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	entries, err := q.Audit(req)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+	for _, e := range entries {
+		fmt.Printf("%s\t%s@%s\t%d\t%s\t%s\t%d\t%s\n",
+			e.At, orDash(e.User), orDash(e.Host), e.PID, e.Command, orDash(e.Treatment), e.Rows, orDash(e.Cmdline))
+	}
+	return nil
+}
+
+// parseSince accepts a duration back from now or an RFC 3339 time.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --since %q: want a duration such as 24h or an RFC 3339 time", s)
+	}
+	return t.UTC(), nil
+}
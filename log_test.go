@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogCmd_ShowsReset_When_FilteredByCommand(t *testing.T) {
+	tmpDir, restore := setupWorkDir(t, true)
+	defer restore()
+
+	dbPath := filepath.Join(tmpDir, "ledger.db")
+	seedQueue(t, dbPath, "review", filepath.Join(tmpDir, "a.go"), filepath.Join(tmpDir, "b.go"))
+	runCmd(t, resetCmd, "reset", "--db", dbPath, "--treatment", "review", "--yes")
+	runCmd(t, undoCmd, "undo", "--db", dbPath, "--yes")
+
+	out := runCmd(t, logCmd, "log", "--db", dbPath, "--command", "reset", "--since", "1h")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("log output = %q, want one reset entry", out)
+	}
+	f := strings.Split(lines[0], "\t")
+	if len(f) != 7 || f[3] != "reset" || f[4] != "review" || f[5] != "2" || !strings.Contains(f[6], "--treatment review") {
+		t.Fatalf("reset entry = %q", lines[0])
+	}
+
+	out = runCmd(t, logCmd, "log", "--db", dbPath)
+	if got := strings.Count(out, "\n"); got != 2 {
+		t.Fatalf("full log = %q, want reset and undo entries", out)
+	}
+}
+
+func TestParseSince_AcceptsDurationOrTime(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if got, err := parseSince("24h", now); err != nil || !got.Equal(now.Add(-24*time.Hour)) {
+		t.Fatalf("parseSince(24h) = %v, %v", got, err)
+	}
+	if got, err := parseSince("2026-02-01T10:00:00+02:00", now); err != nil || got.Format(time.RFC3339) != "2026-02-01T08:00:00Z" {
+		t.Fatalf("parseSince(time) = %v, %v", got, err)
+	}
+	if _, err := parseSince("yesterday", now); err == nil {
+		t.Fatal("parseSince(yesterday) should fail")
+	}
+}
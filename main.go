@@ -2,18 +2,17 @@ package main
 
 import (
 	"bufio"
-	"crypto/sha256"
 	"database/sql"
-	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"time"
+	"strconv"
+	"strings"
 
-	_ "github.com/ncruces/go-sqlite3/driver"
-	_ "github.com/ncruces/go-sqlite3/embed"
+	"github.com/dkoosis/next/ledger"
 )
 
 const defaultDBPath = ".quality/ledger.db"
@@ -31,10 +30,44 @@ func main() {
 		claimCmd()
 	case "done":
 		doneCmd()
+	case "fail":
+		failCmd()
 	case "status":
 		statusCmd()
+	case "list":
+		listCmd()
 	case "reset":
 		resetCmd()
+	case "snapshots":
+		snapshotsCmd()
+	case "undo":
+		undoCmd()
+	case "serve":
+		serveCmd()
+	case "metrics":
+		metricsCmd()
+	case "treatments":
+		treatmentsCmd()
+	case "graph":
+		graphCmd()
+	case "run":
+		runTreatmentCmd()
+	case "watch":
+		watchCmd()
+	case "hook":
+		hookCmd()
+	case "show":
+		showCmd()
+	case "ingest":
+		ingestCmd()
+	case "findings":
+		findingsCmd()
+	case "diff":
+		diffCmd()
+	case "log":
+		logCmd()
+	case "workers":
+		workersCmd()
 	default:
 		usage()
 		os.Exit(1)
@@ -48,39 +81,70 @@ Commands:
   enqueue   Read paths from stdin, add to queue
   claim     Claim next unclaimed path(s)
   done      Mark path as complete
+  fail      Mark path as failed
   status    Show queue stats
+  list      List queue entries
   reset     Clear treatment from queue
+  snapshots List automatic pre-operation snapshots
+  undo      Restore the most recent snapshot
+  serve     Serve the ledger over HTTP/JSON, /metrics and a dashboard at /
+  metrics   Print Prometheus metrics or write a node_exporter textfile
+  treatments List treatment definitions with their queue counts
+  graph     Print the treatment dependency graph
+  run       Execute a built-in treatment, or a --persistent worker, on claimed paths
+  watch     Re-enqueue files under --root as they change (Linux)
+  hook      Install, uninstall or run a git hook gating commits on results
+  show      Print the full output stored with a path's latest run
+  ingest    Record a SARIF report's findings and mark its paths done
+  findings  List the current findings by treatment, rule or path
+  diff      Compare findings with the previous run: new, fixed, unchanged
+  log       Browse the audit log of who changed the ledger and when
+  workers   Show each worker's claims, throughput and stale leases
 
 Examples:
   find . -name '*.go' | next enqueue --treatment=lint
   next claim --treatment=lint
+  next claim --treatment=lint --lease=10m --wait=5m
   next done --path=foo.go --result=abc123
+  go vet ./... 2>&1 | next done --path=foo.go --treatment=lint --artifact-stdin
+  next show --path=foo.go --treatment=lint
+  next ingest --sarif=report.sarif --treatment=lint
+  next findings --treatment=lint --rule=unused
+  next diff --treatment=lint --path=foo.go
+  next done --path=foo.go --treatment=review --result-json='{"score":7}'
+  next list --treatment=review --where='$.score < 5'
+  next status --treatment=review --agg='avg($.score)'
+  next run --treatment=gofmt-check
+  next run --treatment=review --persistent -- python3 worker.py
+  next watch --root=. --treatment=lint
+  next hook install --treatment=lint --stage=pre-commit
+  next serve --listen=127.0.0.1:7070
+  next undo --yes
+  next log --since=24h --command=reset
+  next workers --window=15m
+  next done --path=foo.go --treatment=review --cost=0.0123 --tokens=4500
+  next status --cost
+
+Every command accepts --server=URL (or $NEXT_SERVER) to use a remote
+ledger started with "next serve" instead of the local file.
+
+When .quality/treatments.json (next to --db) exists, only the treatments it
+defines are accepted; it also sets each one's globs, revisit, timeout and
+retry policy.
+
+claim --wait blocks until a path is claimable; if a --wait=DURATION
+elapses first it prints nothing and exits 3. Once a treatment's budget of
+cost or tokens recorded by done and fail --cost/--tokens is spent, claim and
+run exit 4.
+
+Destructive commands snapshot the ledger first; NEXT_SNAPSHOT_KEEP sets
+how many snapshots are retained (default 10, 0 disables).
+
+Every change is recorded in an audit log with the OS user, host, pid and
+command line behind it; reset and undo leave the log intact.
 `)
 }
 
-// Hash utilities.
-func pathHash(s string) string {
-	h := sha256.Sum256([]byte(s))
-	return hex.EncodeToString(h[:])
-}
-
-func fileHash(path string) (string, error) {
-	f, err := os.Open(path) // #nosec G304 -- path comes from user input, which is expected
-	if err != nil {
-		return "", err
-	}
-	defer func() {
-		if closeErr := f.Close(); closeErr != nil && err == nil {
-			err = closeErr
-		}
-	}()
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(h.Sum(nil)), nil
-}
-
 func openDB(path string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", path)
 	if err != nil {
@@ -112,16 +176,33 @@ func doEnqueueCmd() error {
 	fs := flag.NewFlagSet("enqueue", flag.ExitOnError)
 	treatment := fs.String("treatment", "default", "treatment name")
 	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
 	_ = fs.Parse(os.Args[2:])
 
-	db, err := openDB(*dbPath)
+	q, err := openQueue(*dbPath, *server)
 	if err != nil {
-		return fmt.Errorf("db error: %w", err)
+		return err
 	}
-	defer func() { _ = db.Close() }()
+	defer func() { _ = q.Close() }()
 
-	scanner := bufio.NewScanner(os.Stdin)
-	count := 0
+	items, err := readEnqueueItems(os.Stdin)
+	if err != nil {
+		return err
+	}
+	count, err := q.Enqueue(*treatment, items)
+	if err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+
+	fmt.Printf("enqueued %d paths for treatment=%s\n", count, *treatment)
+	return nil
+}
+
+// readEnqueueItems reads one path per line, making each absolute and hashing
+// its contents. Unreadable paths are skipped with a warning.
+func readEnqueueItems(r io.Reader) ([]enqueueItem, error) {
+	scanner := bufio.NewScanner(r)
+	var items []enqueueItem
 	for scanner.Scan() {
 		path := scanner.Text()
 		if path == "" {
@@ -133,173 +214,377 @@ func doEnqueueCmd() error {
 			fmt.Fprintf(os.Stderr, "warning: skipping %q: %v\n", path, err)
 			continue
 		}
-		ph := pathHash(absPath)
-		ch, err := fileHash(absPath)
+		ch, err := ledger.FileHash(absPath)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "warning: skipping %q: %v\n", absPath, err)
 			continue
 		}
-		_, err = db.Exec(`
-			INSERT OR IGNORE INTO queue
-			(path, path_hash, content_hash, treatment, done_at, result, next_at)
-			VALUES (?, ?, ?, ?, NULL, NULL, NULL)
-		`, absPath, ph, ch, *treatment)
-		if err != nil {
-			return fmt.Errorf("error: failed to insert %q: %w", absPath, err)
-		}
-		count++
+		items = append(items, enqueueItem{Path: absPath, ContentHash: ch})
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading stdin: %w", err)
+		return nil, fmt.Errorf("error reading stdin: %w", err)
 	}
-
-	fmt.Printf("enqueued %d paths for treatment=%s\n", count, *treatment)
-	return nil
+	return items, nil
 }
 
 func claimCmd() {
+	if err := doClaimCmd(); errors.Is(err, errWaitTimeout) {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitWaitTimeout)
+	} else if errors.Is(err, ledger.ErrBudgetExhausted) {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitBudgetExhausted)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doClaimCmd() error {
 	fs := flag.NewFlagSet("claim", flag.ExitOnError)
 	treatment := fs.String("treatment", "default", "treatment name")
 	cursor := fs.String("cursor", "", "resume after this path_hash")
 	n := fs.Int("n", 1, "number to claim")
+	lease := fs.Duration("lease", 0, "hide claimed paths from other workers for this long (e.g., 10m)")
+	var wait waitFlag
+	fs.Var(&wait, "wait", "block until something is claimable; --wait=DURATION gives up after DURATION (exit 3)")
+	version := fs.String("version", "", "current treatment version; results from other versions are reclaimed (default: defined version)")
+	worker := workerFlag(fs, os.Getppid())
 	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
 	_ = fs.Parse(os.Args[2:])
 
-	db, err := openDB(*dbPath)
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	opts := claimOptions{Treatment: *treatment, Cursor: *cursor, N: *n, Lease: *lease, Version: *version, Worker: *worker}
+	var items []claimedItem
+	if wait.set {
+		items, err = claimUntil(q, opts, wait.timeout)
+	} else {
+		items, err = q.Claim(opts)
+	}
+	if errors.Is(err, errWaitTimeout) {
+		return err
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
+		return fmt.Errorf("query error: %w", err)
+	}
+	for _, it := range items {
+		fmt.Println(it.Path)
+	}
+	return nil
+}
+
+func doneCmd() {
+	if err := doDoneCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
-	defer func() { _ = db.Close() }()
+}
+
+func doDoneCmd() error {
+	fs := flag.NewFlagSet("done", flag.ExitOnError)
+	path := fs.String("path", "", "file path (required unless --sarif)")
+	result := fs.String("result", "", "result hash")
+	resultJSON := fs.String("result-json", "", "structured result stored as JSON, e.g. '{\"score\":7}', for list --where and status --agg")
+	revisit := fs.String("revisit", "", "revisit after duration (e.g., '14 days')")
+	version := fs.String("version", "", "treatment version that produced the result (default: defined version)")
+	then := fs.String("then", "", "comma-separated treatments to enqueue this path for next (e.g., summarize,index)")
+	treatment := fs.String("treatment", "default", "treatment name")
+	sarif := fs.String("sarif", "", "record this SARIF report's findings and mark its paths (or just --path) done")
+	artifactFile, artifactStdin := artifactFlags(fs)
+	cost, tokens := spendFlags(fs)
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	if *path == "" && *sarif == "" {
+		return fmt.Errorf("error: --path required")
+	}
+	if *path == "" && (*cost != 0 || *tokens != 0) {
+		return fmt.Errorf("error: --cost and --tokens record one run; add --path")
+	}
+
+	var absPath string
+	if *path != "" {
+		var err error
+		if absPath, err = filepath.Abs(*path); err != nil {
+			return fmt.Errorf("path error: %w", err)
+		}
+	}
+	var report sarifReport
+	if *sarif != "" {
+		var err error
+		if report, err = readSARIF(*sarif, "."); err != nil {
+			return fmt.Errorf("sarif error: %w", err)
+		}
+		if absPath != "" {
+			report = sarifReport{absPath: report[absPath]}
+		}
+	}
 
-	rows, err := db.Query(`
-		SELECT path, path_hash FROM queue
-		WHERE treatment=? AND done_at IS NULL AND path_hash > ?
-		ORDER BY path_hash
-		LIMIT ?
-	`, *treatment, *cursor, *n)
+	q, err := openQueue(*dbPath, *server)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "query error: %v\n", err)
-		return
+		return err
 	}
-	defer func() { _ = rows.Close() }()
+	defer func() { _ = q.Close() }()
 
-	for rows.Next() {
-		var path, hash string
-		if err := rows.Scan(&path, &hash); err != nil {
-			continue
+	artifact, err := storeArtifact(q, *artifactFile, *artifactStdin)
+	if err != nil {
+		return err
+	}
+	opts := doneOptions{
+		Path: absPath, Treatment: *treatment, Result: *result, Revisit: *revisit, Version: *version,
+		Then: splitList(*then), Artifact: artifact, ResultJSON: *resultJSON, Cost: *cost, Tokens: *tokens,
+	}
+	if report != nil {
+		done, findings, skipped, err := ingestSARIF(q, report, opts)
+		if err != nil {
+			return err
 		}
-		fmt.Println(path)
+		printIngest(*treatment, done, findings, skipped)
+		return nil
+	}
+	if _, err := q.Done(opts); err != nil {
+		return fmt.Errorf("update error: %w", err)
 	}
+	return nil
+}
 
-	if err := rows.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "rows error: %v\n", err)
+// splitList parses a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
 	}
+	return out
 }
 
-func doneCmd() {
-	fs := flag.NewFlagSet("done", flag.ExitOnError)
+func failCmd() {
+	if err := doFailCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doFailCmd() error {
+	fs := flag.NewFlagSet("fail", flag.ExitOnError)
 	path := fs.String("path", "", "file path (required)")
-	result := fs.String("result", "", "result hash")
-	revisit := fs.String("revisit", "", "revisit after duration (e.g., '14 days')")
+	msg := fs.String("error", "", "error message")
+	revisit := fs.String("revisit", "", "retry after duration (e.g., '1 hour'); empty = stay failed")
 	treatment := fs.String("treatment", "default", "treatment name")
+	artifactFile, artifactStdin := artifactFlags(fs)
+	cost, tokens := spendFlags(fs)
 	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
 	_ = fs.Parse(os.Args[2:])
 
 	if *path == "" {
-		fmt.Fprintf(os.Stderr, "error: --path required\n")
-		os.Exit(1)
+		return fmt.Errorf("error: --path required")
 	}
 
 	absPath, err := filepath.Abs(*path)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "path error: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("path error: %w", err)
 	}
 
-	db, err := openDB(*dbPath)
+	q, err := openQueue(*dbPath, *server)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		return err
 	}
-	defer func() { _ = db.Close() }()
+	defer func() { _ = q.Close() }()
 
-	now := time.Now().UTC().Format(time.RFC3339)
-	var nextAt *string
-	if *revisit != "" {
-		// SQLite datetime modifier
-		nextAt = revisit
+	artifact, err := storeArtifact(q, *artifactFile, *artifactStdin)
+	if err != nil {
+		return err
 	}
-
-	_, err = db.Exec(`
-		UPDATE queue
-		SET done_at=?, result=?, next_at=DATETIME('now', ?)
-		WHERE path=? AND treatment=?
-	`, now, *result, nextAt, absPath, *treatment)
+	n, err := q.Fail(failOptions{
+		Path: absPath, Treatment: *treatment, Error: *msg, Revisit: *revisit, Artifact: artifact,
+		Cost: *cost, Tokens: *tokens,
+	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "update error: %v\n", err)
+		return fmt.Errorf("update error: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("error: %s is not pending or claimed for treatment %q", absPath, *treatment)
 	}
+	return nil
 }
 
 func statusCmd() {
+	if err := doStatusCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doStatusCmd() error {
 	fs := flag.NewFlagSet("status", flag.ExitOnError)
 	treatment := fs.String("treatment", "", "filter by treatment (empty = all)")
+	agg := fs.String("agg", "", "aggregate over done results' JSON: avg|sum|min|max|count($.path)")
+	cost := fs.Bool("cost", false, "show the cost and tokens recorded by runs per treatment and day")
 	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
 	_ = fs.Parse(os.Args[2:])
 
-	db, err := openDB(*dbPath)
+	q, err := openQueue(*dbPath, *server)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	rows, err := q.Status(*treatment)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
 	}
-	defer func() { _ = db.Close() }()
 
-	query := `
-		SELECT treatment, 
-		       COUNT(*) FILTER (WHERE done_at IS NULL) as pending,
-		       COUNT(*) FILTER (WHERE done_at IS NOT NULL) as done
-		FROM queue
-	`
-	args := []interface{}{}
-	if *treatment != "" {
-		query += " WHERE treatment=?"
-		args = append(args, *treatment)
+	fmt.Printf("%-20s %10s %10s\n", "TREATMENT", "PENDING", "DONE")
+	for _, r := range rows {
+		fmt.Printf("%-20s %10d %10d\n", r.Treatment, r.Pending, r.Done)
+	}
+	printConcurrency(rows)
+
+	versions, err := q.Versions(*treatment)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+	printVersions(versions)
+
+	if *cost {
+		costs, err := q.Costs(*treatment)
+		if err != nil {
+			return fmt.Errorf("query error: %w", err)
+		}
+		printCosts(costs)
 	}
-	query += " GROUP BY treatment"
 
-	rows, err := db.Query(query, args...)
+	if *agg == "" {
+		return nil
+	}
+	aggs, err := q.Aggregate(*treatment, *agg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "query error: %v\n", err)
+		return fmt.Errorf("query error: %w", err)
+	}
+	fmt.Printf("\n%-20s %14s %10s\n", "TREATMENT", *agg, "ROWS")
+	for _, r := range aggs {
+		fmt.Printf("%-20s %14s %10d\n", r.Treatment, strconv.FormatFloat(r.Value, 'g', 6, 64), r.Count)
+	}
+	return nil
+}
+
+// printConcurrency adds live leases against each capped treatment's
+// concurrency, skipped while no treatment has a cap.
+func printConcurrency(rows []statusRow) {
+	capped := false
+	for _, r := range rows {
+		capped = capped || r.MaxRunning > 0
+	}
+	if !capped {
 		return
 	}
-	defer func() { _ = rows.Close() }()
+	fmt.Printf("\n%-20s %10s %10s\n", "TREATMENT", "RUNNING", "MAX")
+	for _, r := range rows {
+		limit := "-"
+		if r.MaxRunning > 0 {
+			limit = strconv.Itoa(r.MaxRunning)
+		}
+		fmt.Printf("%-20s %10d %10s\n", r.Treatment, r.Running, limit)
+	}
+}
 
-	fmt.Printf("%-20s %10s %10s\n", "TREATMENT", "PENDING", "DONE")
-	for rows.Next() {
-		var t string
-		var pending, done int
-		if err := rows.Scan(&t, &pending, &done); err != nil {
-			continue
+// printVersions adds a per-version breakdown of done results, skipped while
+// no result has been recorded with a version.
+func printVersions(rows []versionRow) {
+	versioned := false
+	for _, r := range rows {
+		versioned = versioned || r.Version != ""
+	}
+	if !versioned {
+		return
+	}
+	fmt.Printf("\n%-20s %-20s %10s\n", "TREATMENT", "VERSION", "DONE")
+	for _, r := range rows {
+		v := r.Version
+		if v == "" {
+			v = "(none)"
+		}
+		if !r.Current {
+			v += " (stale)"
 		}
-		fmt.Printf("%-20s %10d %10d\n", t, pending, done)
+		fmt.Printf("%-20s %-20s %10d\n", r.Treatment, v, r.Done)
+	}
+}
+
+func listCmd() {
+	if err := doListCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doListCmd() error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	treatment := fs.String("treatment", "", "filter by treatment (empty = all)")
+	state := fs.String("state", "", "filter by state: pending|leased|failed|done|due")
+	limit := fs.Int("limit", 0, "maximum entries (0 = all)")
+	where := fs.String("where", "", "filter on result JSON, e.g. '$.score < 5 and $.lang = \"go\"'")
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
 	}
+	defer func() { _ = q.Close() }()
 
-	if err := rows.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "rows error: %v\n", err)
+	entries, err := q.List(listOptions{Treatment: *treatment, State: *state, Limit: *limit, Where: *where})
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+	printEntries(entries)
+	return nil
+}
+
+// printEntries writes one tab-separated line per entry: state, treatment,
+// path, the result or error, and the result JSON when there is one.
+func printEntries(entries []entry) {
+	for _, e := range entries {
+		detail := e.Result
+		if e.State == "failed" {
+			detail = e.Error
+		}
+		if e.ResultJSON != "" {
+			detail += "\t" + e.ResultJSON
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", e.State, e.Treatment, e.Path, detail)
 	}
 }
 
 func resetCmd() {
+	if err := doResetCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doResetCmd() error {
 	fs := flag.NewFlagSet("reset", flag.ExitOnError)
 	treatment := fs.String("treatment", "", "treatment to reset (required)")
 	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
 	confirm := fs.Bool("yes", false, "skip confirmation")
 	_ = fs.Parse(os.Args[2:])
 
 	if *treatment == "" {
-		fmt.Fprintf(os.Stderr, "error: --treatment required\n")
-		os.Exit(1)
+		return fmt.Errorf("error: --treatment required")
 	}
 
 	if !*confirm {
@@ -308,22 +593,20 @@ func resetCmd() {
 		_, _ = fmt.Scanln(&response)
 		if response != "y" && response != "Y" {
 			fmt.Println("canceled")
-			return
+			return nil
 		}
 	}
 
-	db, err := openDB(*dbPath)
+	q, err := openQueue(*dbPath, *server)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "db error: %v\n", err)
-		os.Exit(1)
+		return err
 	}
-	defer func() { _ = db.Close() }()
+	defer func() { _ = q.Close() }()
 
-	res, err := db.Exec("DELETE FROM queue WHERE treatment=?", *treatment)
+	n, err := q.Reset(*treatment)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "delete error: %v\n", err)
-		return
+		return fmt.Errorf("delete error: %w", err)
 	}
-	n, _ := res.RowsAffected()
 	fmt.Printf("deleted %d entries\n", n)
+	return nil
 }
@@ -11,6 +11,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/dkoosis/next/ledger"
 )
 
 func setupWorkDir(t *testing.T, withSchema bool) (dir string, cleanup func()) {
@@ -74,41 +76,60 @@ func captureStdout(t *testing.T, fn func()) string {
 		t.Fatalf("close writer: %v", err)
 	}
 	os.Stdout = oldStdout
+	<-done
 	if err := r.Close(); err != nil {
 		t.Fatalf("close reader: %v", err)
 	}
-	<-done
 
 	return buf.String()
 }
 
-func TestFileHash_ReturnsDigest_When_FileReadable(t *testing.T) {
-	t.Parallel()
-
-	tmpDir := t.TempDir()
-	filePath := filepath.Join(tmpDir, "sample.txt")
-	content := []byte("hello world\n")
-	if err := os.WriteFile(filePath, content, 0o600); err != nil {
-		t.Fatalf("write file: %v", err)
+func newTestDB(t *testing.T) (db *sql.DB, dbPath string) {
+	t.Helper()
+	tmpDir, restore := setupWorkDir(t, true)
+	t.Cleanup(restore)
+	dbPath = filepath.Join(tmpDir, "ledger.db")
+	db, err := openDB(dbPath)
+	if err != nil {
+		t.Fatalf("openDB: %v", err)
 	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db, dbPath
+}
 
-	got, err := fileHash(filePath)
+// newTestLedger wraps newTestDB in a ledger, writing treatments next to it
+// when non-empty.
+func newTestLedger(t *testing.T, treatments string) (l *ledger.Ledger, db *sql.DB) {
+	t.Helper()
+	db, dbPath := newTestDB(t)
+	if treatments != "" {
+		writeTreatments(t, dbPath, treatments)
+	}
+	l, err := newLedger(db, dbPath)
 	if err != nil {
-		t.Fatalf("fileHash error: %v", err)
+		t.Fatalf("newLedger: %v", err)
 	}
+	return l, db
+}
 
-	// Known SHA-256 digest for "hello world\n"
-	const want = "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"
-	if got != want {
-		t.Fatalf("fileHash = %s, want %s", got, want)
+func writeTreatments(t *testing.T, dbPath, body string) {
+	t.Helper()
+	path := filepath.Join(filepath.Dir(dbPath), "treatments.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write treatments: %v", err)
 	}
 }
 
-func TestFileHash_ReturnsError_When_FileMissing(t *testing.T) {
-	t.Parallel()
-
-	if _, err := fileHash("/non-existent-file" + time.Now().Format("150405")); err == nil {
-		t.Fatal("expected error for missing file")
+// mustEnqueue inserts rows directly, bypassing any treatment definitions.
+func mustEnqueue(t *testing.T, db *sql.DB, treatment string, paths ...string) {
+	t.Helper()
+	for _, p := range paths {
+		if _, err := db.Exec(`
+            INSERT OR IGNORE INTO queue (path, path_hash, content_hash, treatment)
+            VALUES (?, ?, ?, ?)
+        `, p, ledger.PathHash(p), "hash-"+filepath.Base(p), treatment); err != nil {
+			t.Fatalf("insert %s: %v", p, err)
+		}
 	}
 }
 
@@ -134,7 +155,7 @@ func TestOpenDB_CreatesSchema_When_SchemaPresent(t *testing.T) {
 	if _, err := db.Exec(`
         INSERT INTO queue (path, path_hash, content_hash, treatment, done_at, result, next_at)
         VALUES (?, ?, ?, ?, NULL, NULL, NULL)
-    `, "/tmp/file", pathHash("/tmp/file"), "hash", "lint"); err != nil {
+    `, "/tmp/file", ledger.PathHash("/tmp/file"), "hash", "lint"); err != nil {
 		t.Fatalf("insert queue: %v", err)
 	}
 }
@@ -216,9 +237,9 @@ func TestEnqueueCmd_InsertsRows_When_InputContainsValidPaths(t *testing.T) {
 	if storedPath != validAbs {
 		t.Fatalf("stored path = %s, want %s", storedPath, validAbs)
 	}
-	wantHash, err := fileHash(validAbs)
+	wantHash, err := ledger.FileHash(validAbs)
 	if err != nil {
-		t.Fatalf("fileHash: %v", err)
+		t.Fatalf("FileHash: %v", err)
 	}
 	if storedHash != wantHash {
 		t.Fatalf("stored hash = %s, want %s", storedHash, wantHash)
@@ -261,10 +282,10 @@ func TestClaimCmd_PrintsPendingPaths_When_CursorSpecified(t *testing.T) {
 		if _, err := db.Exec(`
             INSERT INTO queue (path, path_hash, content_hash, treatment, done_at, result, next_at)
         VALUES (?, ?, ?, ?, NULL, NULL, NULL)
-        `, p, pathHash(p), fmt.Sprintf("hash-%d", i), "lint"); err != nil {
+        `, p, ledger.PathHash(p), fmt.Sprintf("hash-%d", i), "lint"); err != nil {
 			t.Fatalf("insert %s: %v", p, err)
 		}
-		candidates = append(candidates, candidate{path: p, hash: pathHash(p)})
+		candidates = append(candidates, candidate{path: p, hash: ledger.PathHash(p)})
 	}
 
 	donePath := filepath.Join(tmpDir, "done.txt")
@@ -274,7 +295,7 @@ func TestClaimCmd_PrintsPendingPaths_When_CursorSpecified(t *testing.T) {
 	if _, err := db.Exec(`
         INSERT INTO queue (path, path_hash, content_hash, treatment, done_at, result, next_at)
         VALUES (?, ?, ?, ?, ?, ?, NULL)
-    `, donePath, pathHash(donePath), "hash-done", "lint", time.Now().UTC().Format(time.RFC3339), "ok"); err != nil {
+    `, donePath, ledger.PathHash(donePath), "hash-done", "lint", time.Now().UTC().Format(time.RFC3339), "ok"); err != nil {
 		t.Fatalf("insert done: %v", err)
 	}
 
@@ -335,7 +356,7 @@ func TestStatusCmd_ShowsCounts_When_FilteredByTreatment(t *testing.T) {
 	if _, err := db.Exec(`
         INSERT INTO queue (path, path_hash, content_hash, treatment, done_at, result, next_at)
         VALUES (?, ?, ?, ?, NULL, NULL, NULL)
-    `, pendingPath, pathHash(pendingPath), "hash-pending", "lint"); err != nil {
+    `, pendingPath, ledger.PathHash(pendingPath), "hash-pending", "lint"); err != nil {
 		t.Fatalf("insert pending: %v", err)
 	}
 
@@ -346,7 +367,7 @@ func TestStatusCmd_ShowsCounts_When_FilteredByTreatment(t *testing.T) {
 	if _, err := db.Exec(`
         INSERT INTO queue (path, path_hash, content_hash, treatment, done_at, result, next_at)
         VALUES (?, ?, ?, ?, ?, ?, NULL)
-    `, donePath, pathHash(donePath), "hash-done", "lint", time.Now().UTC().Format(time.RFC3339), "ok"); err != nil {
+    `, donePath, ledger.PathHash(donePath), "hash-done", "lint", time.Now().UTC().Format(time.RFC3339), "ok"); err != nil {
 		t.Fatalf("insert done: %v", err)
 	}
 
@@ -357,7 +378,7 @@ func TestStatusCmd_ShowsCounts_When_FilteredByTreatment(t *testing.T) {
 	if _, err := db.Exec(`
         INSERT INTO queue (path, path_hash, content_hash, treatment, done_at, result, next_at)
         VALUES (?, ?, ?, ?, NULL, NULL, NULL)
-    `, otherPath, pathHash(otherPath), "hash-other", "other"); err != nil {
+    `, otherPath, ledger.PathHash(otherPath), "hash-other", "other"); err != nil {
 		t.Fatalf("insert other: %v", err)
 	}
 
@@ -410,7 +431,7 @@ func TestResetCmd_DeletesEntries_When_Confirmed(t *testing.T) {
 	if _, execErr := db.Exec(`
         INSERT INTO queue (path, path_hash, content_hash, treatment, done_at, result, next_at)
         VALUES (?, ?, ?, ?, NULL, NULL, NULL)
-    `, lintPath, pathHash(lintPath), "hash-lint", "lint"); execErr != nil {
+    `, lintPath, ledger.PathHash(lintPath), "hash-lint", "lint"); execErr != nil {
 		t.Fatalf("insert lint: %v", execErr)
 	}
 
@@ -421,7 +442,7 @@ func TestResetCmd_DeletesEntries_When_Confirmed(t *testing.T) {
 	if _, execErr2 := db.Exec(`
         INSERT INTO queue (path, path_hash, content_hash, treatment, done_at, result, next_at)
         VALUES (?, ?, ?, ?, NULL, NULL, NULL)
-    `, otherPath, pathHash(otherPath), "hash-other", "other"); execErr2 != nil {
+    `, otherPath, ledger.PathHash(otherPath), "hash-other", "other"); execErr2 != nil {
 		t.Fatalf("insert other: %v", execErr2)
 	}
 
@@ -486,7 +507,7 @@ func TestDoneCmd_MarksEntryDone_When_PathProvided(t *testing.T) {
 	if _, execErr := db.Exec(`
         INSERT INTO queue (path, path_hash, content_hash, treatment, done_at, result, next_at)
         VALUES (?, ?, ?, ?, NULL, NULL, NULL)
-    `, absTarget, pathHash(absTarget), "hash-target", "lint"); execErr != nil {
+    `, absTarget, ledger.PathHash(absTarget), "hash-target", "lint"); execErr != nil {
 		t.Fatalf("insert target: %v", execErr)
 	}
 
@@ -521,3 +542,45 @@ func TestDoneCmd_MarksEntryDone_When_PathProvided(t *testing.T) {
 		t.Fatalf("expected next_at NULL, got %v", nextAt)
 	}
 }
+
+func TestFailCmd_ReturnsError_When_RowNotHeld(t *testing.T) {
+	l, db := newTestLedger(t, "")
+	mustEnqueue(t, db, "lint", "/src/a.go")
+	runCmd(t, doneCmd, "done", "--db", l.Path(), "--treatment", "lint", "--path", "/src/a.go", "--revisit", "-1 seconds")
+
+	var err error
+	runCmd(t, func() { err = doFailCmd() }, "fail", "--db", l.Path(), "--treatment", "lint", "--path", "/src/a.go")
+	if err == nil || !strings.Contains(err.Error(), "not pending or claimed") {
+		t.Fatalf("fail on an unclaimed done row err = %v", err)
+	}
+
+	runCmd(t, claimCmd, "claim", "--db", l.Path(), "--treatment", "lint", "--lease", "10m")
+	runCmd(t, func() { err = doFailCmd() }, "fail", "--db", l.Path(), "--treatment", "lint", "--path", "/src/a.go", "--error", "boom")
+	if err != nil {
+		t.Fatalf("fail on the claimed due row: %v", err)
+	}
+	out := runCmd(t, listCmd, "list", "--db", l.Path(), "--treatment", "lint", "--state", "failed")
+	if !strings.Contains(out, "/src/a.go") {
+		t.Fatalf("list --state=failed = %q, want /src/a.go", out)
+	}
+}
+
+func TestResultJSON_FiltersAndAggregates_When_Recorded(t *testing.T) {
+	tmpDir, restore := setupWorkDir(t, true)
+	defer restore()
+
+	dbPath := filepath.Join(tmpDir, "ledger.db")
+	a, b := filepath.Join(tmpDir, "a.go"), filepath.Join(tmpDir, "b.go")
+	seedQueue(t, dbPath, "review", a, b)
+	runCmd(t, doneCmd, "done", "--db", dbPath, "--path", a, "--treatment", "review", "--result", "r1", "--result-json", `{"score": 3}`)
+	runCmd(t, doneCmd, "done", "--db", dbPath, "--path", b, "--treatment", "review", "--result-json", `{"score": 8}`)
+
+	out := runCmd(t, listCmd, "list", "--db", dbPath, "--treatment", "review", "--where", "$.score < 5")
+	if want := "done\treview\t" + a + "\tr1\t{\"score\":3}\n"; out != want {
+		t.Fatalf("list --where = %q, want %q", out, want)
+	}
+	out = runCmd(t, statusCmd, "status", "--db", dbPath, "--agg", "avg($.score)")
+	if !strings.Contains(out, "avg($.score)") || !strings.Contains(out, "5.5") {
+		t.Fatalf("status --agg = %q, want the average 5.5", out)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces path in one rename so node_exporter never reads a
+// partial textfile.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".next-metrics-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil { // #nosec G302 -- node_exporter must be able to read it
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func metricsCmd() {
+	if err := doMetricsCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doMetricsCmd() error {
+	fs := flag.NewFlagSet("metrics", flag.ExitOnError)
+	textfile := fs.String("textfile", "", "write to this file for node_exporter instead of stdout")
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	data, err := q.Metrics()
+	if err != nil {
+		return fmt.Errorf("metrics error: %w", err)
+	}
+	if *textfile == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return writeFileAtomic(*textfile, data)
+}
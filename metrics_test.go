@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetricsCmd_WritesTextfile(t *testing.T) {
+	db, dbPath := newTestDB(t)
+	mustEnqueue(t, db, `we"ird`, "/src/a.go")
+
+	textfile := filepath.Join(filepath.Dir(dbPath), "next.prom")
+	runCmd(t, metricsCmd, "metrics", "--db", dbPath, "--textfile", textfile)
+
+	data, err := os.ReadFile(textfile)
+	if err != nil {
+		t.Fatalf("read textfile: %v", err)
+	}
+	if !strings.Contains(string(data), `next_queue_pending{treatment="we\"ird"} 1`) {
+		t.Fatalf("textfile = %s", data)
+	}
+}
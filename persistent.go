@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// maxWorkerCrashes is how many jobs in a row may lose their worker before
+// run gives up on the command instead of failing the whole queue.
+const maxWorkerCrashes = 5
+
+// workerStopGrace is how long a worker gets to exit after its stdin closes.
+// It is a variable so tests can shorten it.
+var workerStopGrace = 5 * time.Second
+
+// workerJob and workerResult are the lines of the persistent worker protocol
+// documented in docs/worker-protocol.md.
+type workerJob struct {
+	ID          int64             `json:"id"`
+	Path        string            `json:"path"`
+	PathHash    string            `json:"path_hash"`
+	ContentHash string            `json:"content_hash"`
+	Treatment   string            `json:"treatment"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+type workerResult struct {
+	ID     int64  `json:"id"`
+	Result string `json:"result"`
+	Error  string `json:"error"`
+}
+
+// persistentWorker runs an external command once and feeds it one job per
+// line on stdin, reading one result per line from its stdout. A worker that
+// exits, hangs past the timeout or breaks the protocol is stopped, fails the
+// job it was on, and is started again for the next one.
+type persistentWorker struct {
+	argv    []string
+	meta    map[string]string
+	timeout time.Duration
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan []byte
+	nextID  int64
+	crashes int
+}
+
+func newPersistentWorker(argv []string, meta map[string]string, timeout time.Duration) *persistentWorker {
+	return &persistentWorker{argv: argv, meta: meta, timeout: timeout}
+}
+
+func (w *persistentWorker) start() error {
+	cmd := exec.Command(w.argv[0], w.argv[1:]...) // #nosec G204 -- the user names the worker command
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start worker: %w", err)
+	}
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		r := bufio.NewReader(stdout)
+		for {
+			line, err := r.ReadBytes('\n')
+			if len(line) > 0 {
+				lines <- line
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	w.cmd, w.stdin, w.lines = cmd, stdin, lines
+	return nil
+}
+
+// stop closes the worker's stdin, waits up to workerStopGrace for it to
+// exit (immediately when kill is set) and returns how it exited. Once the
+// grace period is over it stops waiting for stdout to close, since a
+// process the worker started may still hold it; Wait closes our end.
+func (w *persistentWorker) stop(kill bool) error {
+	if w.cmd == nil {
+		return nil
+	}
+	_ = w.stdin.Close()
+	grace := time.After(workerStopGrace)
+	if kill {
+		_ = w.cmd.Process.Kill()
+	}
+	for drained := false; !drained; {
+		select {
+		case _, ok := <-w.lines:
+			drained = !ok
+		case <-grace:
+			_ = w.cmd.Process.Kill()
+			drained = true
+		}
+	}
+	err := w.cmd.Wait()
+	// Keep the reader unblocked until it sees the closed pipe.
+	go func(lines <-chan []byte) {
+		for range lines {
+		}
+	}(w.lines)
+	w.cmd, w.stdin, w.lines = nil, nil, nil
+	return err
+}
+
+// execute sends it to the worker, starting one if needed. A job the worker
+// rejects or loses is a jobErr; err means run should stop.
+func (w *persistentWorker) execute(treatment string, it claimedItem) (result string, jobErr, err error) {
+	if w.crashes >= maxWorkerCrashes {
+		return "", nil, fmt.Errorf("worker %q failed %d jobs in a row without answering", strings.Join(w.argv, " "), w.crashes)
+	}
+	if w.cmd == nil {
+		if err := w.start(); err != nil {
+			return "", nil, err
+		}
+	}
+	w.nextID++
+	res, lost := w.roundTrip(workerJob{
+		ID: w.nextID, Path: it.Path, PathHash: it.PathHash, ContentHash: it.ContentHash,
+		Treatment: treatment, Metadata: w.meta,
+	})
+	if lost != nil {
+		w.crashes++
+		return "", lost, nil
+	}
+	w.crashes = 0
+	if res.Error != "" {
+		return "", errors.New(res.Error), nil
+	}
+	return res.Result, nil, nil
+}
+
+// roundTrip writes one job and reads its result. Any failure stops the
+// worker and is returned as the job's error.
+func (w *persistentWorker) roundTrip(job workerJob) (workerResult, error) {
+	line, err := json.Marshal(job)
+	if err != nil {
+		return workerResult{}, err
+	}
+	if _, err := w.stdin.Write(append(line, '\n')); err != nil {
+		return workerResult{}, fmt.Errorf("worker exited: %v", exitReason(w.stop(false)))
+	}
+	var timeout <-chan time.Time
+	if w.timeout > 0 {
+		timer := time.NewTimer(w.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case out, ok := <-w.lines:
+		if !ok {
+			return workerResult{}, fmt.Errorf("worker exited: %v", exitReason(w.stop(false)))
+		}
+		var res workerResult
+		if err := json.Unmarshal(out, &res); err != nil || res.ID != job.ID {
+			_ = w.stop(true)
+			return workerResult{}, fmt.Errorf("worker protocol error: want a result for job %d, got %q", job.ID, strings.TrimSpace(string(out)))
+		}
+		return res, nil
+	case <-timeout:
+		_ = w.stop(true)
+		return workerResult{}, fmt.Errorf("worker timed out after %v", w.timeout)
+	}
+}
+
+// exitReason describes how a worker process ended.
+func exitReason(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+// metaFlag collects repeated --meta KEY=VALUE flags.
+type metaFlag map[string]string
+
+func (m metaFlag) String() string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m metaFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("want KEY=VALUE, got %q", s)
+	}
+	m[k] = v
+	return nil
+}
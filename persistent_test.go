@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperWorker is the worker process for the persistent tests: the test
+// binary re-executes itself with NEXT_TEST_WORKER set.
+func TestHelperWorker(t *testing.T) {
+	if os.Getenv("NEXT_TEST_WORKER") == "" {
+		t.Skip("helper process")
+	}
+	if log := os.Getenv("NEXT_TEST_WORKER_LOG"); log != "" {
+		f, err := os.OpenFile(log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, _ = f.WriteString("start\n")
+			_ = f.Close()
+		}
+	}
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		var job workerJob
+		if err := json.Unmarshal(sc.Bytes(), &job); err != nil {
+			os.Exit(2)
+		}
+		res := workerResult{ID: job.ID, Result: job.ContentHash + "/" + job.Metadata["model"]}
+		switch filepath.Base(job.Path) {
+		case "crash.go":
+			os.Exit(3)
+		case "garbage.go":
+			fmt.Println("not json")
+			continue
+		case "bad.go":
+			res = workerResult{ID: job.ID, Error: "rejected"}
+		}
+		out, _ := json.Marshal(res)
+		fmt.Println(string(out))
+	}
+	os.Exit(0)
+}
+
+func TestRunCmd_RestartsWorker_When_Persistent(t *testing.T) {
+	l, db := newTestLedger(t, "")
+	dir := t.TempDir()
+	paths := map[string]string{}
+	for _, name := range []string{"a.go", "b.go", "bad.go", "crash.go", "garbage.go"} {
+		paths[name] = filepath.Join(dir, name)
+	}
+	mustEnqueue(t, db, "review", paths["a.go"], paths["b.go"], paths["bad.go"], paths["crash.go"], paths["garbage.go"])
+	log := filepath.Join(t.TempDir(), "starts")
+	t.Setenv("NEXT_TEST_WORKER", "1")
+	t.Setenv("NEXT_TEST_WORKER_LOG", log)
+
+	out := runCmd(t, runTreatmentCmd, "run", "--db", l.Path(), "--treatment", "review", "--persistent",
+		"--meta", "model=small", "--", os.Args[0], "-test.run=^TestHelperWorker$")
+
+	entries, err := l.List(t.Context(), listOptions{Treatment: "review"})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	got := map[string]entry{}
+	for _, e := range entries {
+		got[filepath.Base(e.Path)] = e
+	}
+	for _, name := range []string{"a.go", "b.go"} {
+		if e := got[name]; e.State != "done" || e.Result != "hash-"+name+"/small" {
+			t.Errorf("%s = %+v, want done with its content hash and metadata", name, e)
+		}
+	}
+	for name, want := range map[string]string{"bad.go": "rejected", "crash.go": "worker exited", "garbage.go": "protocol error"} {
+		if e := got[name]; e.State != "failed" || !strings.Contains(e.Error, want) {
+			t.Errorf("%s = %+v, want failed with %q", name, e, want)
+		}
+	}
+	if strings.Count(out, "\n") != 5 {
+		t.Errorf("output:\n%s", out)
+	}
+
+	starts, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("read start log: %v", err)
+	}
+	// One start, plus one after the crash and one after the protocol error,
+	// unless either was the last job.
+	if n := strings.Count(string(starts), "start"); n < 1 || n > 3 {
+		t.Errorf("worker started %d times", n)
+	}
+}
+
+func TestRunCmd_RejectsCommand_When_NotPersistent(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	os.Args = []string{"next", "run", "--treatment", "line-count", "--", "cat"}
+	if err := doRunTreatmentCmd(); err == nil || !strings.Contains(err.Error(), "--persistent") {
+		t.Fatalf("err = %v, want a --persistent hint", err)
+	}
+}
+
+func TestPersistentWorker_Stops_When_GrandchildHoldsStdout(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+	defer func(g time.Duration) { workerStopGrace = g }(workerStopGrace)
+	workerStopGrace = 100 * time.Millisecond
+
+	// The worker exits when its stdin closes, but the sleep it started
+	// keeps the stdout pipe open.
+	w := newPersistentWorker([]string{"sh", "-c", "sleep 5 2>/dev/null & cat >/dev/null"}, nil, 0)
+	if err := w.start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- w.stop(false) }()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("stop hung while a grandchild held the worker's stdout")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dkoosis/next/ledger"
+	"github.com/dkoosis/next/treatment"
+
+	// Reference treatments compiled into the binary.
+	_ "github.com/dkoosis/next/treatment/gofmtcheck"
+	_ "github.com/dkoosis/next/treatment/licenseheader"
+	_ "github.com/dkoosis/next/treatment/linecount"
+)
+
+func runTreatmentCmd() {
+	if err := doRunTreatmentCmd(); errors.Is(err, ledger.ErrBudgetExhausted) {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitBudgetExhausted)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doRunTreatmentCmd() error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	name := fs.String("treatment", "", "treatment to run: a built-in one, or any with --persistent (required)")
+	persistent := fs.Bool("persistent", false, "run the command after -- once and send it jobs over stdin/stdout (docs/worker-protocol.md)")
+	meta := metaFlag{}
+	fs.Var(meta, "meta", "KEY=VALUE added to every job's metadata with --persistent (repeatable)")
+	timeout := fs.Duration("timeout", 0, "with --persistent, restart a worker that takes longer than this on one job (0 = no limit)")
+	n := fs.Int("n", 10, "paths to claim per batch")
+	lease := fs.Duration("lease", 0, "hide claimed paths from other workers for this long (e.g., 10m)")
+	var wait waitFlag
+	fs.Var(&wait, "wait", "keep waiting for claimable paths; --wait=DURATION stops once idle for DURATION")
+	revisit := fs.String("revisit", "", "revisit after duration (e.g., '14 days'; default: defined revisit)")
+	worker := workerFlag(fs, os.Getpid())
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	var execute executor
+	switch {
+	case *persistent:
+		if *name == "" || fs.NArg() == 0 {
+			return fmt.Errorf("error: --persistent needs --treatment and a command after --")
+		}
+		worker := newPersistentWorker(fs.Args(), meta, *timeout)
+		defer func() { _ = worker.stop(false) }()
+		execute = func(it claimedItem) (string, error, error) { return worker.execute(*name, it) }
+	case fs.NArg() > 0:
+		return fmt.Errorf("error: a worker command needs --persistent")
+	default:
+		t, ok := treatment.Lookup(*name)
+		if !ok {
+			return fmt.Errorf("error: no built-in treatment %q (have: %s)", *name, strings.Join(treatment.Names(), ", "))
+		}
+		execute = builtinExecutor(t)
+	}
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	opts := claimOptions{Treatment: *name, N: *n, Lease: *lease, Worker: *worker}
+	done, failed, err := runTreatment(q, execute, opts, wait, *revisit, os.Stdout)
+	fmt.Fprintf(os.Stderr, "%s: %d done, %d failed\n", *name, done, failed)
+	return err
+}
+
+// executor processes one claimed path. A jobErr is recorded with fail; err
+// stops the run.
+type executor func(it claimedItem) (result string, jobErr, err error)
+
+// builtinExecutor runs a compiled-in treatment in-process.
+func builtinExecutor(t treatment.Treatment) executor {
+	return func(it claimedItem) (string, error, error) {
+		result, err := treatment.Run(t, it.Path)
+		return result, err, nil
+	}
+}
+
+// runTreatment claims batches and executes each path until nothing is
+// claimable, or, with wait, until a wait times out. It prints a line per
+// path in list's format: state, path, and the result or error. It stops
+// when a done or fail updates no row, since the path would stay claimable
+// and be executed again forever.
+func runTreatment(q queueAPI, exec executor, opts claimOptions, wait waitFlag, revisit string, w io.Writer) (done, failed int, err error) {
+	for {
+		items, err := q.Claim(opts)
+		if err == nil && len(items) == 0 && wait.set {
+			items, err = claimUntil(q, opts, wait.timeout)
+		}
+		if errors.Is(err, errWaitTimeout) {
+			return done, failed, nil
+		}
+		if err != nil {
+			return done, failed, fmt.Errorf("query error: %w", err)
+		}
+		if len(items) == 0 {
+			return done, failed, nil
+		}
+		for _, it := range items {
+			result, runErr, err := exec(it)
+			if err != nil {
+				return done, failed, err
+			}
+			if runErr != nil {
+				n, err := q.Fail(failOptions{Path: it.Path, Treatment: opts.Treatment, Error: runErr.Error()})
+				if err != nil {
+					return done, failed, fmt.Errorf("update error: %w", err)
+				}
+				if n == 0 {
+					return done, failed, errNotRecorded(it.Path, "failure")
+				}
+				failed++
+				fmt.Fprintf(w, "failed\t%s\t%s\n", it.Path, runErr)
+				continue
+			}
+			n, err := q.Done(doneOptions{Path: it.Path, Treatment: opts.Treatment, Result: result, Revisit: revisit})
+			if err != nil {
+				return done, failed, fmt.Errorf("update error: %w", err)
+			}
+			if n == 0 {
+				return done, failed, errNotRecorded(it.Path, "result")
+			}
+			done++
+			fmt.Fprintf(w, "done\t%s\t%s\n", it.Path, result)
+		}
+	}
+}
+
+// errNotRecorded reports a claimed path whose outcome updated no row, e.g.
+// because it was reset or reopened mid-run.
+func errNotRecorded(path, what string) error {
+	return fmt.Errorf("update error: %s for %s updated no row; stopping", what, path)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dkoosis/next/treatment"
+)
+
+type testTreatment struct{}
+
+func (testTreatment) Name() string { return "test-size" }
+
+func (testTreatment) Execute(path string) (string, error) {
+	if strings.HasSuffix(path, "bad.go") {
+		return "", errors.New("rejected")
+	}
+	return "checked", nil
+}
+
+func init() {
+	treatment.Register(testTreatment{})
+}
+
+func TestRunCmd_RecordsResults_When_TreatmentRegistered(t *testing.T) {
+	l, db := newTestLedger(t, "")
+	dir := t.TempDir()
+	good, bad := filepath.Join(dir, "good.go"), filepath.Join(dir, "bad.go")
+	mustEnqueue(t, db, "test-size", good, bad)
+
+	out := runCmd(t, runTreatmentCmd, "run", "--treatment", "test-size", "--db", l.Path())
+	if !strings.Contains(out, "done\t"+good+"\tchecked\n") || !strings.Contains(out, "failed\t"+bad+"\trejected\n") {
+		t.Fatalf("output:\n%s", out)
+	}
+
+	entries, err := l.List(t.Context(), listOptions{Treatment: "test-size"})
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("List = %+v, %v", entries, err)
+	}
+	for _, e := range entries {
+		switch e.Path {
+		case good:
+			if e.State != "done" || e.Result != "checked" {
+				t.Errorf("good entry = %+v", e)
+			}
+		case bad:
+			if e.State != "failed" || e.Error != "rejected" {
+				t.Errorf("bad entry = %+v", e)
+			}
+		}
+	}
+}
+
+func TestRunCmd_FailsOnce_When_DueRowRejected(t *testing.T) {
+	l, db := newTestLedger(t, "")
+	bad := filepath.Join(t.TempDir(), "bad.go")
+	mustEnqueue(t, db, "test-size", bad)
+	if _, err := l.Complete(t.Context(), doneOptions{Path: bad, Treatment: "test-size", Revisit: "-1 seconds"}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	out := runCmd(t, runTreatmentCmd, "run", "--treatment", "test-size", "--db", l.Path())
+	if out != "failed\t"+bad+"\trejected\n" {
+		t.Fatalf("output = %q, want one failure", out)
+	}
+}
+
+func TestRunTreatment_Stops_When_OutcomeUpdatesNoRow(t *testing.T) {
+	l, db := newTestLedger(t, "")
+	mustEnqueue(t, db, "test-size", "/src/a.go", "/src/b.go")
+	q, err := openQueue(l.Path(), "")
+	if err != nil {
+		t.Fatalf("openQueue: %v", err)
+	}
+	defer func() { _ = q.Close() }()
+
+	reset := func(it claimedItem) (string, error, error) {
+		_, err := l.Reset(t.Context(), "test-size")
+		return "checked", nil, err
+	}
+	done, failed, err := runTreatment(q, reset, claimOptions{Treatment: "test-size", N: 1}, waitFlag{}, "", io.Discard)
+	if err == nil || done != 0 || failed != 0 {
+		t.Fatalf("runTreatment = %d done, %d failed, %v; want an error for the lost row", done, failed, err)
+	}
+}
+
+func TestRunCmd_ExecutesReferenceTreatment_When_Linked(t *testing.T) {
+	l, db := newTestLedger(t, "")
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("one\ntwo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mustEnqueue(t, db, "line-count", path)
+
+	out := runCmd(t, runTreatmentCmd, "run", "--treatment", "line-count", "--db", l.Path())
+	if out != "done\t"+path+"\t2\n" {
+		t.Fatalf("output = %q", out)
+	}
+}
+
+func TestTreatmentsCmd_ListsBuiltins_When_NoRows(t *testing.T) {
+	l, _ := newTestLedger(t, "")
+
+	out := runCmd(t, treatmentsCmd, "treatments", "--db", l.Path())
+	for _, name := range []string{"gofmt-check", "license-header", "line-count", "test-size"} {
+		if !strings.Contains(out, name) {
+			t.Errorf("treatments output missing %s:\n%s", name, out)
+		}
+	}
+	if !strings.Contains(out, "(built-in: next run)") {
+		t.Errorf("built-ins not marked:\n%s", out)
+	}
+}
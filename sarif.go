@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dkoosis/next/client"
+	"github.com/dkoosis/next/ledger"
+)
+
+// sarifLog is the subset of SARIF 2.1.0 that ingestion reads.
+type sarifLog struct {
+	Runs []struct {
+		OriginalURIBaseIDs map[string]struct {
+			URI string `json:"uri"`
+		} `json:"originalUriBaseIds"`
+		Artifacts []struct {
+			Location sarifArtifactLocation `json:"location"`
+		} `json:"artifacts"`
+		Results []sarifResult `json:"results"`
+	} `json:"runs"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	Fingerprints        map[string]string `json:"fingerprints"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+	Rule                struct {
+		ID string `json:"id"`
+	} `json:"rule"`
+	Level   string `json:"level"`
+	Message struct {
+		Text string `json:"text"`
+	} `json:"message"`
+	Locations []struct {
+		PhysicalLocation struct {
+			ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+			Region           struct {
+				StartLine int `json:"startLine"`
+				EndLine   int `json:"endLine"`
+			} `json:"region"`
+		} `json:"physicalLocation"`
+	} `json:"locations"`
+}
+
+// fingerprint prefers the tool's fingerprints, then the flagged source
+// text. It returns "" when neither is known, leaving the ledger's
+// message-based default.
+func (res sarifResult) fingerprint(f client.Finding, text string) string {
+	for _, fps := range []map[string]string{res.Fingerprints, res.PartialFingerprints} {
+		if len(fps) == 0 {
+			continue
+		}
+		keys := make([]string, 0, len(fps))
+		for k := range fps {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s=%s\n", k, fps[k])
+		}
+		return ledger.Fingerprint(f.Rule, b.String())
+	}
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+	return ledger.Fingerprint(f.Rule, text)
+}
+
+// maxFingerprintLines caps how much of a multi-line region is hashed.
+const maxFingerprintLines = 20
+
+// sourceLines caches the files a report points into, keyed by path. A file
+// that cannot be read maps to nil.
+type sourceLines map[string][]string
+
+// text returns lines start..end of path, or "" when they are unknown.
+func (s sourceLines) text(path string, start, end int) string {
+	lines, ok := s[path]
+	if !ok {
+		b, err := os.ReadFile(path) // #nosec G304 -- path named by the report being ingested
+		if err == nil {
+			lines = strings.Split(string(b), "\n")
+		}
+		s[path] = lines
+	}
+	if start < 1 || start > len(lines) {
+		return ""
+	}
+	end = min(end, len(lines), start+maxFingerprintLines-1)
+	return strings.Join(lines[start-1:end], "\n")
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId"`
+}
+
+// sarifReport maps absolute paths to their findings. Paths a run lists as
+// analyzed but without results map to no findings.
+type sarifReport map[string][]client.Finding
+
+func readSARIF(file, root string) (sarifReport, error) {
+	f, err := os.Open(file) // #nosec G304 -- user-specified report
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return parseSARIF(f, root)
+}
+
+// parseSARIF reads a SARIF log, resolving relative artifact URIs against
+// their uriBaseId, when the log defines it, and otherwise against root.
+// Results without a file location are dropped. Each finding is fingerprinted
+// by the tool's own fingerprints when present, and otherwise by its rule and
+// the flagged source lines, so it can be matched after lines move.
+func parseSARIF(r io.Reader, root string) (sarifReport, error) {
+	var log sarifLog
+	if err := json.NewDecoder(r).Decode(&log); err != nil {
+		return nil, fmt.Errorf("invalid SARIF: %w", err)
+	}
+	report := sarifReport{}
+	src := sourceLines{}
+	for _, run := range log.Runs {
+		bases := map[string]string{}
+		for id, b := range run.OriginalURIBaseIDs {
+			bases[id] = b.URI
+		}
+		for _, a := range run.Artifacts {
+			if p := resolveSARIFPath(a.Location, bases, root); p != "" {
+				if _, ok := report[p]; !ok {
+					report[p] = nil
+				}
+			}
+		}
+		for _, res := range run.Results {
+			if len(res.Locations) == 0 {
+				continue
+			}
+			loc := res.Locations[0].PhysicalLocation
+			p := resolveSARIFPath(loc.ArtifactLocation, bases, root)
+			if p == "" {
+				continue
+			}
+			f := client.Finding{
+				Rule: res.RuleID, Level: res.Level, Message: res.Message.Text,
+				StartLine: loc.Region.StartLine, EndLine: loc.Region.EndLine,
+			}
+			if f.Rule == "" {
+				f.Rule = res.Rule.ID
+			}
+			if f.Level == "" {
+				f.Level = "warning" // SARIF's default level
+			}
+			if f.EndLine < f.StartLine {
+				f.EndLine = f.StartLine
+			}
+			f.Fingerprint = res.fingerprint(f, src.text(p, f.StartLine, f.EndLine))
+			report[p] = append(report[p], f)
+		}
+	}
+	return report, nil
+}
+
+// resolveSARIFPath turns an artifact location into an absolute file path, or
+// "" when it is not a file.
+func resolveSARIFPath(loc sarifArtifactLocation, bases map[string]string, root string) string {
+	u, err := url.Parse(loc.URI)
+	if err != nil || loc.URI == "" {
+		return ""
+	}
+	if base, ok := bases[loc.URIBaseID]; ok && !u.IsAbs() {
+		if b, err := url.Parse(base); err == nil {
+			u = b.ResolveReference(u)
+		}
+	}
+	switch u.Scheme {
+	case "file":
+	case "":
+		if !filepath.IsAbs(u.Path) {
+			u.Path = filepath.Join(root, u.Path)
+		}
+	default:
+		return ""
+	}
+	p, err := filepath.Abs(filepath.FromSlash(u.Path))
+	if err != nil {
+		return ""
+	}
+	return p
+}
+
+// ingestSARIF completes every path in report with its findings, using base
+// for everything but the path. A path without a ledger row for the
+// treatment is skipped rather than enqueued.
+func ingestSARIF(q queueAPI, report sarifReport, base doneOptions) (done, findings, skipped int, err error) {
+	paths := make([]string, 0, len(report))
+	for p := range report {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		opts := base
+		opts.Path, opts.Findings = p, report[p]
+		if opts.Result == "" {
+			opts.Result = fmt.Sprintf("findings=%d", len(report[p]))
+		}
+		n, err := q.Done(opts)
+		if err != nil {
+			return done, findings, skipped, fmt.Errorf("update error: %s: %w", p, err)
+		}
+		if n == 0 {
+			skipped++
+			continue
+		}
+		done++
+		findings += len(report[p])
+	}
+	return done, findings, skipped, nil
+}
+
+// printIngest reports an ingestSARIF outcome.
+func printIngest(treatment string, done, findings, skipped int) {
+	fmt.Printf("ingested %d findings for %d paths (treatment=%s)\n", findings, done, treatment)
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d paths not enqueued for %s\n", skipped, treatment)
+	}
+}
+
+func ingestCmd() {
+	if err := doIngestCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doIngestCmd() error {
+	fs := flag.NewFlagSet("ingest", flag.ExitOnError)
+	sarif := fs.String("sarif", "", "SARIF report to ingest (required)")
+	root := fs.String("root", ".", "directory relative result URIs are resolved against")
+	treatment := fs.String("treatment", "default", "treatment name")
+	revisit := fs.String("revisit", "", "revisit after duration (e.g., '14 days')")
+	version := fs.String("version", "", "treatment version that produced the report (default: defined version)")
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	if *sarif == "" {
+		return fmt.Errorf("error: --sarif required")
+	}
+	report, err := readSARIF(*sarif, *root)
+	if err != nil {
+		return fmt.Errorf("sarif error: %w", err)
+	}
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	done, findings, skipped, err := ingestSARIF(q, report, doneOptions{Treatment: *treatment, Revisit: *revisit, Version: *version})
+	if err != nil {
+		return err
+	}
+	printIngest(*treatment, done, findings, skipped)
+	return nil
+}
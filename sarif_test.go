@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dkoosis/next/client"
+)
+
+const testSARIF = `{
+  "version": "2.1.0",
+  "runs": [{
+    "originalUriBaseIds": {"SRCROOT": {"uri": "file:///work/"}},
+    "artifacts": [{"location": {"uri": "clean.go"}}],
+    "results": [
+      {"ruleId": "unused", "level": "error", "message": {"text": "x is unused"},
+       "locations": [{"physicalLocation": {"artifactLocation": {"uri": "a.go"}, "region": {"startLine": 3}}}]},
+      {"rule": {"id": "shadow"}, "message": {"text": "err shadowed"},
+       "locations": [{"physicalLocation": {"artifactLocation": {"uri": "pkg/b%20c.go", "uriBaseId": "SRCROOT"}, "region": {"startLine": 7, "endLine": 9}}}]},
+      {"ruleId": "remote", "message": {"text": "ignored"},
+       "locations": [{"physicalLocation": {"artifactLocation": {"uri": "https://example.com/x.go"}}}]},
+      {"ruleId": "nowhere", "message": {"text": "no location"}}
+    ]
+  }]
+}`
+
+func TestParseSARIF_MapsResultsToPaths_When_URIsVary(t *testing.T) {
+	report, err := parseSARIF(strings.NewReader(testSARIF), "/repo")
+	if err != nil {
+		t.Fatalf("parseSARIF: %v", err)
+	}
+	if len(report) != 3 {
+		t.Fatalf("report = %+v, want a.go, b c.go and clean.go", report)
+	}
+	a := report["/repo/a.go"]
+	if len(a) != 1 || a[0] != (client.Finding{Rule: "unused", Level: "error", StartLine: 3, EndLine: 3, Message: "x is unused"}) {
+		t.Fatalf("a.go findings = %+v", a)
+	}
+	b := report["/work/pkg/b c.go"]
+	if len(b) != 1 || b[0].Rule != "shadow" || b[0].Level != "warning" || b[0].EndLine != 9 {
+		t.Fatalf("b c.go findings = %+v, want rule from rule.id and the default level", b)
+	}
+	if f, ok := report["/repo/clean.go"]; !ok || len(f) != 0 {
+		t.Fatalf("clean.go = %+v, %v; want analyzed with no findings", f, ok)
+	}
+}
+
+func TestIngestCmd_RecordsFindings_When_PathsEnqueued(t *testing.T) {
+	tmpDir, restore := setupWorkDir(t, true)
+	defer restore()
+
+	dbPath := filepath.Join(tmpDir, "ledger.db")
+	a, clean := filepath.Join(tmpDir, "a.go"), filepath.Join(tmpDir, "clean.go")
+	seedQueue(t, dbPath, "lint", a, clean)
+	report := filepath.Join(tmpDir, "report.sarif")
+	if err := os.WriteFile(report, []byte(testSARIF), 0o600); err != nil {
+		t.Fatalf("write report: %v", err)
+	}
+
+	out := runCmd(t, ingestCmd, "ingest", "--db", dbPath, "--sarif", report, "--root", tmpDir, "--treatment", "lint")
+	if out != "ingested 1 findings for 2 paths (treatment=lint)\n" {
+		t.Fatalf("ingest output = %q", out)
+	}
+	out = runCmd(t, findingsCmd, "findings", "--db", dbPath, "--treatment", "lint")
+	if want := "lint\t" + a + ":3\terror\tunused\tx is unused\n"; out != want {
+		t.Fatalf("findings = %q, want %q", out, want)
+	}
+
+	l, err := openLedger(dbPath)
+	if err != nil {
+		t.Fatalf("openLedger: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+	done, err := l.List(context.Background(), listOptions{Treatment: "lint", State: "done"})
+	if err != nil || len(done) != 2 {
+		t.Fatalf("done rows = %+v, %v; want both paths", done, err)
+	}
+	for _, e := range done {
+		if e.Path == clean && e.Result != "findings=0" {
+			t.Fatalf("clean.go = %+v, want findings=0", e)
+		}
+	}
+}
+
+func TestDoneCmd_ReplacesFindings_When_SARIFHasNoneForPath(t *testing.T) {
+	tmpDir, restore := setupWorkDir(t, true)
+	defer restore()
+
+	dbPath := filepath.Join(tmpDir, "ledger.db")
+	a := filepath.Join(tmpDir, "a.go")
+	seedQueue(t, dbPath, "lint", a)
+	report := filepath.Join(tmpDir, "report.sarif")
+	if err := os.WriteFile(report, []byte(testSARIF), 0o600); err != nil {
+		t.Fatalf("write report: %v", err)
+	}
+	empty := filepath.Join(tmpDir, "empty.sarif")
+	if err := os.WriteFile(empty, []byte(`{"version": "2.1.0", "runs": [{"results": []}]}`), 0o600); err != nil {
+		t.Fatalf("write report: %v", err)
+	}
+
+	runCmd(t, doneCmd, "done", "--db", dbPath, "--sarif", report, "--treatment", "lint")
+	if out := runCmd(t, findingsCmd, "findings", "--db", dbPath, "--path", a); !strings.Contains(out, "unused") {
+		t.Fatalf("findings = %q, want the unused finding", out)
+	}
+	runCmd(t, doneCmd, "done", "--db", dbPath, "--sarif", empty, "--path", a, "--treatment", "lint")
+	if out := runCmd(t, findingsCmd, "findings", "--db", dbPath, "--path", a); out != "" {
+		t.Fatalf("findings after a clean report = %q, want none", out)
+	}
+}
+
+func TestDiffCmd_MatchesMovedFindings_When_LinesShift(t *testing.T) {
+	tmpDir, restore := setupWorkDir(t, true)
+	defer restore()
+
+	dbPath := filepath.Join(tmpDir, "ledger.db")
+	a := filepath.Join(tmpDir, "a.go")
+	seedQueue(t, dbPath, "lint", a)
+	report := func(src string, results string) string {
+		t.Helper()
+		if err := os.WriteFile(a, []byte(src), 0o600); err != nil {
+			t.Fatalf("write source: %v", err)
+		}
+		p := filepath.Join(tmpDir, "report.sarif")
+		body := `{"version": "2.1.0", "runs": [{"results": [` + results + `]}]}`
+		if err := os.WriteFile(p, []byte(body), 0o600); err != nil {
+			t.Fatalf("write report: %v", err)
+		}
+		return p
+	}
+	result := func(rule string, line int, msg string) string {
+		return `{"ruleId": "` + rule + `", "message": {"text": "` + msg + `"}, "locations": [{"physicalLocation": {"artifactLocation": {"uri": "a.go"}, "region": {"startLine": ` + strconv.Itoa(line) + `}}}]}`
+	}
+
+	first := report("package a\nvar x = 1\nvar y = 2\n",
+		result("unused", 2, "x unused at line 2")+","+result("unused", 3, "y unused at line 3"))
+	runCmd(t, doneCmd, "done", "--db", dbPath, "--sarif", first, "--path", a, "--treatment", "lint")
+	second := report("package a\n\nimport \"os\"\nvar x = 1\nvar y = 3\n",
+		result("unused", 4, "x unused at line 4")+","+result("unused", 5, "y unused at line 5")+","+result("unusedimport", 3, "os unused"))
+	runCmd(t, doneCmd, "done", "--db", dbPath, "--sarif", second, "--path", a, "--treatment", "lint")
+
+	out := runCmd(t, diffCmd, "diff", "--db", dbPath, "--treatment", "lint", "--path", a)
+	want := "new\t" + a + ":3\twarning\tunusedimport\tos unused\n" +
+		"unchanged\t" + a + ":4\twarning\tunused\tx unused at line 4\n" +
+		"new\t" + a + ":5\twarning\tunused\ty unused at line 5\n" +
+		"fixed\t" + a + ":3\twarning\tunused\ty unused at line 3\n"
+	if out != want {
+		t.Fatalf("diff =\n%s\nwant\n%s", out, want)
+	}
+
+	out = runCmd(t, diffCmd, "diff", "--db", dbPath, "--treatment", "lint")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || strings.Fields(lines[1])[0] != "2" || strings.Fields(lines[2])[3] != "TOTAL" {
+		t.Fatalf("summary = %q, want a header, a.go with 2 new and a total", out)
+	}
+}
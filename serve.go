@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dkoosis/next/client"
+	"github.com/dkoosis/next/ledger"
+)
+
+const defaultListen = "127.0.0.1:7070"
+
+// server exposes the ledger operations as JSON endpoints. Requests run with
+// their own context, so a client that hangs up mid-wait frees its claim.
+type server struct {
+	l *ledger.Ledger
+}
+
+func newServer(l *ledger.Ledger) *server {
+	return &server{l: l}
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/enqueue", s.handleEnqueue)
+	mux.HandleFunc("POST /api/claim", s.handleClaim)
+	mux.HandleFunc("POST /api/done", s.handleDone)
+	mux.HandleFunc("POST /api/fail", s.handleFail)
+	mux.HandleFunc("GET /api/status", s.handleStatus)
+	mux.HandleFunc("GET /api/versions", s.handleVersions)
+	mux.HandleFunc("GET /api/list", s.handleList)
+	mux.HandleFunc("GET /api/aggregate", s.handleAggregate)
+	mux.HandleFunc("POST /api/reset", s.handleReset)
+	mux.HandleFunc("GET /api/snapshots", s.handleSnapshots)
+	mux.HandleFunc("GET /api/treatments", s.handleTreatments)
+	mux.HandleFunc("POST /api/undo", s.handleUndo)
+	mux.HandleFunc("GET /api/history", s.handleHistory)
+	mux.HandleFunc("GET /api/findings", s.handleFindings)
+	mux.HandleFunc("GET /api/audit", s.handleAudit)
+	mux.HandleFunc("GET /api/workers", s.handleWorkers)
+	mux.HandleFunc("GET /api/costs", s.handleCosts)
+	mux.HandleFunc("POST /api/artifacts", s.handlePutArtifact)
+	mux.HandleFunc("GET /api/artifacts/{hash}", s.handleArtifact)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.HandleFunc("GET /{$}", s.handleDashboard)
+	return withActor(mux)
+}
+
+// withActor audits each request's mutations as the process the client
+// reported, or as the remote address when it reported none.
+func withActor(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a := client.ActorFromHeader(r.Header)
+		if a == (client.Actor{}) {
+			a.Host, _, _ = net.SplitHostPort(r.RemoteAddr)
+		}
+		h.ServeHTTP(w, r.WithContext(ledger.WithActor(r.Context(), a)))
+	})
+}
+
+func (s *server) handleEnqueue(w http.ResponseWriter, r *http.Request) {
+	var req client.EnqueueRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	enqueue := s.l.Enqueue
+	if req.Reopen {
+		enqueue = s.l.Reopen
+	}
+	n, err := enqueue(r.Context(), defaultTreatment(req.Treatment), req.Items)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.EnqueueResponse{Enqueued: n})
+}
+
+func (s *server) handleClaim(w http.ResponseWriter, r *http.Request) {
+	var req client.ClaimRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts := claimOptions{Treatment: defaultTreatment(req.Treatment), Cursor: req.Cursor, N: req.N, Version: req.Version, Worker: req.Worker}
+	if req.Lease != "" {
+		d, err := time.ParseDuration(req.Lease)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid lease: %w", err))
+			return
+		}
+		opts.Lease = d
+	}
+	if req.Wait != "" {
+		d, err := time.ParseDuration(req.Wait)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid wait %q", req.Wait))
+			return
+		}
+		opts.Wait = d
+	}
+	items, err := s.l.Claim(r.Context(), opts)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.ClaimResponse{Items: nonNil(items)})
+}
+
+func (s *server) handleDone(w http.ResponseWriter, r *http.Request) {
+	var req doneOptions
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Path == "" {
+		writeError(w, http.StatusBadRequest, errors.New("path required"))
+		return
+	}
+	req.Treatment = defaultTreatment(req.Treatment)
+	n, err := s.l.Complete(r.Context(), req)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.UpdateResponse{Updated: n})
+}
+
+func (s *server) handleFail(w http.ResponseWriter, r *http.Request) {
+	var req failOptions
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Path == "" {
+		writeError(w, http.StatusBadRequest, errors.New("path required"))
+		return
+	}
+	req.Treatment = defaultTreatment(req.Treatment)
+	n, err := s.l.Fail(r.Context(), req)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.UpdateResponse{Updated: n})
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.l.Stats(r.Context(), r.URL.Query().Get("treatment"))
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.StatusResponse{Treatments: nonNil(rows)})
+}
+
+func (s *server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.l.Versions(r.Context(), r.URL.Query().Get("treatment"))
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.VersionsResponse{Versions: nonNil(rows)})
+}
+
+func (s *server) handleList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := listOptions{Treatment: q.Get("treatment"), State: q.Get("state"), Where: q.Get("where")}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %w", err))
+			return
+		}
+		opts.Limit = n
+	}
+	if v := q.Get("newest"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid newest: %w", err))
+			return
+		}
+		opts.Newest = b
+	}
+	entries, err := s.l.List(r.Context(), opts)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.ListResponse{Entries: nonNil(entries)})
+}
+
+func (s *server) handleAggregate(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	rows, err := s.l.Aggregate(r.Context(), q.Get("treatment"), q.Get("agg"))
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.AggregateResponse{Rows: nonNil(rows)})
+}
+
+func (s *server) handleReset(w http.ResponseWriter, r *http.Request) {
+	var req client.ResetRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Treatment == "" {
+		writeError(w, http.StatusBadRequest, errors.New("treatment required"))
+		return
+	}
+	n, err := s.l.Reset(r.Context(), req.Treatment)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.UpdateResponse{Updated: n})
+}
+
+func (s *server) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	snaps, err := s.l.Snapshots(r.Context())
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.SnapshotsResponse{Snapshots: snaps})
+}
+
+func (s *server) handleUndo(w http.ResponseWriter, r *http.Request) {
+	var req client.UndoRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.l.Undo(r.Context(), req.Snapshot); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.UpdateResponse{Updated: 1})
+}
+
+func (s *server) handleWorkers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := ledger.WorkersOptions{Treatment: q.Get("treatment")}
+	for _, p := range []struct {
+		name string
+		d    *time.Duration
+	}{{"window", &opts.Window}, {"stale", &opts.Stale}} {
+		if v := q.Get(p.name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s %q", p.name, v))
+				return
+			}
+			*p.d = d
+		}
+	}
+	workers, err := s.l.Workers(r.Context(), opts)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.WorkersResponse{Workers: nonNil(workers)})
+}
+
+func (s *server) handleCosts(w http.ResponseWriter, r *http.Request) {
+	costs, err := s.l.Costs(r.Context(), r.URL.Query().Get("treatment"))
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.CostsResponse{Costs: nonNil(costs)})
+}
+
+func (s *server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := client.AuditRequest{Since: q.Get("since"), Command: q.Get("command")}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %w", err))
+			return
+		}
+		req.Limit = n
+	}
+	entries, err := s.l.AuditLog(r.Context(), req)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.AuditResponse{Entries: nonNil(entries)})
+}
+
+func (s *server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("path") == "" {
+		writeError(w, http.StatusBadRequest, errors.New("path required"))
+		return
+	}
+	runs, err := s.l.History(r.Context(), defaultTreatment(q.Get("treatment")), q.Get("path"))
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.HistoryResponse{Runs: nonNil(runs)})
+}
+
+func (s *server) handleFindings(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := client.FindingsRequest{Treatment: q.Get("treatment"), Rule: q.Get("rule"), Path: q.Get("path")}
+	if v := q.Get("previous"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid previous: %w", err))
+			return
+		}
+		req.Previous = b
+	}
+	findings, err := s.l.Findings(r.Context(), req)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.FindingsResponse{Findings: nonNil(findings)})
+}
+
+// maxArtifactBytes bounds one uploaded output blob.
+const maxArtifactBytes = 256 << 20
+
+func (s *server) handlePutArtifact(w http.ResponseWriter, r *http.Request) {
+	hash, err := s.l.PutArtifact(r.Context(), http.MaxBytesReader(w, r.Body, maxArtifactBytes))
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.ArtifactResponse{Artifact: hash})
+}
+
+func (s *server) handleArtifact(w http.ResponseWriter, r *http.Request) {
+	rc, err := s.l.OpenArtifact(r.Context(), r.PathValue("hash"))
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	defer func() { _ = rc.Close() }()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = io.Copy(w, rc)
+}
+
+func (s *server) handleTreatments(w http.ResponseWriter, r *http.Request) {
+	list, err := s.l.Treatments(r.Context())
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, client.TreatmentsResponse{Treatments: list})
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := s.l.WriteMetrics(r.Context(), &buf); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// errorStatus maps ledger errors to HTTP statuses: caller mistakes such as an
+// unknown treatment or a malformed query are 400s, a missing snapshot or
+// artifact a 404, a spent budget a 402, anything else a 500.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ledger.ErrUnknownTreatment), errors.Is(err, ledger.ErrUnknownState),
+		errors.Is(err, ledger.ErrInvalidQuery), errors.Is(err, ledger.ErrInvalidResultJSON),
+		errors.Is(err, ledger.ErrLeaseRequired), errors.Is(err, ledger.ErrInvalidSpend):
+		return http.StatusBadRequest
+	case errors.Is(err, ledger.ErrBudgetExhausted):
+		return http.StatusPaymentRequired
+	case errors.Is(err, ledger.ErrSnapshotNotFound), errors.Is(err, ledger.ErrArtifactNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func defaultTreatment(t string) string {
+	if t == "" {
+		return "default"
+	}
+	return t
+}
+
+// nonNil keeps empty results encoding as [] rather than null.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+func decodeJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 32<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, client.ErrorResponse{Error: err.Error()})
+}
+
+// listen accepts "host:port" or "unix:/path/to.sock".
+func listen(addr string) (net.Listener, error) {
+	if sock, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// A socket left behind by a crashed server would make bind fail.
+		if err := os.Remove(sock); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return net.Listen("unix", sock)
+	}
+	return net.Listen("tcp", addr)
+}
+
+func serveCmd() {
+	if err := doServeCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doServeCmd() error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("listen", defaultListen, "address to listen on (host:port or unix:/path)")
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	_ = fs.Parse(os.Args[2:])
+
+	db, err := openDB(*dbPath)
+	if err != nil {
+		return fmt.Errorf("db error: %w", err)
+	}
+	// One connection serializes writes from every client in-process instead
+	// of having them contend on the file lock.
+	db.SetMaxOpenConns(1)
+	l, err := newLedger(db, *dbPath)
+	if err != nil {
+		_ = db.Close()
+		return err
+	}
+	defer func() { _ = l.Close() }()
+
+	ln, err := listen(*addr)
+	if err != nil {
+		return fmt.Errorf("listen error: %w", err)
+	}
+
+	srv := &http.Server{
+		Handler:           newServer(l).routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(os.Stderr, "serving %s on %s\n", *dbPath, ln.Addr())
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dkoosis/next/client"
+	"github.com/dkoosis/next/ledger"
+)
+
+func newTestServer(t *testing.T) (c *client.Client, url, dbPath string) {
+	t.Helper()
+	l, _ := newTestLedger(t, "")
+	srv := httptest.NewServer(newServer(l).routes())
+	t.Cleanup(srv.Close)
+	c, err := client.New(srv.URL)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+	return c, srv.URL, l.Path()
+}
+
+func TestServer_RoundTrip_EnqueueClaimDoneFail(t *testing.T) {
+	c, _, _ := newTestServer(t)
+	ctx := context.Background()
+
+	n, err := c.Enqueue(ctx, "lint", []client.EnqueueItem{{Path: "/src/a.go", ContentHash: "h1"}, {Path: "/src/b.go", ContentHash: "h2"}})
+	if err != nil || n != 2 {
+		t.Fatalf("enqueue: n=%d err=%v", n, err)
+	}
+
+	claimed, err := c.Claim(ctx, client.ClaimRequest{Treatment: "lint", N: 2, Lease: "1m"})
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if len(claimed) != 2 {
+		t.Fatalf("claimed %+v, want 2 items", claimed)
+	}
+
+	if n, err := c.Done(ctx, client.DoneRequest{Path: claimed[0].Path, Treatment: "lint", Result: "ok"}); err != nil || n != 1 {
+		t.Fatalf("done: n=%d err=%v", n, err)
+	}
+	if n, err := c.Fail(ctx, client.FailRequest{Path: claimed[1].Path, Treatment: "lint", Error: "boom"}); err != nil || n != 1 {
+		t.Fatalf("fail: n=%d err=%v", n, err)
+	}
+
+	status, err := c.Status(ctx, "lint")
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	want := statusRow{Treatment: "lint", Pending: 1, Failed: 1, Done: 1}
+	if len(status) != 1 || status[0] != want {
+		t.Fatalf("status = %+v, want %+v", status, want)
+	}
+
+	entries, err := c.List(ctx, client.ListRequest{State: "failed"})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Error != "boom" {
+		t.Fatalf("list = %+v", entries)
+	}
+}
+
+func TestServer_RejectsBadRequests(t *testing.T) {
+	c, _, _ := newTestServer(t)
+	ctx := context.Background()
+
+	var apiErr *client.Error
+	if _, err := c.Done(ctx, client.DoneRequest{Treatment: "lint"}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("done without path: err=%v", err)
+	}
+	if _, err := c.Claim(ctx, client.ClaimRequest{Lease: "forever"}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("claim with bad lease: err=%v", err)
+	}
+	if _, err := c.List(ctx, client.ListRequest{State: "bogus"}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("list with bad state: err=%v", err)
+	}
+	if _, err := c.List(ctx, client.ListRequest{Where: "score < 5"}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("list with bad where: err=%v", err)
+	}
+	if _, err := c.Aggregate(ctx, "", "median($.score)"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("aggregate with bad function: err=%v", err)
+	}
+}
+
+func TestServer_ResetAndUndo_RoundTrip(t *testing.T) {
+	c, _, _ := newTestServer(t)
+	ctx := context.Background()
+	var apiErr *client.Error
+
+	if _, err := c.Enqueue(ctx, "review", []client.EnqueueItem{{Path: "/src/a.go", ContentHash: "h1"}}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if n, err := c.Reset(ctx, "review"); err != nil || n != 1 {
+		t.Fatalf("reset: n=%d err=%v", n, err)
+	}
+	snaps, err := c.Snapshots(ctx)
+	if err != nil || len(snaps) != 1 {
+		t.Fatalf("snapshots = %+v, err=%v", snaps, err)
+	}
+	if err := c.Undo(ctx, snaps[0].Name); err != nil {
+		t.Fatalf("undo: %v", err)
+	}
+	entries, err := c.List(ctx, client.ListRequest{Treatment: "review"})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("after undo entries = %+v, err=%v", entries, err)
+	}
+	if err := c.Undo(ctx, "../ledger.db"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("undo outside the snapshot dir: err=%v, want 404", err)
+	}
+}
+
+func TestCommands_UseServer_When_FlagSet(t *testing.T) {
+	c, url, dbPath := newTestServer(t)
+	ctx := context.Background()
+	if _, err := c.Enqueue(ctx, "lint", []client.EnqueueItem{{Path: "/src/a.go", ContentHash: "h1"}}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	// Point --db somewhere empty so only the server can answer.
+	emptyDB := filepath.Join(filepath.Dir(dbPath), "unused.db")
+	t.Setenv("NEXT_SERVER", url)
+	output := runCmd(t, claimCmd, "claim", "--db", emptyDB, "--treatment", "lint")
+	if output != "/src/a.go\n" {
+		t.Fatalf("claim output = %q", output)
+	}
+}
+
+func TestServer_Artifacts_RoundTrip(t *testing.T) {
+	c, _, _ := newTestServer(t)
+	ctx := context.Background()
+	if _, err := c.Enqueue(ctx, "lint", []client.EnqueueItem{{Path: "/src/a.go", ContentHash: "h1"}}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	hash, err := c.PutArtifact(ctx, strings.NewReader("line 1: unused variable\n"))
+	if err != nil || len(hash) != 64 {
+		t.Fatalf("put artifact = %q, %v", hash, err)
+	}
+	if _, err := c.Done(ctx, client.DoneRequest{Path: "/src/a.go", Treatment: "lint", Result: "1 issue", Artifact: hash}); err != nil {
+		t.Fatalf("done: %v", err)
+	}
+	runs, err := c.History(ctx, "lint", "/src/a.go")
+	if err != nil || len(runs) != 1 || runs[0].Artifact != hash || runs[0].State != "done" {
+		t.Fatalf("history = %+v, %v", runs, err)
+	}
+
+	rc, err := c.Artifact(ctx, hash)
+	if err != nil {
+		t.Fatalf("artifact: %v", err)
+	}
+	b, err := io.ReadAll(rc)
+	_ = rc.Close()
+	if err != nil || string(b) != "line 1: unused variable\n" {
+		t.Fatalf("artifact = %q, %v", b, err)
+	}
+
+	var apiErr *client.Error
+	if _, err := c.Artifact(ctx, strings.Repeat("0", 64)); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("missing artifact err = %v, want 404", err)
+	}
+	_, err = c.Done(ctx, client.DoneRequest{Path: "/src/a.go", Treatment: "lint", Artifact: "not-a-hash"})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("done with unknown artifact err = %v, want 404", err)
+	}
+}
+
+func TestServer_Findings_RoundTrip(t *testing.T) {
+	c, _, _ := newTestServer(t)
+	ctx := context.Background()
+	if _, err := c.Enqueue(ctx, "lint", []client.EnqueueItem{{Path: "/src/a.go", ContentHash: "h1"}}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	findings := []client.Finding{{Rule: "unused", Level: "warning", StartLine: 3, EndLine: 3, Message: "x is unused"}}
+	if _, err := c.Done(ctx, client.DoneRequest{Path: "/src/a.go", Treatment: "lint", Findings: findings}); err != nil {
+		t.Fatalf("done: %v", err)
+	}
+	got, err := c.Findings(ctx, client.FindingsRequest{Rule: "unused"})
+	if err != nil || len(got) != 1 || got[0].Path != "/src/a.go" || got[0].Message != "x is unused" {
+		t.Fatalf("findings = %+v, %v", got, err)
+	}
+	if got, err := c.Findings(ctx, client.FindingsRequest{Rule: "shadow"}); err != nil || len(got) != 0 {
+		t.Fatalf("findings(shadow) = %+v, %v", got, err)
+	}
+}
+
+func TestServer_Audit_RecordsClientActor(t *testing.T) {
+	c, url, _ := newTestServer(t)
+	ctx := context.Background()
+	if _, err := c.Enqueue(ctx, "lint", []client.EnqueueItem{{Path: "/src/a.go", ContentHash: "h1"}}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	entries, err := c.Audit(ctx, client.AuditRequest{Command: "enqueue"})
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("audit = %+v, %v; want one entry", entries, err)
+	}
+	if e := entries[0]; e.Actor != ledger.CurrentActor() || e.Treatment != "lint" || e.Rows != 1 {
+		t.Fatalf("audit entry = %+v, want the client's actor", e)
+	}
+
+	resp, err := http.Get(url + "/api/audit?since=yesterday")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad since status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestServer_Workers_RoundTrip(t *testing.T) {
+	c, url, _ := newTestServer(t)
+	ctx := context.Background()
+	if _, err := c.Enqueue(ctx, "lint", []client.EnqueueItem{{Path: "/src/a.go", ContentHash: "h1"}}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	if _, err := c.Claim(ctx, client.ClaimRequest{Treatment: "lint", Lease: "10m", Worker: "box:42"}); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	workers, err := c.Workers(ctx, client.WorkersRequest{Treatment: "lint", Window: "5m"})
+	if err != nil || len(workers) != 1 || workers[0].Worker != "box:42" || workers[0].Active != 1 {
+		t.Fatalf("workers = %+v, %v", workers, err)
+	}
+
+	resp, err := http.Get(url + "/api/workers?stale=soon")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("bad stale status = %d, want 400", resp.StatusCode)
+	}
+}
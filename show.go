@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// artifactFlags registers --artifact and --artifact-stdin on done and fail.
+func artifactFlags(fs *flag.FlagSet) (file *string, stdin *bool) {
+	file = fs.String("artifact", "", "store this file's contents as the run's full output")
+	stdin = fs.Bool("artifact-stdin", false, "store stdin as the run's full output")
+	return file, stdin
+}
+
+// storeArtifact uploads the output named by --artifact or --artifact-stdin
+// and returns its digest, or "" when neither is set.
+func storeArtifact(q queueAPI, file string, stdin bool) (string, error) {
+	var r io.Reader
+	switch {
+	case file != "" && stdin:
+		return "", errors.New("error: --artifact and --artifact-stdin are exclusive")
+	case stdin:
+		r = os.Stdin
+	case file != "":
+		f, err := os.Open(file) // #nosec G304 -- user-specified artifact file
+		if err != nil {
+			return "", fmt.Errorf("artifact error: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+		r = f
+	default:
+		return "", nil
+	}
+	hash, err := q.PutArtifact(r)
+	if err != nil {
+		return "", fmt.Errorf("artifact error: %w", err)
+	}
+	return hash, nil
+}
+
+func showCmd() {
+	if err := doShowCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doShowCmd() error {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	path := fs.String("path", "", "file path (required)")
+	treatment := fs.String("treatment", "default", "treatment name")
+	history := fs.Bool("history", false, "list every recorded run instead of printing the latest artifact")
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	if *path == "" {
+		return fmt.Errorf("error: --path required")
+	}
+	absPath, err := filepath.Abs(*path)
+	if err != nil {
+		return fmt.Errorf("path error: %w", err)
+	}
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	runs, err := q.History(*treatment, absPath)
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+	if *history {
+		for _, r := range runs {
+			detail := r.Result
+			if r.State == "failed" {
+				detail = r.Error
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\n", r.At, r.State, orDash(r.Artifact), detail)
+		}
+		return nil
+	}
+	if len(runs) == 0 {
+		return fmt.Errorf("no runs recorded for %s (treatment=%s)", absPath, *treatment)
+	}
+	if runs[0].Artifact == "" {
+		return fmt.Errorf("latest run of %s (treatment=%s) has no artifact", absPath, *treatment)
+	}
+	rc, err := q.OpenArtifact(runs[0].Artifact)
+	if err != nil {
+		return fmt.Errorf("artifact error: %w", err)
+	}
+	defer func() { _ = rc.Close() }()
+	_, err = io.Copy(os.Stdout, rc)
+	return err
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShowCmd_PrintsLatestArtifact_When_DoneStoredOne(t *testing.T) {
+	tmpDir, restore := setupWorkDir(t, true)
+	defer restore()
+
+	dbPath := filepath.Join(tmpDir, "ledger.db")
+	a, b := filepath.Join(tmpDir, "a.go"), filepath.Join(tmpDir, "b.go")
+	seedQueue(t, dbPath, "lint", a, b)
+	out := filepath.Join(tmpDir, "out.txt")
+	if err := os.WriteFile(out, []byte("a.go:3: unused import\n"), 0o600); err != nil {
+		t.Fatalf("write output: %v", err)
+	}
+
+	runCmd(t, failCmd, "fail", "--db", dbPath, "--path", a, "--treatment", "lint", "--error", "timeout", "--revisit", "-1 seconds")
+	runCmd(t, doneCmd, "done", "--db", dbPath, "--path", a, "--treatment", "lint", "--result", "1 issue", "--artifact", out)
+	runCmd(t, doneCmd, "done", "--db", dbPath, "--path", b, "--treatment", "lint", "--result", "1 issue", "--artifact", out)
+
+	blobs, err := os.ReadDir(filepath.Join(tmpDir, "artifacts"))
+	if err != nil || len(blobs) != 1 {
+		t.Fatalf("artifacts = %v, %v; want identical output stored once", blobs, err)
+	}
+	if got := runCmd(t, showCmd, "show", "--db", dbPath, "--path", a, "--treatment", "lint"); got != "a.go:3: unused import\n" {
+		t.Fatalf("show = %q", got)
+	}
+
+	lines := strings.Split(strings.TrimSpace(runCmd(t, showCmd, "show", "--db", dbPath, "--path", a, "--treatment", "lint", "--history")), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("history = %q, want 2 runs", lines)
+	}
+	if f := strings.Split(lines[0], "\t"); f[1] != "done" || f[2] != blobs[0].Name() || f[3] != "1 issue" {
+		t.Fatalf("newest run = %q", lines[0])
+	}
+	if f := strings.Split(lines[1], "\t"); f[1] != "failed" || f[2] != "-" || f[3] != "timeout" {
+		t.Fatalf("oldest run = %q", lines[1])
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dkoosis/next/ledger"
+)
+
+// snapshotKeep returns the retention count from NEXT_SNAPSHOT_KEEP as a
+// ledger option: unset means the ledger's default, and zero disables
+// automatic snapshots.
+func snapshotKeep() (int, error) {
+	v := os.Getenv("NEXT_SNAPSHOT_KEEP")
+	if v == "" {
+		return ledger.DefaultSnapshotKeep, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid NEXT_SNAPSHOT_KEEP %q", v)
+	}
+	if n == 0 {
+		return -1, nil
+	}
+	return n, nil
+}
+
+func snapshotsCmd() {
+	if err := doSnapshotsCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doSnapshotsCmd() error {
+	fs := flag.NewFlagSet("snapshots", flag.ExitOnError)
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	snaps, err := q.Snapshots()
+	if err != nil {
+		return fmt.Errorf("snapshot error: %w", err)
+	}
+	fmt.Printf("%-40s %-10s %20s %10s\n", "SNAPSHOT", "REASON", "CREATED", "SIZE")
+	for i := len(snaps) - 1; i >= 0; i-- {
+		s := snaps[i]
+		fmt.Printf("%-40s %-10s %20s %10d\n", s.Name, s.Reason, s.Created.Format(time.RFC3339), s.Size)
+	}
+	return nil
+}
+
+func undoCmd() {
+	if err := doUndoCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doUndoCmd() error {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	name := fs.String("snapshot", "", "snapshot to restore (default: most recent)")
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	confirm := fs.Bool("yes", false, "skip confirmation")
+	_ = fs.Parse(os.Args[2:])
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	snaps, err := q.Snapshots()
+	if err != nil {
+		return fmt.Errorf("snapshot error: %w", err)
+	}
+	if len(snaps) == 0 {
+		return fmt.Errorf("error: no snapshots to restore")
+	}
+	target := snaps[len(snaps)-1]
+	if *name != "" {
+		found := false
+		for _, s := range snaps {
+			if s.Name == *name {
+				target, found = s, true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("error: snapshot %q not found", *name)
+		}
+	}
+
+	if !*confirm {
+		fmt.Printf("Restore %s (taken before %s)? [y/N] ", target.Name, target.Reason)
+		var response string
+		_, _ = fmt.Scanln(&response)
+		if response != "y" && response != "Y" {
+			fmt.Println("canceled")
+			return nil
+		}
+	}
+
+	if err := q.Undo(target.Name); err != nil {
+		return fmt.Errorf("restore error: %w", err)
+	}
+	fmt.Printf("restored %s\n", target.Name)
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dkoosis/next/client"
+	"github.com/dkoosis/next/ledger"
+)
+
+func seedQueue(t *testing.T, dbPath, treatment string, paths ...string) {
+	t.Helper()
+	db, err := openDB(dbPath)
+	if err != nil {
+		t.Fatalf("openDB: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	for _, p := range paths {
+		if _, err := db.Exec(`
+            INSERT INTO queue (path, path_hash, content_hash, treatment, done_at, result, next_at)
+            VALUES (?, ?, ?, ?, NULL, NULL, NULL)
+        `, p, ledger.PathHash(p), "hash-"+filepath.Base(p), treatment); err != nil {
+			t.Fatalf("insert %s: %v", p, err)
+		}
+	}
+}
+
+func countQueue(t *testing.T, dbPath, treatment string) int {
+	t.Helper()
+	db, err := openDB(dbPath)
+	if err != nil {
+		t.Fatalf("openDB: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM queue WHERE treatment=?", treatment).Scan(&n); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	return n
+}
+
+func listTestSnapshots(t *testing.T, dbPath string) []client.Snapshot {
+	t.Helper()
+	l, err := openLedger(dbPath)
+	if err != nil {
+		t.Fatalf("openLedger: %v", err)
+	}
+	defer func() { _ = l.Close() }()
+	snaps, err := l.Snapshots(context.Background())
+	if err != nil {
+		t.Fatalf("Snapshots: %v", err)
+	}
+	return snaps
+}
+
+func runCmd(t *testing.T, fn func(), args ...string) string {
+	t.Helper()
+	oldArgs := os.Args
+	os.Args = append([]string{"next"}, args...)
+	defer func() { os.Args = oldArgs }()
+	return captureStdout(t, fn)
+}
+
+func TestUndoCmd_RestoresRows_When_ResetSnapshotted(t *testing.T) {
+	tmpDir, restore := setupWorkDir(t, true)
+	defer restore()
+
+	dbPath := filepath.Join(tmpDir, "ledger.db")
+	seedQueue(t, dbPath, "review", filepath.Join(tmpDir, "a.go"), filepath.Join(tmpDir, "b.go"))
+
+	runCmd(t, resetCmd, "reset", "--db", dbPath, "--treatment", "review", "--yes")
+	if got := countQueue(t, dbPath, "review"); got != 0 {
+		t.Fatalf("after reset count = %d, want 0", got)
+	}
+
+	snaps := listTestSnapshots(t, dbPath)
+	if len(snaps) != 1 || snaps[0].Reason != "reset" {
+		t.Fatalf("snapshots = %+v, want one reset snapshot", snaps)
+	}
+
+	output := runCmd(t, undoCmd, "undo", "--db", dbPath, "--yes")
+	if !strings.Contains(output, "restored "+snaps[0].Name) {
+		t.Fatalf("unexpected output: %q", output)
+	}
+	if got := countQueue(t, dbPath, "review"); got != 2 {
+		t.Fatalf("after undo count = %d, want 2", got)
+	}
+
+	after := listTestSnapshots(t, dbPath)
+	if len(after) != 2 || after[0].Name != snaps[0].Name || after[1].Reason != "undo" {
+		t.Fatalf("undo should keep its snapshot and save the replaced state, got %+v", after)
+	}
+	runCmd(t, undoCmd, "undo", "--db", dbPath, "--yes")
+	if got := countQueue(t, dbPath, "review"); got != 0 {
+		t.Fatalf("after undoing the undo count = %d, want 0", got)
+	}
+}
+
+func TestResetCmd_SkipsSnapshot_When_RetentionZero(t *testing.T) {
+	tmpDir, restore := setupWorkDir(t, true)
+	defer restore()
+	t.Setenv("NEXT_SNAPSHOT_KEEP", "0")
+
+	dbPath := filepath.Join(tmpDir, "ledger.db")
+	seedQueue(t, dbPath, "review", filepath.Join(tmpDir, "a.go"))
+
+	runCmd(t, resetCmd, "reset", "--db", dbPath, "--treatment", "review", "--yes")
+	if got := countQueue(t, dbPath, "review"); got != 0 {
+		t.Fatalf("after reset count = %d, want 0", got)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "snapshots")); !os.IsNotExist(err) {
+		t.Fatalf("snapshot dir should not exist, stat err = %v", err)
+	}
+}
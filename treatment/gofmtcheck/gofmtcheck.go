@@ -0,0 +1,39 @@
+// Package gofmtcheck registers the gofmt-check treatment, which fails Go
+// files that gofmt would rewrite.
+package gofmtcheck
+
+import (
+	"bytes"
+	"errors"
+	"go/format"
+	"os"
+
+	"github.com/dkoosis/next/treatment"
+)
+
+func init() {
+	treatment.Register(Check{})
+}
+
+// ErrUnformatted is returned for a file whose contents differ from gofmt's.
+var ErrUnformatted = errors.New("not gofmt-formatted")
+
+// Check is the gofmt-check treatment. Its result is "formatted".
+type Check struct{}
+
+func (Check) Name() string { return "gofmt-check" }
+
+func (Check) Execute(path string) (string, error) {
+	src, err := os.ReadFile(path) // #nosec G304 -- paths come from the ledger
+	if err != nil {
+		return "", err
+	}
+	out, err := format.Source(src)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(src, out) {
+		return "", ErrUnformatted
+	}
+	return "formatted", nil
+}
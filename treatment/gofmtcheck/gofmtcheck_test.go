@@ -0,0 +1,27 @@
+package gofmtcheck
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheck_FailsFile_When_NotFormatted(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.go")
+	bad := filepath.Join(dir, "bad.go")
+	if err := os.WriteFile(good, []byte("package x\n\nfunc f() {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, []byte("package x\nfunc  f( ) {}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if res, err := (Check{}).Execute(good); err != nil || res != "formatted" {
+		t.Fatalf("Execute(good) = %q, %v", res, err)
+	}
+	if _, err := (Check{}).Execute(bad); !errors.Is(err, ErrUnformatted) {
+		t.Fatalf("Execute(bad) err = %v, want ErrUnformatted", err)
+	}
+}
@@ -0,0 +1,49 @@
+// Package licenseheader registers the license-header treatment, which fails
+// files without a license notice near the top.
+package licenseheader
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/dkoosis/next/treatment"
+)
+
+func init() {
+	treatment.Register(Check{})
+}
+
+// headerLines is how far into a file the notice may start, leaving room for
+// a shebang, build constraints or a generated-code banner.
+const headerLines = 10
+
+// ErrMissing is returned for a file with no notice in its header.
+var ErrMissing = errors.New("no license header")
+
+// Check is the license-header treatment. It accepts an SPDX identifier or a
+// copyright line, and its result is the line it found.
+type Check struct{}
+
+func (Check) Name() string { return "license-header" }
+
+func (Check) Execute(path string) (string, error) {
+	f, err := os.Open(path) // #nosec G304 -- paths come from the ledger
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	sc := bufio.NewScanner(f)
+	for i := 0; i < headerLines && sc.Scan(); i++ {
+		line := strings.TrimSpace(sc.Text())
+		if strings.Contains(line, "SPDX-License-Identifier:") || strings.Contains(strings.ToLower(line), "copyright") {
+			return line, nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrMissing
+}
@@ -0,0 +1,30 @@
+package licenseheader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheck_FindsNotice_When_InHeader(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	spdx := write("spdx.go", "//go:build linux\n\n// SPDX-License-Identifier: MIT\npackage x\n")
+	late := write("late.go", strings.Repeat("\n", 12)+"// Copyright 2025 Someone\n")
+
+	if got, err := (Check{}).Execute(spdx); err != nil || got != "// SPDX-License-Identifier: MIT" {
+		t.Fatalf("Execute(spdx) = %q, %v", got, err)
+	}
+	if _, err := (Check{}).Execute(late); !errors.Is(err, ErrMissing) {
+		t.Fatalf("Execute(late) err = %v, want ErrMissing", err)
+	}
+}
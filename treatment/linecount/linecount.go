@@ -0,0 +1,33 @@
+// Package linecount registers the line-count treatment, whose result is the
+// number of lines in the file.
+package linecount
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+
+	"github.com/dkoosis/next/treatment"
+)
+
+func init() {
+	treatment.Register(Count{})
+}
+
+// Count is the line-count treatment. A final line without a trailing
+// newline still counts.
+type Count struct{}
+
+func (Count) Name() string { return "line-count" }
+
+func (Count) Execute(path string) (string, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- paths come from the ledger
+	if err != nil {
+		return "", err
+	}
+	n := bytes.Count(data, []byte{'\n'})
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		n++
+	}
+	return strconv.Itoa(n), nil
+}
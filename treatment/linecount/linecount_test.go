@@ -0,0 +1,19 @@
+package linecount
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCount_CountsLines_When_LastLineUnterminated(t *testing.T) {
+	for body, want := range map[string]string{"": "0", "a\n": "1", "a\nb": "2", "a\n\nb\n": "3"} {
+		path := filepath.Join(t.TempDir(), "f.txt")
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := (Count{}).Execute(path); err != nil || got != want {
+			t.Errorf("Execute(%q) = %q, %v; want %s", body, got, err, want)
+		}
+	}
+}
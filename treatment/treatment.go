@@ -0,0 +1,81 @@
+// Package treatment is the registry of treatments compiled into the next
+// binary. `next run --treatment=NAME` claims paths and executes the
+// registered Treatment on each one in-process, recording its result with
+// done or its error with fail.
+//
+// A treatment registers itself from its package's init function, so linking
+// it in is a blank import:
+//
+//	import _ "github.com/dkoosis/next/treatment/gofmtcheck"
+//
+// The gofmtcheck, linecount and licenseheader packages are small examples.
+package treatment
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Treatment is work that runs on one file at a time.
+type Treatment interface {
+	// Name is the queue treatment the implementation processes.
+	Name() string
+	// Execute processes path, returning the result recorded with done. An
+	// error is recorded with fail instead.
+	Execute(path string) (result string, err error)
+}
+
+var (
+	mu         sync.RWMutex
+	registered = map[string]Treatment{}
+)
+
+// Register makes t available under t.Name(). It panics if t is nil, its name
+// is empty, or the name is already registered.
+func Register(t Treatment) {
+	if t == nil {
+		panic("treatment: Register of nil treatment")
+	}
+	name := t.Name()
+	if name == "" {
+		panic("treatment: Register of treatment with empty name")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := registered[name]; dup {
+		panic(fmt.Sprintf("treatment: Register called twice for %q", name))
+	}
+	registered[name] = t
+}
+
+// Lookup returns the treatment registered under name.
+func Lookup(name string) (Treatment, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	t, ok := registered[name]
+	return t, ok
+}
+
+// Names returns the registered names, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registered))
+	for name := range registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run executes t on path, turning a panic into an error so one bad file
+// cannot stop a run.
+func Run(t Treatment, path string) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s panicked: %v", t.Name(), r)
+		}
+	}()
+	return t.Execute(path)
+}
@@ -0,0 +1,53 @@
+package treatment
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type fake struct {
+	name string
+	fn   func(string) (string, error)
+}
+
+func (f fake) Name() string                        { return f.name }
+func (f fake) Execute(path string) (string, error) { return f.fn(path) }
+
+func TestRegister_MakesTreatmentVisible_When_NameNew(t *testing.T) {
+	Register(fake{name: "test-echo", fn: func(p string) (string, error) { return p, nil }})
+
+	got, ok := Lookup("test-echo")
+	if !ok || got.Name() != "test-echo" {
+		t.Fatalf("Lookup = %v, %v", got, ok)
+	}
+	if !slices.Contains(Names(), "test-echo") {
+		t.Fatalf("Names() = %v, missing test-echo", Names())
+	}
+	if _, ok := Lookup("test-missing"); ok {
+		t.Fatal("Lookup found an unregistered name")
+	}
+}
+
+func TestRegister_Panics_When_NameTaken(t *testing.T) {
+	Register(fake{name: "test-dup"})
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "test-dup") {
+			t.Fatalf("recover() = %v, want a duplicate panic", r)
+		}
+	}()
+	Register(fake{name: "test-dup"})
+}
+
+func TestRun_ReturnsError_When_TreatmentPanics(t *testing.T) {
+	boom := fake{name: "test-boom", fn: func(string) (string, error) { panic("boom") }}
+	if _, err := Run(boom, "/src/a.go"); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("err = %v, want the panic as an error", err)
+	}
+
+	failing := fake{name: "test-fail", fn: func(string) (string, error) { return "", errors.New("bad") }}
+	if _, err := Run(failing, "/src/a.go"); err == nil || err.Error() != "bad" {
+		t.Fatalf("err = %v, want bad", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dkoosis/next/client"
+	"github.com/dkoosis/next/treatment"
+)
+
+func treatmentsCmd() {
+	if err := doTreatmentsCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doTreatmentsCmd() error {
+	fs := flag.NewFlagSet("treatments", flag.ExitOnError)
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	list, err := q.Treatments()
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+	list = withBuiltins(list)
+	fmt.Printf("%-20s %8s %8s %8s %8s  %-12s %s\n", "TREATMENT", "PENDING", "FAILED", "DONE", "DUE", "VERSION", "COMMAND")
+	for _, t := range list {
+		command := t.Command
+		_, builtin := treatment.Lookup(t.Name)
+		switch {
+		case builtin && command == "":
+			command = "(built-in: next run)"
+		case !t.Defined:
+			command = "(not defined)"
+		}
+		version := t.Version
+		if version == "" {
+			version = "-"
+		}
+		fmt.Printf("%-20s %8d %8d %8d %8d  %-12s %s\n", t.Name, t.Stats.Pending, t.Stats.Failed, t.Stats.Done, t.Stats.Due, version, command)
+	}
+	return nil
+}
+
+// withBuiltins appends the compiled-in treatments the ledger knows nothing
+// about yet, so they are listed before their first enqueue.
+func withBuiltins(list []client.Treatment) []client.Treatment {
+	seen := make(map[string]bool, len(list))
+	for _, t := range list {
+		seen[t.Name] = true
+	}
+	for _, name := range treatment.Names() {
+		if !seen[name] {
+			list = append(list, client.Treatment{Name: name, Stats: client.StatusRow{Treatment: name}})
+		}
+	}
+	return list
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dkoosis/next/client"
+)
+
+func TestTreatmentsCmd_ListsDefinitionsAndUndefinedQueues(t *testing.T) {
+	l, db := newTestLedger(t, `{"treatments": {"lint": {"command": "golangci-lint run"}}}`)
+	mustEnqueue(t, db, "lint", "/src/a.go")
+	mustEnqueue(t, db, "lnit", "/src/b.go")
+
+	out := runCmd(t, treatmentsCmd, "treatments", "--db", l.Path())
+	// Built-in treatments follow; TestTreatmentsCmd_ListsBuiltins_When_NoRows covers them.
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) < 3 {
+		t.Fatalf("output:\n%s", out)
+	}
+	if f := strings.Fields(lines[1]); f[0] != "lint" || f[1] != "1" || !strings.Contains(lines[1], "golangci-lint run") {
+		t.Errorf("lint line = %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "lnit") || !strings.Contains(lines[2], "(not defined)") {
+		t.Errorf("lnit line = %q", lines[2])
+	}
+}
+
+func TestServer_ReturnsBadRequest_When_TreatmentUnknown(t *testing.T) {
+	l, _ := newTestLedger(t, `{"treatments": {"lint": {}}}`)
+	srv := httptest.NewServer(newServer(l).routes())
+	defer srv.Close()
+	c, err := client.New(srv.URL)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+
+	_, err = c.Enqueue(t.Context(), "lnit", []client.EnqueueItem{{Path: "/src/a.go", ContentHash: "h"}})
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+		t.Fatalf("err = %v, want HTTP 400", err)
+	}
+}
+
+func TestStatusCmd_ShowsVersions_When_ResultsVersioned(t *testing.T) {
+	l, db := newTestLedger(t, `{"treatments": {"review": {"version": "prompt-2"}}}`)
+	ctx := context.Background()
+	mustEnqueue(t, db, "review", "/src/a.go", "/src/b.go")
+	if _, err := l.Complete(ctx, doneOptions{Path: "/src/a.go", Treatment: "review", Version: "prompt-1"}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if _, err := l.Complete(ctx, doneOptions{Path: "/src/b.go", Treatment: "review"}); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	out := runCmd(t, statusCmd, "status", "--db", l.Path())
+	if !strings.Contains(out, "prompt-1 (stale)") || !strings.Contains(out, "prompt-2") {
+		t.Fatalf("status output missing versions:\n%s", out)
+	}
+}
+
+func TestClaimCmd_StopsAtConcurrencyCap(t *testing.T) {
+	l, db := newTestLedger(t, `{"treatments": {"heavy": {"concurrency": 2, "timeout": "10m"}}}`)
+	mustEnqueue(t, db, "heavy", "/src/a.go", "/src/b.go", "/src/c.go")
+
+	out := runCmd(t, claimCmd, "claim", "--db", l.Path(), "--treatment", "heavy", "--n", "3")
+	if got := strings.Fields(out); len(got) != 2 {
+		t.Fatalf("claimed %v, want the cap of 2", got)
+	}
+	if out := runCmd(t, claimCmd, "claim", "--db", l.Path(), "--treatment", "heavy"); out != "" {
+		t.Fatalf("claim at the cap printed %q, want nothing", out)
+	}
+
+	out = runCmd(t, statusCmd, "status", "--db", l.Path())
+	if !strings.Contains(out, "RUNNING") || !strings.Contains(out, "heavy                         2          2") {
+		t.Fatalf("status output missing running/max:\n%s", out)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// exitWaitTimeout is claim's exit status when --wait timed out with nothing
+// to claim, distinct from 1 (error) and 2 (usage).
+const exitWaitTimeout = 3
+
+// waitSlice bounds a single remote long-poll so proxies don't cut it off.
+const waitSlice = time.Minute
+
+// waitFlag implements --wait and --wait=DURATION. Bare --wait blocks until
+// something is claimable; a duration bounds the wait.
+type waitFlag struct {
+	set     bool
+	timeout time.Duration
+}
+
+func (w *waitFlag) String() string {
+	if !w.set {
+		return "false"
+	}
+	if w.timeout == 0 {
+		return "true"
+	}
+	return w.timeout.String()
+}
+
+func (w *waitFlag) Set(s string) error {
+	if b, err := strconv.ParseBool(s); err == nil {
+		w.set, w.timeout = b, 0
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("want a positive duration, got %q", s)
+	}
+	w.set, w.timeout = true, d
+	return nil
+}
+
+func (w *waitFlag) IsBoolFlag() bool { return true }
+
+// errWaitTimeout is returned by claim --wait when the wait elapses.
+var errWaitTimeout = errors.New("nothing claimable before --wait timed out")
+
+// claimUntil claims, waiting up to timeout (forever when zero) for something
+// to become claimable. The wait is issued in slices of at most waitSlice so a
+// remote long-poll never outlives proxy or load-balancer timeouts.
+func claimUntil(q queueAPI, opts claimOptions, timeout time.Duration) ([]claimedItem, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		opts.Wait = waitSlice
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return nil, errWaitTimeout
+			}
+			opts.Wait = min(waitSlice, remaining)
+		}
+		items, err := q.Claim(opts)
+		if err != nil || len(items) > 0 {
+			return items, err
+		}
+	}
+}
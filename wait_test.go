@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dkoosis/next/client"
+)
+
+func TestWaitFlag_ParsesBareAndDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		set     bool
+		timeout time.Duration
+		wantErr bool
+	}{
+		{in: "true", set: true},
+		{in: "false"},
+		{in: "30s", set: true, timeout: 30 * time.Second},
+		{in: "-1s", wantErr: true},
+		{in: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		var w waitFlag
+		err := w.Set(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Set(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (w.set != tt.set || w.timeout != tt.timeout) {
+			t.Errorf("Set(%q) = %+v", tt.in, w)
+		}
+	}
+}
+
+func TestServer_WakesWaitingClaim_When_Enqueued(t *testing.T) {
+	c, _, _ := newTestServer(t)
+	ctx := context.Background()
+
+	type result struct {
+		items []client.ClaimedItem
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		items, err := c.Claim(ctx, client.ClaimRequest{Treatment: "lint", Wait: "10s"})
+		done <- result{items, err}
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	if _, err := c.Enqueue(ctx, "lint", []client.EnqueueItem{{Path: "/src/a.go", ContentHash: "h1"}}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	select {
+	case r := <-done:
+		if r.err != nil || len(r.items) != 1 {
+			t.Fatalf("claim = %v, %v", r.items, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting claim was not woken by enqueue")
+	}
+}
+
+func TestClaimCmd_ReturnsWaitTimeout_When_NothingClaimable(t *testing.T) {
+	_, dbPath := newTestDB(t)
+
+	var err error
+	out := runCmd(t, func() { err = doClaimCmd() }, "claim", "--db", dbPath, "--treatment", "lint", "--wait=200ms")
+	if !errors.Is(err, errWaitTimeout) {
+		t.Fatalf("err = %v, want errWaitTimeout", err)
+	}
+	if out != "" {
+		t.Fatalf("printed %q on timeout", out)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dkoosis/next/ledger"
+)
+
+// errWatchUnsupported is returned by newFSWatcher where there is no
+// inotify.
+var errWatchUnsupported = errors.New("next watch needs inotify and only runs on Linux")
+
+// fsWatcher reports changed files under a root: written, created or renamed
+// into place. Paths are absolute; Events closes after Close.
+type fsWatcher interface {
+	Events() <-chan string
+	Errors() <-chan error
+	Close() error
+}
+
+func watchCmd() {
+	if err := doWatchCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doWatchCmd() error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	root := fs.String("root", ".", "directory tree to watch")
+	treatment := fs.String("treatment", "default", "treatment name")
+	quiet := fs.Duration("debounce", 300*time.Millisecond, "wait this long after the last change before re-enqueueing")
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+
+	absRoot, err := filepath.Abs(*root)
+	if err != nil {
+		return fmt.Errorf("path error: %w", err)
+	}
+	absDB, err := filepath.Abs(*dbPath)
+	if err != nil {
+		return fmt.Errorf("path error: %w", err)
+	}
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+	// Fail on an unknown treatment now rather than at the first change.
+	if _, err := q.Reopen(*treatment, nil); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+
+	w, err := newFSWatcher(absRoot, func(p string) bool { return skipWatched(p, absDB) })
+	if err != nil {
+		return err
+	}
+	defer func() { _ = w.Close() }()
+	go func() {
+		for err := range w.Errors() {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}
+	}()
+
+	fmt.Fprintf(os.Stderr, "watching %s for treatment=%s\n", absRoot, *treatment)
+	debounce(w.Events(), *quiet, func(paths []string) {
+		if err := reopenPaths(q, *treatment, paths); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+	})
+	return nil
+}
+
+// skipWatched reports whether p is not worth watching: dot files and
+// directories (.git, .quality, editor swap files), backups ending in ~,
+// and the ledger's own files, whose writes would otherwise feed back.
+func skipWatched(p, dbPath string) bool {
+	base := filepath.Base(p)
+	return strings.HasPrefix(base, ".") || strings.HasSuffix(base, "~") || strings.HasPrefix(p, dbPath)
+}
+
+// debounce gathers paths from events and calls flush with the distinct ones,
+// sorted, once quiet has passed without another event. Pending paths are
+// flushed when events closes.
+func debounce(events <-chan string, quiet time.Duration, flush func([]string)) {
+	pending := map[string]bool{}
+	timer := time.NewTimer(quiet)
+	timer.Stop()
+	defer timer.Stop()
+	emit := func() {
+		if len(pending) == 0 {
+			return
+		}
+		paths := make([]string, 0, len(pending))
+		for p := range pending {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		pending = map[string]bool{}
+		flush(paths)
+	}
+	for {
+		select {
+		case p, ok := <-events:
+			if !ok {
+				emit()
+				return
+			}
+			pending[p] = true
+			timer.Reset(quiet)
+		case <-timer.C:
+			emit()
+		}
+	}
+}
+
+// reopenPaths rehashes the regular files among paths and reopens their rows
+// for treatment. Paths that no longer exist or are directories are skipped.
+func reopenPaths(q queueAPI, treatment string, paths []string) error {
+	var items []enqueueItem
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		ch, err := ledger.FileHash(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: skipping %q: %v\n", p, err)
+			continue
+		}
+		items = append(items, enqueueItem{Path: p, ContentHash: ch})
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	n, err := q.Reopen(treatment, items)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		fmt.Printf("reopened %d paths for treatment=%s\n", n, treatment)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchMask selects writes (on close), creations and renames into a watched
+// directory.
+const watchMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_MOVED_TO
+
+// inotifyWatcher watches every directory under a root with one inotify
+// instance, adding directories as they appear. The descriptor is
+// non-blocking and wrapped in an *os.File so reads go through the runtime
+// poller and Close interrupts them.
+type inotifyWatcher struct {
+	f      *os.File
+	fd     int
+	skip   func(string) bool
+	dirs   map[int]string // watch descriptor -> directory
+	events chan string
+	errs   chan error
+}
+
+func newFSWatcher(root string, skip func(string) bool) (fsWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %w", err)
+	}
+	w := &inotifyWatcher{
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		fd:     fd,
+		skip:   skip,
+		dirs:   map[int]string{},
+		events: make(chan string),
+		errs:   make(chan error, 16),
+	}
+	if err := w.addTree(root, false); err != nil {
+		_ = w.f.Close()
+		return nil, err
+	}
+	go w.read()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Events() <-chan string { return w.events }
+func (w *inotifyWatcher) Errors() <-chan error  { return w.errs }
+func (w *inotifyWatcher) Close() error          { return w.f.Close() }
+
+// addTree watches dir and the directories below it. For a directory that
+// just appeared, emit reports the files already inside, which may have been
+// written before the watch was in place.
+func (w *inotifyWatcher) addTree(dir string, emit bool) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir {
+				return err
+			}
+			return filepath.SkipDir // vanished or unreadable: nothing to watch below
+		}
+		if p != dir && w.skip(p) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			if emit {
+				w.events <- p
+			}
+			return nil
+		}
+		wd, err := unix.InotifyAddWatch(w.fd, p, watchMask)
+		if err != nil {
+			return fmt.Errorf("watch %s: %w", p, err)
+		}
+		w.dirs[wd] = p
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) read() {
+	defer close(w.events)
+	defer close(w.errs)
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return // closed
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			wd := int(int32(binary.NativeEndian.Uint32(buf[off:])))
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			name := string(bytes.TrimRight(buf[off+unix.SizeofInotifyEvent:off+unix.SizeofInotifyEvent+nameLen], "\x00"))
+			off += unix.SizeofInotifyEvent + nameLen
+			w.handle(wd, mask, name)
+		}
+	}
+}
+
+func (w *inotifyWatcher) handle(wd int, mask uint32, name string) {
+	switch {
+	case mask&unix.IN_Q_OVERFLOW != 0:
+		w.warn(fmt.Errorf("inotify queue overflowed; some changes were missed"))
+		return
+	case mask&unix.IN_IGNORED != 0:
+		delete(w.dirs, wd)
+		return
+	}
+	dir, ok := w.dirs[wd]
+	if !ok || name == "" {
+		return
+	}
+	p := filepath.Join(dir, name)
+	if w.skip(p) {
+		return
+	}
+	if mask&unix.IN_ISDIR != 0 {
+		if mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+			if err := w.addTree(p, true); err != nil {
+				w.warn(err)
+			}
+		}
+		return
+	}
+	w.events <- p
+}
+
+// warn reports a non-fatal error, dropping it if nobody is reading.
+func (w *inotifyWatcher) warn(err error) {
+	select {
+	case w.errs <- err:
+	default:
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInotifyWatcher_ReportsChanges_When_FilesWrittenOrMoved(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	w, err := newFSWatcher(root, func(p string) bool { return skipWatched(p, filepath.Join(root, "ledger.db")) })
+	if err != nil {
+		t.Fatalf("newFSWatcher: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	write := func(p string) {
+		t.Helper()
+		if err := os.WriteFile(p, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(root, "a.go"))
+	write(filepath.Join(root, ".git", "index"))
+	write(filepath.Join(root, "ledger.db"))
+	write(filepath.Join(root, ".tmp"))
+	if err := os.Rename(filepath.Join(root, ".tmp"), filepath.Join(root, "b.go")); err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(root, "pkg")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join(sub, "c.go"))
+
+	want := map[string]bool{
+		filepath.Join(root, "a.go"): true,
+		filepath.Join(root, "b.go"): true,
+		filepath.Join(sub, "c.go"):  true,
+	}
+	timeout := time.After(5 * time.Second)
+	for len(want) > 0 {
+		select {
+		case p := <-w.Events():
+			if filepath.Base(p) == "index" || filepath.Base(p) == "ledger.db" {
+				t.Fatalf("reported skipped path %s", p)
+			}
+			delete(want, p)
+		case <-timeout:
+			t.Fatalf("no events for %v", want)
+		}
+	}
+
+	_ = w.Close()
+	for range w.Events() {
+	}
+}
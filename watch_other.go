@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func newFSWatcher(string, func(string) bool) (fsWatcher, error) {
+	return nil, errWatchUnsupported
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dkoosis/next/ledger"
+)
+
+func TestDebounce_CoalescesBurst_When_EventsRepeat(t *testing.T) {
+	events := make(chan string)
+	var flushes [][]string
+	done := make(chan struct{})
+	go func() {
+		debounce(events, 100*time.Millisecond, func(paths []string) { flushes = append(flushes, paths) })
+		close(done)
+	}()
+
+	for _, p := range []string{"/src/b.go", "/src/a.go", "/src/b.go"} {
+		events <- p
+	}
+	time.Sleep(300 * time.Millisecond)
+	events <- "/src/c.go"
+	close(events)
+	<-done
+
+	want := [][]string{{"/src/a.go", "/src/b.go"}, {"/src/c.go"}}
+	if !reflect.DeepEqual(flushes, want) {
+		t.Fatalf("flushes = %v, want %v", flushes, want)
+	}
+}
+
+func TestReopenPaths_ReopensRow_When_ContentChanged(t *testing.T) {
+	l, _ := newTestLedger(t, "")
+	ctx := t.Context()
+	dir := t.TempDir()
+	edited, same := filepath.Join(dir, "edited.go"), filepath.Join(dir, "same.go")
+	for _, p := range []string{edited, same} {
+		if err := os.WriteFile(p, []byte("package x\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q := localQueue{l: l}
+	if err := reopenPaths(q, "lint", []string{edited, same}); err != nil {
+		t.Fatalf("reopenPaths: %v", err)
+	}
+	for _, p := range []string{edited, same} {
+		if _, err := l.Complete(ctx, doneOptions{Path: p, Treatment: "lint", Result: "ok"}); err != nil {
+			t.Fatalf("Complete: %v", err)
+		}
+	}
+	if err := os.WriteFile(edited, []byte("package x\n\nvar y = 1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t, func() {
+		if err := reopenPaths(q, "lint", []string{edited, same, filepath.Join(dir, "deleted.go"), dir}); err != nil {
+			t.Errorf("reopenPaths: %v", err)
+		}
+	})
+	if out != "reopened 1 paths for treatment=lint\n" {
+		t.Fatalf("output = %q", out)
+	}
+	pending, err := l.List(ctx, listOptions{Treatment: "lint", State: "pending"})
+	if err != nil || len(pending) != 1 || pending[0].Path != edited {
+		t.Fatalf("pending = %+v, %v; want only the edited file", pending, err)
+	}
+	if want, _ := ledger.FileHash(edited); pending[0].ContentHash != want {
+		t.Fatalf("content hash = %s, want the rehashed %s", pending[0].ContentHash, want)
+	}
+}
+
+func TestSkipWatched_SkipsDotFilesAndLedger(t *testing.T) {
+	for p, want := range map[string]bool{
+		"/proj/main.go":            false,
+		"/proj/.git":               true,
+		"/proj/.main.go.swp":       true,
+		"/proj/main.go~":           true,
+		"/proj/data/ledger.db":     true,
+		"/proj/data/ledger.db-wal": true,
+		"/proj/data/other.db":      false,
+	} {
+		if got := skipWatched(p, "/proj/data/ledger.db"); got != want {
+			t.Errorf("skipWatched(%s) = %v, want %v", p, got, want)
+		}
+	}
+}
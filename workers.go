@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dkoosis/next/client"
+	"github.com/dkoosis/next/ledger"
+)
+
+func workersCmd() {
+	if err := doWorkersCmd(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+func doWorkersCmd() error {
+	fs := flag.NewFlagSet("workers", flag.ExitOnError)
+	treatment := fs.String("treatment", "", "filter by treatment (empty = all)")
+	window := fs.Duration("window", 15*time.Minute, "throughput is done runs per minute over this window")
+	stale := fs.Duration("stale", time.Hour, "flag unleased claims older than this; leased claims are stale once the lease expires")
+	dbPath := fs.String("db", defaultDBPath, "database path")
+	server := serverFlag(fs)
+	_ = fs.Parse(os.Args[2:])
+	if *window <= 0 || *stale <= 0 {
+		return fmt.Errorf("error: --window and --stale must be positive")
+	}
+
+	q, err := openQueue(*dbPath, *server)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = q.Close() }()
+
+	workers, err := q.Workers(ledger.WorkersOptions{Treatment: *treatment, Window: *window, Stale: *stale})
+	if err != nil {
+		return fmt.Errorf("query error: %w", err)
+	}
+	printWorkers(workers, *window)
+	return nil
+}
+
+// printWorkers prints one line per worker, marking those holding stale
+// claims.
+func printWorkers(workers []client.Worker, window time.Duration) {
+	fmt.Printf("%-28s %7s %7s %8s %7s %8s  %s\n", "WORKER", "ACTIVE", "STALE", "DONE", "FAILED", "PER MIN", "LAST SEEN")
+	for _, w := range workers {
+		mark := ""
+		if w.Stale > 0 {
+			mark = "  STALE"
+		}
+		rate := float64(w.Recent) / window.Minutes()
+		fmt.Printf("%-28s %7d %7d %8d %7d %8.2f  %s%s\n", w.Worker, w.Active, w.Stale, w.Done, w.Failed, rate, w.LastSeen, mark)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWorkersCmd_ReportsClaimsByWorker(t *testing.T) {
+	tmpDir, restore := setupWorkDir(t, true)
+	defer restore()
+
+	dbPath := filepath.Join(tmpDir, "ledger.db")
+	a, b := filepath.Join(tmpDir, "a.go"), filepath.Join(tmpDir, "b.go")
+	seedQueue(t, dbPath, "lint", a, b)
+
+	first := strings.TrimSpace(runCmd(t, claimCmd, "claim", "--db", dbPath, "--treatment", "lint", "--worker", "ci-1"))
+	runCmd(t, doneCmd, "done", "--db", dbPath, "--treatment", "lint", "--path", first)
+	runCmd(t, claimCmd, "claim", "--db", dbPath, "--treatment", "lint", "--worker", "ci-2", "--lease", "1ns")
+
+	out := runCmd(t, workersCmd, "workers", "--db", dbPath, "--window", "10m")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("workers output = %q, want a header and two workers", out)
+	}
+	if f := strings.Fields(lines[1]); f[0] != "ci-1" || f[1] != "0" || f[3] != "1" || f[5] != "0.10" {
+		t.Fatalf("ci-1 line = %q, want no active claims, 1 done at 0.10/min", lines[1])
+	}
+	if f := strings.Fields(lines[2]); f[0] != "ci-2" || f[1] != "1" || f[2] != "1" || f[len(f)-1] != "STALE" {
+		t.Fatalf("ci-2 line = %q, want its expired lease flagged stale", lines[2])
+	}
+}